@@ -0,0 +1,75 @@
+// migrate-usage はスプレッドシートの使用量をSQLiteに取り込む一度きりのコマンド
+//
+//	go run ./cmd/migrate-usage -sqlite ./slack-gpt-bot.db
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/gs1068/slack-gpt-bot/config"
+	"github.com/gs1068/slack-gpt-bot/infrastructure/spreadsheet"
+	"github.com/gs1068/slack-gpt-bot/infrastructure/sqlite"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	sqlitePath = flag.String("sqlite", "", "取り込み先のSQLiteファイル（未指定の場合は SQLITE_PATH）")
+	dryRun     = flag.Bool("dry-run", false, "書き込まずに取り込む行を表示する")
+)
+
+func main() {
+	flag.Parse()
+
+	config.LoadEnv()
+	spreadsheet.SpreadsheetID = os.Getenv("SPREADSHEET_ID")
+	if *sqlitePath == "" {
+		*sqlitePath = config.GetEnvString("SQLITE_PATH", sqlite.DefaultPath)
+	}
+
+	if err := run(context.Background()); err != nil {
+		log.Fatal().Err(err).Msg("failed to migrate usage")
+	}
+}
+
+func run(ctx context.Context) error {
+	ssClient, err := spreadsheet.SpreadSheetClient()
+	if err != nil {
+		return fmt.Errorf("failed spreadsheet.SpreadSheetClient: %w", err)
+	}
+	source := spreadsheet.NewSpreadsheetRepository(ssClient)
+
+	usages, err := source.ListUsage(ctx)
+	if err != nil {
+		return fmt.Errorf("failed source.ListUsage: %w", err)
+	}
+	if *dryRun {
+		for _, usage := range usages {
+			fmt.Printf("%+v\n", usage)
+		}
+		log.Info().Int("rows", len(usages)).Msg("dry run")
+		return nil
+	}
+
+	db, err := sqlite.Open(*sqlitePath)
+	if err != nil {
+		return fmt.Errorf("failed sqlite.Open: %w", err)
+	}
+	defer db.Close()
+
+	dest, err := sqlite.NewUsageRepository(ctx, db)
+	if err != nil {
+		return fmt.Errorf("failed sqlite.NewUsageRepository: %w", err)
+	}
+	// 同じユーザーの行は上書きするため、何度実行しても結果は変わらない
+	for _, usage := range usages {
+		if err := dest.UpdateUsage(ctx, usage); err != nil {
+			return fmt.Errorf("failed dest.UpdateUsage for user %s: %w", usage.UserID, err)
+		}
+	}
+
+	log.Info().Int("rows", len(usages)).Str("sqlite", *sqlitePath).Msg("usage migrated")
+	return nil
+}
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// GetEnvString 環境変数を取得する。未設定の場合はデフォルト値を返す
+func GetEnvString(key string, defaultValue string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return defaultValue
+}
+
+// GetEnvInt 環境変数を整数として取得する。未設定または不正な値の場合はデフォルト値を返す
+func GetEnvInt(key string, defaultValue int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return defaultValue
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		log.Warn().Err(err).Str("key", key).Msg("invalid int env, using default")
+		return defaultValue
+	}
+	return i
+}
+
+// GetEnvList カンマ区切りの環境変数を取得する。空の項目は無視する
+func GetEnvList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// GetEnvFloat 環境変数を小数として取得する。未設定または不正な値の場合はデフォルト値を返す
+func GetEnvFloat(key string, defaultValue float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return defaultValue
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		log.Warn().Err(err).Str("key", key).Msg("invalid float env, using default")
+		return defaultValue
+	}
+	return f
+}
+
+// GetEnvMap "key1=value1,key2=value2" 形式の環境変数を取得する。不正な項目は無視する
+func GetEnvMap(key string) map[string]string {
+	m := make(map[string]string)
+	for _, pair := range GetEnvList(key) {
+		k, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			log.Warn().Str("key", key).Str("pair", pair).Msg("invalid map env entry, skipping")
+			continue
+		}
+		m[strings.TrimSpace(k)] = strings.TrimSpace(value)
+	}
+	return m
+}
+
+// GetEnvIntMap "key1=100,key2=200" 形式の環境変数を取得する。不正な項目は無視する
+func GetEnvIntMap(key string) map[string]int {
+	m := make(map[string]int)
+	v := os.Getenv(key)
+	if v == "" {
+		return m
+	}
+	for _, pair := range strings.Split(v, ",") {
+		k, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			log.Warn().Str("key", key).Str("pair", pair).Msg("invalid map env entry, skipping")
+			continue
+		}
+		i, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			log.Warn().Err(err).Str("key", key).Str("pair", pair).Msg("invalid map env entry, skipping")
+			continue
+		}
+		m[strings.TrimSpace(k)] = i
+	}
+	return m
+}
+
+// GetEnvBool 環境変数を真偽値として取得する。未設定または不正な値の場合はデフォルト値を返す
+func GetEnvBool(key string, defaultValue bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Warn().Err(err).Str("key", key).Msg("invalid bool env, using default")
+		return defaultValue
+	}
+	return b
+}
+
+// GetEnvDuration 環境変数を time.Duration（例: "30s"）として取得する。未設定または不正な値の場合はデフォルト値を返す
+func GetEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Warn().Err(err).Str("key", key).Msg("invalid duration env, using default")
+		return defaultValue
+	}
+	return d
+}
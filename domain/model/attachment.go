@@ -0,0 +1,221 @@
+package model
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// ImageTokenEstimate 画像1枚がプロンプトで消費するトークン数の目安（1024x1024の画像を細部まで読む場合）
+	ImageTokenEstimate = 765
+)
+
+// MaxImageAttachmentBytes 読み取る添付画像の最大サイズ
+var MaxImageAttachmentBytes = 5 << 20
+
+// MaxImageAttachments 1回の応答で読み取る添付画像の枚数の上限。超えた場合は新しい画像を優先する
+var MaxImageAttachments = 4
+
+// MaxTextAttachmentBytes プロンプトに含めるテキストの添付ファイル1件あたりの最大サイズ。超えた分は省略する
+// 0以下の場合はダウンロードせず、内容を含めない設定のため省略したことだけを伝える
+var MaxTextAttachmentBytes = 8000
+
+// MaxTextAttachments 1回の応答で読み込むテキストの添付ファイルの件数の上限。超えた場合は新しいファイルを優先する
+var MaxTextAttachments = 5
+
+// MaxTextAttachmentDownloadBytes ダウンロードするテキストの添付ファイルの最大サイズ。超える場合は読まずに省略する
+var MaxTextAttachmentDownloadBytes = 1 << 20
+
+// VisionModels 画像を読み取れるモデル。モデル名と一致するか、日付を付けたモデル名（gpt-4o-2024-08-06 など）を対象にする
+// 同じ系列でも画像を読み取れないモデル（o1-mini や o1-preview など）があるため、前方一致では探さない
+var VisionModels = []string{
+	"gpt-4o", "gpt-4o-mini",
+	"gpt-4.1", "gpt-4.1-mini", "gpt-4.1-nano",
+	"gpt-4-turbo",
+	"gpt-5", "gpt-5-mini", "gpt-5-nano",
+	"o1", "o3", "o4-mini",
+}
+
+// modelDateSuffix モデル名に付く日付（-2024-08-06 など）
+var modelDateSuffix = regexp.MustCompile(`^-\d{4}-\d{2}-\d{2}$`)
+
+// visionImageTypes OpenAIのAPIが読み取れる画像の種類
+var visionImageTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// SupportsVision モデルが画像を読み取れるかどうか
+func SupportsVision(modelName string) bool {
+	for _, name := range VisionModels {
+		if modelName == name {
+			return true
+		}
+		if strings.HasPrefix(modelName, name) && modelDateSuffix.MatchString(modelName[len(name):]) {
+			return true
+		}
+	}
+	return false
+}
+
+// textMimeTypes text/ 以外でテキストとして読むMIMEタイプ
+var textMimeTypes = map[string]bool{
+	"application/json":   true,
+	"application/xml":    true,
+	"application/x-yaml": true,
+	"application/yaml":   true,
+	"application/toml":   true,
+	"application/x-sh":   true,
+}
+
+// textExtensions MIMEタイプが判定されていない場合にテキストとして読むファイルの拡張子
+var textExtensions = map[string]bool{
+	".txt": true, ".log": true, ".json": true, ".yaml": true, ".yml": true, ".toml": true,
+	".xml": true, ".csv": true, ".tsv": true, ".md": true, ".ini": true, ".conf": true,
+	".env": true, ".sql": true, ".diff": true, ".patch": true,
+}
+
+// SlackAttachment メッセージに添付されたファイル
+type SlackAttachment struct {
+	ID       string
+	Name     string
+	MimeType string
+	Mode     string // Slackのスニペットの場合は "snippet"
+	Size     int
+	URL      string // ダウンロードにはBotのトークンが必要
+}
+
+// IsImage GPTが読み取れる種類の画像かどうか
+func (a SlackAttachment) IsImage() bool {
+	return visionImageTypes[a.MimeType]
+}
+
+// IsText スニペットやログ・設定ファイルなど、プロンプトに含められるテキストかどうか
+func (a SlackAttachment) IsText() bool {
+	if a.Mode == "snippet" || strings.HasPrefix(a.MimeType, "text/") || textMimeTypes[a.MimeType] {
+		return true
+	}
+	return textExtensions[strings.ToLower(path.Ext(a.Name))]
+}
+
+// TextAttachment プロンプトに含めるテキストの添付ファイル
+type TextAttachment struct {
+	Name      string
+	Content   string
+	Truncated bool // MaxTextAttachmentBytes を超えた分を省略したか
+}
+
+// NewTextAttachment 添付ファイルの内容を MaxTextAttachmentBytes までに切り詰める
+// テキストでない場合と、MaxTextAttachmentBytes が0以下で内容を含めない場合は false を返す
+func NewTextAttachment(name string, data []byte) (TextAttachment, bool) {
+	limit := MaxTextAttachmentBytes
+	if limit <= 0 || !utf8.Valid(data) {
+		return TextAttachment{}, false
+	}
+
+	attachment := TextAttachment{Name: name, Content: string(data)}
+	if len(data) > limit {
+		// 文字の途中で切らないよう、先頭のバイト数以内の文字の境界で切る
+		end := limit
+		for end > 0 && !utf8.RuneStart(data[end]) {
+			end--
+		}
+		attachment.Content = string(data[:end])
+		attachment.Truncated = true
+	}
+	return attachment, true
+}
+
+// Block 発言に続けてプロンプトに含めるコードブロック
+// 内容にバッククォートの並びが含まれていても閉じないよう、それより長いフェンスを使う
+func (a TextAttachment) Block() string {
+	fence := strings.Repeat("`", max(3, longestBacktickRun(a.Content)+1))
+	content := strings.TrimRight(a.Content, "\n")
+	if a.Truncated {
+		content += "\n" + truncatedTextMarker
+	}
+	return fmt.Sprintf("[添付ファイル %s]\n%s\n%s\n%s", a.Name, fence, content, fence)
+}
+
+// truncatedTextMarker 切り詰めたテキストの添付ファイルの末尾に加える印
+const truncatedTextMarker = "(以下省略)"
+
+func longestBacktickRun(s string) int {
+	longest, run := 0, 0
+	for _, r := range s {
+		if r != '`' {
+			run = 0
+			continue
+		}
+		run++
+		longest = max(longest, run)
+	}
+	return longest
+}
+
+// ChatImage GPTに送る画像
+type ChatImage struct {
+	ContentType string
+	Data        []byte
+}
+
+// NewChatImage 画像の内容から種類を判定する。GPTが読み取れない種類の場合は false を返す
+func NewChatImage(data []byte) (ChatImage, bool) {
+	contentType := http.DetectContentType(data)
+	if !visionImageTypes[contentType] {
+		return ChatImage{}, false
+	}
+	return ChatImage{ContentType: contentType, Data: data}, true
+}
+
+// DataURL 画像をAPIに直接渡すための data URL
+func (i ChatImage) DataURL() string {
+	return "data:" + i.ContentType + ";base64," + base64.StdEncoding.EncodeToString(i.Data)
+}
+
+// UnsupportedImageNote 画像を読み取れないモデルの場合に添付画像の代わりにメッセージに加える注記
+func UnsupportedImageNote(name string) string {
+	return fmt.Sprintf("[添付画像 %s: このモデルは画像を読み取れないため省略しました]", name)
+}
+
+// OversizedImageNote MaxImageAttachmentBytes を超える添付画像の代わりに加える注記
+func OversizedImageNote(name string) string {
+	return fmt.Sprintf("[添付画像 %s: サイズが大きすぎるため省略しました]", name)
+}
+
+// SkippedImageNote MaxImageAttachments を超えた古い添付画像の代わりに加える注記
+func SkippedImageNote(name string) string {
+	return fmt.Sprintf("[添付画像 %s: 枚数の上限を超えたため省略しました]", name)
+}
+
+// UnreadableImageNote ダウンロードできなかった添付画像の代わりに加える注記
+func UnreadableImageNote(name string) string {
+	return fmt.Sprintf("[添付画像 %s: 読み込めませんでした]", name)
+}
+
+// OversizedTextNote MaxTextAttachmentDownloadBytes を超えるテキストの添付ファイルの代わりに加える注記
+func OversizedTextNote(name string) string {
+	return fmt.Sprintf("[添付ファイル %s: サイズが大きすぎるため省略しました]", name)
+}
+
+// OmittedTextNote MaxTextAttachmentBytes が0以下で内容を含めない場合に、テキストの添付ファイルの代わりに加える注記
+func OmittedTextNote(name string) string {
+	return fmt.Sprintf("[添付ファイル %s: 内容は省略しました]", name)
+}
+
+// SkippedTextNote MaxTextAttachments を超えた古いテキストの添付ファイルの代わりに加える注記
+func SkippedTextNote(name string) string {
+	return fmt.Sprintf("[添付ファイル %s: 件数の上限を超えたため省略しました]", name)
+}
+
+// UnreadableTextNote 読み込めなかった、またはテキストでなかった添付ファイルの代わりに加える注記
+func UnreadableTextNote(name string) string {
+	return fmt.Sprintf("[添付ファイル %s: 読み込めませんでした]", name)
+}
@@ -0,0 +1,149 @@
+package model
+
+import "testing"
+
+func TestSupportsVision(t *testing.T) {
+	tests := []struct {
+		model string
+		want  bool
+	}{
+		{model: "gpt-4o", want: true},
+		{model: "gpt-4o-mini-2024-07-18", want: true},
+		{model: "gpt-4o-2024-08-06", want: true},
+		{model: "o1", want: true},
+		{model: "o1-2024-12-17", want: true},
+		{model: "o1-mini", want: false},
+		{model: "o1-preview-2024-09-12", want: false},
+		{model: "o3-mini", want: false},
+		{model: "gpt-4-turbo-preview", want: false},
+		{model: "gpt-3.5-turbo", want: false},
+		{model: "gpt-4", want: false},
+		{model: "llama3", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := SupportsVision(tt.model); got != tt.want {
+			t.Errorf("SupportsVision(%q) = %v, want %v", tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestNewChatImage(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        []byte
+		wantOK      bool
+		wantDataURL string
+	}{
+		{
+			name:        "png",
+			data:        []byte("\x89PNG\r\n\x1a\n"),
+			wantOK:      true,
+			wantDataURL: "data:image/png;base64,iVBORw0KGgo=",
+		},
+		{
+			// Slackの権限が足りない場合はログイン画面のHTMLが返ってくる
+			name:   "html",
+			data:   []byte("<!DOCTYPE html><html></html>"),
+			wantOK: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			image, ok := NewChatImage(tt.data)
+			if ok != tt.wantOK {
+				t.Fatalf("NewChatImage() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && image.DataURL() != tt.wantDataURL {
+				t.Errorf("DataURL() = %q, want %q", image.DataURL(), tt.wantDataURL)
+			}
+		})
+	}
+}
+
+func TestIsText(t *testing.T) {
+	tests := []struct {
+		name       string
+		attachment SlackAttachment
+		want       bool
+	}{
+		{name: "snippet", attachment: SlackAttachment{Name: "Untitled", MimeType: "application/octet-stream", Mode: "snippet"}, want: true},
+		{name: "plain text", attachment: SlackAttachment{Name: "a.txt", MimeType: "text/plain"}, want: true},
+		{name: "json", attachment: SlackAttachment{Name: "config.json", MimeType: "application/json"}, want: true},
+		{name: "log by extension", attachment: SlackAttachment{Name: "app.LOG", MimeType: "application/octet-stream"}, want: true},
+		{name: "image", attachment: SlackAttachment{Name: "a.png", MimeType: "image/png"}, want: false},
+		{name: "pdf", attachment: SlackAttachment{Name: "a.pdf", MimeType: "application/pdf"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.attachment.IsText(); got != tt.want {
+				t.Errorf("IsText() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewTextAttachmentWithoutContent(t *testing.T) {
+	original := MaxTextAttachmentBytes
+	t.Cleanup(func() { MaxTextAttachmentBytes = original })
+
+	for _, limit := range []int{0, -1} {
+		MaxTextAttachmentBytes = limit
+		attachment, ok := NewTextAttachment("app.log", []byte("secret\n"))
+		if ok || attachment.Content != "" {
+			t.Errorf("NewTextAttachment() with limit %d = %+v, %v, want no attachment", limit, attachment, ok)
+		}
+	}
+}
+
+func TestNewTextAttachment(t *testing.T) {
+	original := MaxTextAttachmentBytes
+	MaxTextAttachmentBytes = 10
+	t.Cleanup(func() { MaxTextAttachmentBytes = original })
+
+	tests := []struct {
+		name      string
+		data      []byte
+		wantOK    bool
+		wantBlock string
+	}{
+		{
+			name:      "short text",
+			data:      []byte("ok\n"),
+			wantOK:    true,
+			wantBlock: "[添付ファイル app.log]\n```\nok\n```",
+		},
+		{
+			// 10バイト目が「う」の途中なので、その前で切る
+			name:      "truncated on rune boundary",
+			data:      []byte("abcあいう"),
+			wantOK:    true,
+			wantBlock: "[添付ファイル app.log]\n```\nabcあい\n(以下省略)\n```",
+		},
+		{
+			name:      "fence in content",
+			data:      []byte("```go\n```"),
+			wantOK:    true,
+			wantBlock: "[添付ファイル app.log]\n````\n```go\n```\n````",
+		},
+		{
+			name:   "binary",
+			data:   []byte{0xff, 0xfe, 0x00},
+			wantOK: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attachment, ok := NewTextAttachment("app.log", tt.data)
+			if ok != tt.wantOK {
+				t.Fatalf("NewTextAttachment() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && attachment.Block() != tt.wantBlock {
+				t.Errorf("Block() = %q, want %q", attachment.Block(), tt.wantBlock)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// AuditKind GPTを呼び出した目的
+type AuditKind string
+
+const (
+	AuditKindReply   AuditKind = "reply"   // スレッドへの応答
+	AuditKindSummary AuditKind = "summary" // 省いたターンの要約
+	AuditKindImage   AuditKind = "image"   // 画像の生成
+	AuditKindDebug   AuditKind = "debug"   // 動作確認用のエンドポイント
+)
+
+// PromptAuditMode 監査ログにプロンプトをどう残すか
+type PromptAuditMode string
+
+const (
+	PromptAuditHash   PromptAuditMode = "hash"   // SHA-256のハッシュだけを残す
+	PromptAuditFull   PromptAuditMode = "full"   // プロンプト全文を残す
+	PromptAuditRedact PromptAuditMode = "redact" // 何も残さない
+)
+
+// AuditRecord GPTの呼び出し1回分の監査ログ
+type AuditRecord struct {
+	Time             time.Time `json:"time"`
+	Kind             AuditKind `json:"kind"`
+	ChannelID        string    `json:"channel_id,omitempty"`
+	ThreadTS         string    `json:"thread_ts,omitempty"`
+	UserID           string    `json:"user_id,omitempty"`
+	Model            string    `json:"model"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	LatencyMS        int64     `json:"latency_ms"`
+	FinishReason     string    `json:"finish_reason,omitempty"`
+	Error            string    `json:"error,omitempty"`
+	Prompt           string    `json:"prompt,omitempty"`
+	PromptHash       string    `json:"prompt_hash,omitempty"`
+}
+
+// SetPrompt 設定に応じてプロンプトの全文またはハッシュを記録する
+// プロンプトはシステムプロンプトを含む会話全体をJSONにしたもの
+func (r *AuditRecord) SetPrompt(conversation Conversation, mode PromptAuditMode) {
+	if mode == PromptAuditRedact {
+		return
+	}
+
+	b, err := json.Marshal(struct {
+		System   string        `json:"system"`
+		Messages []ChatMessage `json:"messages"`
+	}{
+		System:   conversation.SystemContent(),
+		Messages: conversation.Messages,
+	})
+	if err != nil {
+		return
+	}
+
+	if mode == PromptAuditFull {
+		r.Prompt = string(b)
+		return
+	}
+	sum := sha256.Sum256(b)
+	r.PromptHash = hex.EncodeToString(sum[:])
+}
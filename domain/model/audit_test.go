@@ -0,0 +1,42 @@
+package model
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAuditRecordSetPrompt(t *testing.T) {
+	conversation := NewConversation("system prompt", "秘密の質問")
+
+	tests := []struct {
+		name       string
+		mode       PromptAuditMode
+		wantPrompt bool
+		wantHash   bool
+	}{
+		{name: "hash", mode: PromptAuditHash, wantHash: true},
+		{name: "full", mode: PromptAuditFull, wantPrompt: true},
+		{name: "redact", mode: PromptAuditRedact},
+		{name: "unknown mode falls back to hash", mode: "invalid", wantHash: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var record AuditRecord
+			record.SetPrompt(conversation, tt.mode)
+
+			if got := record.Prompt != ""; got != tt.wantPrompt {
+				t.Errorf("Prompt = %q, want present: %v", record.Prompt, tt.wantPrompt)
+			}
+			if tt.wantPrompt && !strings.Contains(record.Prompt, "秘密の質問") {
+				t.Errorf("Prompt = %q, want to contain the user message", record.Prompt)
+			}
+			if got := record.PromptHash != ""; got != tt.wantHash {
+				t.Errorf("PromptHash = %q, want present: %v", record.PromptHash, tt.wantHash)
+			}
+			if tt.wantHash && len(record.PromptHash) != 64 {
+				t.Errorf("PromptHash = %q, want a SHA-256 hex digest", record.PromptHash)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package model
+
+const (
+	DefaultChatModel = "gpt-4o"
+	// DefaultContextTokenBudget モデルごとの設定がない場合のプロンプトのトークン上限
+	DefaultContextTokenBudget = 8000
+	// MessageTokenOverhead 1メッセージごとに役割などで消費されるトークン数の目安
+	MessageTokenOverhead = 4
+	// SummaryTokenReserve 古い履歴を切り詰めたときに要約のために確保するトークン数
+	SummaryTokenReserve = 500
+	// minLatestMessageTokens 最新の発言が予算に収まらない場合でも残すトークン数（予算を超える場合は予算まで）
+	minLatestMessageTokens = 100
+	truncatedMarker        = "(前略)"
+)
+
+// ContextTokenBudgets モデルごとのプロンプト（システムプロンプト・要約・履歴）のトークン上限。応答に使うトークンは含まない
+var ContextTokenBudgets = map[string]int{
+	"gpt-4o":        16000,
+	"gpt-4o-mini":   16000,
+	"gpt-4":         6000,
+	"gpt-3.5-turbo": 12000,
+}
+
+// TokenCounter テキストのトークン数を数える
+type TokenCounter interface {
+	CountTokens(text string) int
+}
+
+// ContextTokenBudget モデルのプロンプトのトークン上限を返す
+func ContextTokenBudget(modelName string) int {
+	if budget, ok := ContextTokenBudgets[modelName]; ok {
+		return budget
+	}
+	return DefaultContextTokenBudget
+}
+
+// FitToBudget 会話をトークンの予算内に収める
+// システムプロンプトは必ず残し、新しいターンから順に予算に収まるだけ残す。収まらなかった古いターンを返す
+// Summary にはこれまでの要約を入れておく。古いターンを省く場合は、要約を作り直しても収まるよう
+// 今の要約と SummaryTokenReserve のうち大きい方を確保する。省くターンがない場合は要約を外す
+func (c Conversation) FitToBudget(counter TokenCounter, budget int) (Conversation, []ChatMessage) {
+	used := counter.CountTokens(c.SystemPrompt) + MessageTokenOverhead
+
+	costs := make([]int, len(c.Messages))
+	total := used
+	for i, message := range c.Messages {
+		costs[i] = counter.CountTokens(message.Content) + len(message.Images)*ImageTokenEstimate + MessageTokenOverhead
+		total += costs[i]
+	}
+	if total <= budget {
+		// すべてのターンを送るため、要約は不要
+		c.Summary = ""
+		return c, nil
+	}
+
+	reserve := SummaryTokenReserve
+	if c.Summary != "" {
+		reserve = max(reserve, counter.CountTokens(c.Summary))
+	}
+	used += reserve + counter.CountTokens(summaryHeading)
+
+	// 新しいターンから順に残す
+	start := len(c.Messages)
+	for i := len(c.Messages) - 1; i >= 0; i-- {
+		if used+costs[i] > budget {
+			break
+		}
+		used += costs[i]
+		start = i
+	}
+
+	fitted := c
+	fitted.Messages = append([]ChatMessage{}, c.Messages[start:]...)
+	dropped := append([]ChatMessage{}, c.Messages[:start]...)
+
+	// 最新のターンだけで予算を超える場合は、末尾を残して切り詰める
+	if len(fitted.Messages) == 0 && len(c.Messages) > 0 {
+		latest := c.Messages[len(c.Messages)-1]
+		// 予算が小さい場合も、最新の発言は予算を超えない範囲で残す
+		remaining := max(budget-used-MessageTokenOverhead, min(minLatestMessageTokens, budget-MessageTokenOverhead), 0)
+		latest.Content = truncateHead(counter, latest.Content, remaining)
+		fitted.Messages = []ChatMessage{latest}
+		dropped = dropped[:len(dropped)-1]
+	}
+
+	return fitted, dropped
+}
+
+// truncateHead テキストの末尾を maxTokens に収まるだけ残し、先頭を省略する
+func truncateHead(counter TokenCounter, text string, maxTokens int) string {
+	if counter.CountTokens(text) <= maxTokens {
+		return text
+	}
+
+	runes := []rune(text)
+	// 残す位置を二分探索する（lowは収まらない位置、highは収まる位置）
+	low, high := 0, len(runes)
+	for high-low > 1 {
+		mid := (low + high) / 2
+		if counter.CountTokens(truncatedMarker+string(runes[mid:])) <= maxTokens {
+			high = mid
+		} else {
+			low = mid
+		}
+	}
+	return truncatedMarker + string(runes[high:])
+}
@@ -0,0 +1,189 @@
+package model
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// runeCounter 1文字を1トークンとして数えるテスト用のカウンター
+type runeCounter struct{}
+
+func (runeCounter) CountTokens(text string) int {
+	return len([]rune(text))
+}
+
+func syntheticThread(n int, content string) []ChatMessage {
+	messages := make([]ChatMessage, 0, n)
+	for i := 0; i < n; i++ {
+		role := ChatRoleUser
+		if i%2 == 1 {
+			role = ChatRoleAssistant
+		}
+		messages = append(messages, ChatMessage{Role: role, Content: content})
+	}
+	return messages
+}
+
+func TestFitToBudget(t *testing.T) {
+	// システムプロンプト10トークン + オーバーヘッド4 = 14
+	systemPrompt := strings.Repeat("s", 10)
+	// 古いターンを省く場合は要約の見出しと要約の分を確保する
+	reserve := len([]rune(summaryHeading)) + SummaryTokenReserve
+
+	tests := []struct {
+		name         string
+		conversation Conversation
+		budget       int
+		wantKept     int
+		wantDropped  int
+		wantSummary  string
+	}{
+		{
+			name: "short thread fits entirely",
+			conversation: Conversation{
+				SystemPrompt: systemPrompt,
+				Messages:     syntheticThread(5, strings.Repeat("a", 16)),
+			},
+			budget:      1000,
+			wantKept:    5,
+			wantDropped: 0,
+		},
+		{
+			// 各ターン20トークン。14 + 要約の予約を引いた残りに収まる分だけ残す
+			name: "chatty thread keeps newest turns",
+			conversation: Conversation{
+				SystemPrompt: systemPrompt,
+				Messages:     syntheticThread(100, strings.Repeat("a", 16)),
+			},
+			budget:      14 + reserve + 20*10,
+			wantKept:    10,
+			wantDropped: 90,
+		},
+		{
+			name: "pasted log drops itself and older turns",
+			conversation: Conversation{
+				SystemPrompt: systemPrompt,
+				Messages: append(append(
+					syntheticThread(3, strings.Repeat("a", 16)),
+					ChatMessage{Role: ChatRoleAssistant, Content: strings.Repeat("log\n", 5000)}),
+					syntheticThread(4, strings.Repeat("a", 16))...),
+			},
+			budget:      14 + reserve + 20*5,
+			wantKept:    4,
+			wantDropped: 4,
+		},
+		{
+			// 要約を作り直しても収まるよう、短い要約でも予約分を確保する
+			name: "short existing summary keeps the reserve",
+			conversation: Conversation{
+				SystemPrompt: systemPrompt,
+				Summary:      strings.Repeat("m", 30),
+				Messages:     syntheticThread(100, strings.Repeat("a", 16)),
+			},
+			budget:      14 + reserve + 20*3,
+			wantKept:    3,
+			wantDropped: 97,
+			wantSummary: strings.Repeat("m", 30),
+		},
+		{
+			name: "long existing summary is counted in full",
+			conversation: Conversation{
+				SystemPrompt: systemPrompt,
+				Summary:      strings.Repeat("m", SummaryTokenReserve+100),
+				Messages:     syntheticThread(100, strings.Repeat("a", 16)),
+			},
+			budget:      14 + reserve + 100 + 20*3,
+			wantKept:    3,
+			wantDropped: 97,
+			wantSummary: strings.Repeat("m", SummaryTokenReserve+100),
+		},
+		{
+			name: "summary is removed when every turn fits",
+			conversation: Conversation{
+				SystemPrompt: systemPrompt,
+				Summary:      strings.Repeat("m", 30),
+				Messages:     syntheticThread(3, strings.Repeat("a", 16)),
+			},
+			budget:      1000,
+			wantKept:    3,
+			wantDropped: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, dropped := tt.conversation.FitToBudget(runeCounter{}, tt.budget)
+			if len(got.Messages) != tt.wantKept {
+				t.Errorf("kept = %v, want %v", len(got.Messages), tt.wantKept)
+			}
+			if len(dropped) != tt.wantDropped {
+				t.Errorf("dropped = %v, want %v", len(dropped), tt.wantDropped)
+			}
+			// 残ったターンは元の会話の末尾と一致する
+			original := tt.conversation.Messages
+			for i, message := range got.Messages {
+				if !reflect.DeepEqual(message, original[len(original)-len(got.Messages)+i]) {
+					t.Errorf("kept message %d = %v, want newest turns", i, message)
+				}
+			}
+			if got.SystemPrompt != tt.conversation.SystemPrompt {
+				t.Errorf("system prompt changed")
+			}
+			if got.Summary != tt.wantSummary {
+				t.Errorf("Summary = %q, want %q", got.Summary, tt.wantSummary)
+			}
+		})
+	}
+}
+
+func TestFitToBudgetTruncatesLatestMessage(t *testing.T) {
+	latest := strings.Repeat("x", 1000) + "question?"
+	conversation := Conversation{
+		SystemPrompt: "system",
+		Messages: []ChatMessage{
+			{Role: ChatRoleUser, Content: "old"},
+			{Role: ChatRoleUser, Content: latest},
+		},
+	}
+	reserve := len([]rune(summaryHeading)) + SummaryTokenReserve
+
+	tests := []struct {
+		name      string
+		budget    int
+		maxTokens int
+	}{
+		{
+			name:      "latest message fills the rest of the budget",
+			budget:    10 + reserve + 200,
+			maxTokens: 200 - MessageTokenOverhead,
+		},
+		{
+			name:      "small budget keeps the minimum",
+			budget:    10 + reserve,
+			maxTokens: minLatestMessageTokens,
+		},
+		{
+			name:      "minimum is clamped to a tiny budget",
+			budget:    50,
+			maxTokens: 50 - MessageTokenOverhead,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, dropped := conversation.FitToBudget(runeCounter{}, tt.budget)
+
+			if len(got.Messages) != 1 || len(dropped) != 1 {
+				t.Fatalf("kept = %v, dropped = %v, want 1 and 1", len(got.Messages), len(dropped))
+			}
+			content := got.Messages[0].Content
+			if !strings.HasPrefix(content, truncatedMarker) || !strings.HasSuffix(content, "question?") {
+				t.Errorf("latest message should keep its tail, got %q", content)
+			}
+			if n := (runeCounter{}).CountTokens(content); n > tt.maxTokens {
+				t.Errorf("truncated message has %v tokens, want at most %v", n, tt.maxTokens)
+			}
+		})
+	}
+}
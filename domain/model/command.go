@@ -0,0 +1,84 @@
+package model
+
+import (
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+const (
+	CommandPersona = "persona"
+	CommandModel   = "model"
+	CommandUsage   = "usage"
+	CommandImage   = "image"
+	CommandReset   = "reset"
+	CommandHelp    = "help"
+
+	// channelScopeFlag 設定をチャンネル全体に適用するフラグ
+	channelScopeFlag = "--channel"
+)
+
+const CommandHelpMessage = "*使い方*\n" +
+	"`/gpt persona` 現在のペルソナと選択できるペルソナを表示\n" +
+	"`/gpt persona <名前> [--channel]` ペルソナを切り替える（`--channel` でチャンネル全体に適用）\n" +
+	"`/gpt model` 現在のモデルと選択できるモデルを表示\n" +
+	"`/gpt model <名前> [--channel]` モデルを切り替える\n" +
+	"`/gpt usage` 利用状況を表示\n" +
+	"`/gpt image <説明>` 画像を作成してチャンネルに投稿する\n" +
+	"`/gpt reset [--channel]` 設定を元に戻す\n" +
+	"`/gpt help` このヘルプを表示"
+
+// ChannelScopeDeniedMessage チャンネル全体の設定を変更する権限がない場合のメッセージ
+const ChannelScopeDeniedMessage = "チャンネル全体の設定を変更できるのは、チャンネルの作成者とワークスペースの管理者だけです。"
+
+// SlashCommand Slackのスラッシュコマンド（/gpt）の入力
+type SlashCommand struct {
+	UserID    string
+	ChannelID string
+	Name      string   // サブコマンド
+	Args      []string // サブコマンドの引数（フラグを除く）
+	Scope     PreferenceScope
+}
+
+// CommandResponse スラッシュコマンドの応答。Blocks がある場合は Text は通知用の代替テキストになる
+type CommandResponse struct {
+	Text   string
+	Blocks []slack.Block
+}
+
+// ParseSlashCommand "/gpt" に続くテキストをサブコマンドと引数に分解する
+func ParseSlashCommand(userID string, channelID string, text string) SlashCommand {
+	command := SlashCommand{
+		UserID:    userID,
+		ChannelID: channelID,
+		Name:      CommandHelp,
+		Scope:     PreferenceScopeUser,
+	}
+
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return command
+	}
+	command.Name = strings.ToLower(fields[0])
+	for _, field := range fields[1:] {
+		if field == channelScopeFlag {
+			command.Scope = PreferenceScopeChannel
+			continue
+		}
+		command.Args = append(command.Args, field)
+	}
+	return command
+}
+
+// ImagePrompt /gpt image に続く画像の説明
+func (c SlashCommand) ImagePrompt() string {
+	return strings.Join(c.Args, " ")
+}
+
+// PreferenceID 設定の対象となるユーザーIDまたはチャンネルID
+func (c SlashCommand) PreferenceID() string {
+	if c.Scope == PreferenceScopeChannel {
+		return c.ChannelID
+	}
+	return c.UserID
+}
@@ -0,0 +1,91 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSlashCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want SlashCommand
+	}{
+		{
+			name: "empty text shows help",
+			text: "",
+			want: SlashCommand{UserID: "U1", ChannelID: "C1", Name: CommandHelp, Scope: PreferenceScopeUser},
+		},
+		{
+			name: "user scope",
+			text: "persona sisters",
+			want: SlashCommand{UserID: "U1", ChannelID: "C1", Name: CommandPersona, Args: []string{"sisters"}, Scope: PreferenceScopeUser},
+		},
+		{
+			name: "channel scope",
+			text: "Model  gpt-4o-mini --channel",
+			want: SlashCommand{UserID: "U1", ChannelID: "C1", Name: CommandModel, Args: []string{"gpt-4o-mini"}, Scope: PreferenceScopeChannel},
+		},
+		{
+			name: "image prompt",
+			text: "image 夕焼けの 海",
+			want: SlashCommand{UserID: "U1", ChannelID: "C1", Name: CommandImage, Args: []string{"夕焼けの", "海"}, Scope: PreferenceScopeUser},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseSlashCommand("U1", "C1", tt.text)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseSlashCommand() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyPreferences(t *testing.T) {
+	personas := map[string]Persona{
+		"assistant": {Name: "assistant", Model: "gpt-4o"},
+		"incident":  {Name: "incident", Model: "gpt-4o"},
+	}
+	lookup := func(name string) (Persona, bool) {
+		p, ok := personas[name]
+		return p, ok
+	}
+
+	tests := []struct {
+		name        string
+		preferences []*Preference
+		want        Persona
+	}{
+		{
+			name: "no preferences",
+			want: personas["assistant"],
+		},
+		{
+			name: "user preference wins over channel preference",
+			preferences: []*Preference{
+				{Scope: PreferenceScopeChannel, Persona: "incident", Model: "gpt-4o-mini"},
+				{Scope: PreferenceScopeUser, Model: "gpt-4o"},
+			},
+			want: Persona{Name: "incident", Model: "gpt-4o"},
+		},
+		{
+			name: "unknown persona is ignored",
+			preferences: []*Preference{
+				nil,
+				{Scope: PreferenceScopeUser, Persona: "missing", Model: "gpt-4o-mini"},
+			},
+			want: Persona{Name: "assistant", Model: "gpt-4o-mini"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ApplyPreferences(personas["assistant"], lookup, tt.preferences...)
+			if got != tt.want {
+				t.Errorf("ApplyPreferences() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
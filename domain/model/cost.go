@@ -0,0 +1,104 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ModelPrice 100万トークンあたりの料金（USD）
+type ModelPrice struct {
+	Prompt     float64
+	Completion float64
+}
+
+// ModelPrices モデルごとの料金。日付付きのモデル名（gpt-4o-2024-08-06 など）は前方一致で探す
+var ModelPrices = map[string]ModelPrice{
+	"gpt-4o":      {Prompt: 2.5, Completion: 10},
+	"gpt-4o-mini": {Prompt: 0.15, Completion: 0.6},
+}
+
+// DefaultModelPrice 料金が登録されていないモデルに使う料金
+var DefaultModelPrice = ModelPrice{Prompt: 2.5, Completion: 10}
+
+// PriceOf モデルの料金を返す。前方一致するモデルが複数ある場合は最も長い名前を優先する
+func PriceOf(modelName string) ModelPrice {
+	if price, ok := lookupByPrefix(ModelPrices, modelName); ok {
+		return price
+	}
+	return DefaultModelPrice
+}
+
+// lookupByPrefix モデル名に前方一致する項目を返す。複数ある場合は最も長い名前を優先する
+func lookupByPrefix[V any](table map[string]V, modelName string) (V, bool) {
+	if v, ok := table[modelName]; ok {
+		return v, true
+	}
+	var matched string
+	for name := range table {
+		if strings.HasPrefix(modelName, name) && len(name) > len(matched) {
+			matched = name
+		}
+	}
+	v, ok := table[matched]
+	return v, ok && matched != ""
+}
+
+// ParseModelPrice "2.5:10"（プロンプト:応答）形式の料金を解析する
+func ParseModelPrice(s string) (ModelPrice, error) {
+	prompt, completion, ok := strings.Cut(s, ":")
+	if !ok {
+		return ModelPrice{}, fmt.Errorf("invalid model price: %s", s)
+	}
+	p, err := strconv.ParseFloat(strings.TrimSpace(prompt), 64)
+	if err != nil {
+		return ModelPrice{}, fmt.Errorf("invalid prompt price: %w", err)
+	}
+	c, err := strconv.ParseFloat(strings.TrimSpace(completion), 64)
+	if err != nil {
+		return ModelPrice{}, fmt.Errorf("invalid completion price: %w", err)
+	}
+	return ModelPrice{Prompt: p, Completion: c}, nil
+}
+
+// TokenUsage 1回のリクエストで使用したトークン数
+type TokenUsage struct {
+	Model            string
+	PromptTokens     int
+	CompletionTokens int
+	Images           int  // 生成した画像の枚数。予約した画像を払い戻す場合は負の値
+	Reservation      bool // 画像の予約とその取り消し。枚数だけを加算し、リクエストとして数えない
+	Supplementary    bool // 要約など、応答とは別の呼び出しの使用量。使ったモデルの料金で加算し、リクエストとして数えない
+}
+
+// ImageReservation 作成する前に count 枚の画像を確保する使用量
+func ImageReservation(modelName string, count int) TokenUsage {
+	return TokenUsage{Model: modelName, Images: count, Reservation: true}
+}
+
+// ImageCancellation 上限を超えたため確保した count 枚の画像を取り消す使用量
+func ImageCancellation(modelName string, count int) TokenUsage {
+	return TokenUsage{Model: modelName, Images: -count, Reservation: true}
+}
+
+// ImageSettlement 確保した reserved 枚のうち generated 枚を作成したリクエストの使用量
+// リクエストとして1回数え、作成しなかった分を払い戻す
+func ImageSettlement(modelName string, reserved int, generated int) TokenUsage {
+	return TokenUsage{Model: modelName, Images: generated - reserved}
+}
+
+func (u TokenUsage) TotalTokens() int {
+	return u.PromptTokens + u.CompletionTokens
+}
+
+// IsZero トークンも画像も使っていないか
+func (u TokenUsage) IsZero() bool {
+	return u.TotalTokens() == 0 && u.Images == 0
+}
+
+// CostUSD モデルの料金から計算した費用
+func (u TokenUsage) CostUSD() float64 {
+	price := PriceOf(u.Model)
+	cost := (float64(u.PromptTokens)*price.Prompt + float64(u.CompletionTokens)*price.Completion) / 1_000_000
+	return cost + float64(u.Images)*ImagePriceOf(u.Model)
+}
@@ -0,0 +1,52 @@
+package model
+
+import "testing"
+
+func TestPriceOf(t *testing.T) {
+	tests := []struct {
+		model string
+		want  ModelPrice
+	}{
+		{model: "gpt-4o", want: ModelPrices["gpt-4o"]},
+		{model: "gpt-4o-mini-2024-07-18", want: ModelPrices["gpt-4o-mini"]},
+		{model: "gpt-4o-2024-08-06", want: ModelPrices["gpt-4o"]},
+		{model: "unknown-model", want: DefaultModelPrice},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			if got := PriceOf(tt.model); got != tt.want {
+				t.Errorf("PriceOf(%q) = %+v, want %+v", tt.model, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImagePriceOf(t *testing.T) {
+	tests := []struct {
+		model string
+		want  float64
+	}{
+		{model: "dall-e-3", want: ImagePrices["dall-e-3"]},
+		{model: "gpt-image-1-2025-04-23", want: ImagePrices["gpt-image-1"]},
+		{model: "unknown-model", want: DefaultImagePrice},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			if got := ImagePriceOf(tt.model); got != tt.want {
+				t.Errorf("ImagePriceOf(%q) = %v, want %v", tt.model, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseModelPrice(t *testing.T) {
+	got, err := ParseModelPrice("0.15:0.6")
+	if err != nil || got != (ModelPrice{Prompt: 0.15, Completion: 0.6}) {
+		t.Errorf("ParseModelPrice() = %+v, %v", got, err)
+	}
+	if _, err := ParseModelPrice("0.15"); err == nil {
+		t.Error("ParseModelPrice() without completion price should fail")
+	}
+}
@@ -0,0 +1,148 @@
+package model
+
+import (
+	"regexp"
+	"strings"
+)
+
+// BotDisplayName プロンプトでこのBotを表す名前
+const BotDisplayName = "[GptBot]"
+
+// slackReference Slackのメッセージ中の <@U123> / <#C123|general> / <https://example.com|ラベル> などの参照
+var slackReference = regexp.MustCompile(`<([^<>]+)>`)
+
+// slackEntityUnescaper Slackがメッセージ中でエスケープする文字を元に戻す
+var slackEntityUnescaper = strings.NewReplacer("&lt;", "<", "&gt;", ">", "&amp;", "&")
+
+// SlackUser ユーザーの表示に使う情報
+type SlackUser struct {
+	ID          string
+	DisplayName string
+	IsBot       bool
+	IsAdmin     bool // ワークスペースの管理者またはオーナー
+}
+
+// SlackDirectory SlackのIDを読める名前に置き換えるための対応表。引けないIDはそのまま表示する
+type SlackDirectory struct {
+	BotUserID string
+	BotID     string
+	Users     map[string]string // ユーザーID -> 表示名
+	Channels  map[string]string // チャンネルID -> チャンネル名
+}
+
+// UserName ユーザーの表示名。このBotは BotDisplayName とする
+// Botと紛らわしい名前や、他のユーザーと同じ名前の場合は、誰の発言か区別できるようユーザーIDを添える
+func (d SlackDirectory) UserName(userID string) string {
+	if userID != "" && userID == d.BotUserID {
+		return BotDisplayName
+	}
+	if name, ok := d.Users[userID]; ok && name != "" {
+		if d.isAmbiguousName(userID, name) {
+			return withUserID(name, userID)
+		}
+		return name
+	}
+	return userID
+}
+
+// isAmbiguousName Botと同じ名前か、他のユーザーと同じ名前か
+func (d SlackDirectory) isAmbiguousName(userID string, name string) bool {
+	if looksLikeBot(name) {
+		return true
+	}
+	for otherID, other := range d.Users {
+		if otherID != userID && normalizeName(other) == normalizeName(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// looksLikeBot 括弧や大文字・小文字の違いを除いて BotDisplayName と同じ名前か
+func looksLikeBot(name string) bool {
+	return normalizeName(name) == normalizeName(BotDisplayName)
+}
+
+// normalizeName 見た目で区別しにくい違い（前後の空白・括弧・大文字と小文字）を除いた名前
+func normalizeName(name string) string {
+	return strings.ToLower(strings.Trim(strings.TrimSpace(name), "[]"))
+}
+
+// withUserID 名前にユーザーIDを添える
+func withUserID(name string, userID string) string {
+	return name + " (" + userID + ")"
+}
+
+// FormatText メンション・チャンネル・リンクを読めるテキストに置き換え、エスケープを元に戻す
+func (d SlackDirectory) FormatText(text string) string {
+	text = slackReference.ReplaceAllStringFunc(text, func(reference string) string {
+		return d.formatReference(reference[1 : len(reference)-1])
+	})
+	return slackEntityUnescaper.Replace(text)
+}
+
+func (d SlackDirectory) formatReference(reference string) string {
+	target, label, _ := strings.Cut(reference, "|")
+	switch {
+	case strings.HasPrefix(target, "@"):
+		userID := target[1:]
+		if name := d.UserName(userID); name != userID || label == "" {
+			return "@" + name
+		}
+		label = strings.TrimPrefix(label, "@")
+		if looksLikeBot(label) {
+			return "@" + withUserID(label, userID)
+		}
+		return "@" + label
+	case strings.HasPrefix(target, "#"):
+		if label != "" {
+			return "#" + label
+		}
+		channelID := target[1:]
+		if name, ok := d.Channels[channelID]; ok && name != "" {
+			return "#" + name
+		}
+		return "#" + channelID
+	case strings.HasPrefix(target, "!"):
+		// <!here> などの特殊なメンションや <!subteam^S123|@team>、<!date^...|代替テキスト>
+		if label != "" {
+			return label
+		}
+		name, _, _ := strings.Cut(target[1:], "^")
+		return "@" + name
+	default:
+		if label == "" || label == target {
+			return target
+		}
+		return label + " (" + target + ")"
+	}
+}
+
+// ReferencedIDs 発言者と、メッセージ中でメンションされたユーザー・名前のないチャンネル参照のIDを返す
+func (messages SlackMessages) ReferencedIDs() (userIDs []string, channelIDs []string) {
+	users := make(map[string]bool)
+	channels := make(map[string]bool)
+	addUser := func(id string) {
+		if id != "" && !users[id] {
+			users[id] = true
+			userIDs = append(userIDs, id)
+		}
+	}
+
+	for _, message := range messages {
+		addUser(message.User)
+		for _, match := range slackReference.FindAllStringSubmatch(message.Text, -1) {
+			target, label, _ := strings.Cut(match[1], "|")
+			switch {
+			case strings.HasPrefix(target, "@"):
+				addUser(target[1:])
+			case strings.HasPrefix(target, "#") && label == "":
+				if id := target[1:]; !channels[id] {
+					channels[id] = true
+					channelIDs = append(channelIDs, id)
+				}
+			}
+		}
+	}
+	return userIDs, channelIDs
+}
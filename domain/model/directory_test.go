@@ -0,0 +1,107 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFormatText(t *testing.T) {
+	directory := SlackDirectory{
+		BotUserID: "UBOT",
+		Users:     map[string]string{"U1": "佐藤"},
+		Channels:  map[string]string{"C1": "general"},
+	}
+
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{name: "bot mention", text: "<@UBOT> こんにちは", want: "@[GptBot] こんにちは"},
+		{name: "user mention", text: "<@U1> さんに聞いて", want: "@佐藤 さんに聞いて"},
+		{name: "unknown user with label", text: "<@U2|suzuki> さん", want: "@suzuki さん"},
+		{name: "unknown user", text: "<@U2> さん", want: "@U2 さん"},
+		{name: "channel with label", text: "<#C9|random> を見て", want: "#random を見て"},
+		{name: "channel without label", text: "<#C1> を見て", want: "#general を見て"},
+		{name: "special mention", text: "<!here> 確認お願いします", want: "@here 確認お願いします"},
+		{name: "user group", text: "<!subteam^S1|@sre> 対応して", want: "@sre 対応して"},
+		{name: "link with label", text: "<https://example.com|手順書> 参照", want: "手順書 (https://example.com) 参照"},
+		{name: "bare link", text: "<https://example.com>", want: "https://example.com"},
+		{name: "escaped characters", text: "a &lt; b &amp;&amp; c &gt; d", want: "a < b && c > d"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := directory.FormatText(tt.text); got != tt.want {
+				t.Errorf("FormatText(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUserNameDisambiguates(t *testing.T) {
+	directory := SlackDirectory{
+		BotUserID: "UBOT",
+		Users: map[string]string{
+			"U1": "佐藤",
+			"U2": "佐藤",
+			"U3": "[GptBot]",
+			"U4": "gptbot",
+			"U5": "鈴木",
+		},
+	}
+
+	tests := []struct {
+		userID string
+		want   string
+	}{
+		{userID: "UBOT", want: "[GptBot]"},
+		{userID: "U1", want: "佐藤 (U1)"},
+		{userID: "U2", want: "佐藤 (U2)"},
+		{userID: "U3", want: "[GptBot] (U3)"},
+		{userID: "U4", want: "gptbot (U4)"},
+		{userID: "U5", want: "鈴木"},
+		{userID: "U9", want: "U9"},
+	}
+
+	for _, tt := range tests {
+		if got := directory.UserName(tt.userID); got != tt.want {
+			t.Errorf("UserName(%q) = %q, want %q", tt.userID, got, tt.want)
+		}
+	}
+
+	if got, want := directory.FormatText("<@U9|[GptBot]> です"), "@[GptBot] (U9) です"; got != want {
+		t.Errorf("FormatText() = %q, want %q", got, want)
+	}
+}
+
+func TestReferencedIDs(t *testing.T) {
+	messages := SlackMessages{
+		{User: "U1", Text: "<@UBOT> <@U2> と <#C1> と <#C2|named> を見て"},
+		{User: "U2", Text: "<@U1> 了解 <#C1>"},
+	}
+
+	users, channels := messages.ReferencedIDs()
+	if want := []string{"U1", "UBOT", "U2"}; !reflect.DeepEqual(users, want) {
+		t.Errorf("users = %v, want %v", users, want)
+	}
+	if want := []string{"C1"}; !reflect.DeepEqual(channels, want) {
+		t.Errorf("channels = %v, want %v", channels, want)
+	}
+}
+
+func TestCreateConversationUsesDisplayNames(t *testing.T) {
+	messages := SlackMessages{
+		{User: "U1", Text: "<@UBOT> <@U2> さんの質問に答えて"},
+	}
+	directory := SlackDirectory{
+		BotUserID: "UBOT",
+		Users:     map[string]string{"U1": "佐藤", "U2": "鈴木"},
+	}
+
+	got := messages.CreateConversation("system", directory)
+	want := "佐藤: @[GptBot] @鈴木 さんの質問に答えて"
+	if len(got.Messages) != 1 || got.Messages[0].Content != want {
+		t.Errorf("CreateConversation() = %+v, want %q", got.Messages, want)
+	}
+}
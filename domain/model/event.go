@@ -0,0 +1,41 @@
+package model
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	// EventProcessingTTL 処理中のイベントを保持する時間。処理中にプロセスが落ちても、この時間が過ぎれば再送を処理できる
+	EventProcessingTTL = 10 * time.Minute
+	// EventDoneTTL 処理済みのイベントを保持する時間。Slackの再送はこの範囲に収まる
+	EventDoneTTL = time.Hour
+)
+
+// ErrReplyPosted 処理は失敗したが、応答の一部をすでにスレッドに投稿した
+// 再送されたイベントを処理すると同じ応答を再び投稿するため、処理済みとして扱う
+var ErrReplyPosted = errors.New("reply already posted")
+
+// SlackEvent 重複判定に使うSlackイベントの識別子
+type SlackEvent struct {
+	EventID     string // Events APIのevent_id
+	ClientMsgID string // メッセージのclient_msg_id（app_mentionには含まれない）
+	ChannelID   string
+	TimeStamp   string // イベントの元になったメッセージのts
+}
+
+// DedupKeys 重複判定のキーを返す
+// 同じメッセージからapp_mentionとmessageの両方のイベントが届くため、event_idに加えてメッセージ単位のキーも使う
+func (e SlackEvent) DedupKeys() []string {
+	var keys []string
+	if e.EventID != "" {
+		keys = append(keys, "event:"+e.EventID)
+	}
+	if e.ClientMsgID != "" {
+		keys = append(keys, "client_msg:"+e.ClientMsgID)
+	}
+	if e.ChannelID != "" && e.TimeStamp != "" {
+		keys = append(keys, "message:"+e.ChannelID+":"+e.TimeStamp)
+	}
+	return keys
+}
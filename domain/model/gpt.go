@@ -1,5 +1,109 @@
 package model
 
+type ChatRole string
+
+const (
+	ChatRoleSystem    ChatRole = "system"
+	ChatRoleUser      ChatRole = "user"
+	ChatRoleAssistant ChatRole = "assistant"
+)
+
+// ChatMessage 会話の1ターン
+type ChatMessage struct {
+	Role    ChatRole    `json:"role"`
+	Content string      `json:"content"`
+	Images  []ChatImage `json:"-"` // 添付画像（ユーザーのターンのみ）
+	TS      string      `json:"-"` // ターンにまとめたSlackのメッセージのうち最後のもののts
+}
+
+// Conversation GPTに送る会話（システムプロンプトとユーザー・アシスタントのターン）
+type Conversation struct {
+	Provider     string  // 使用するLLMのプロバイダー（空の場合はデフォルトのプロバイダー）
+	Model        string  // 使用するモデル（空の場合はデフォルトのモデル）
+	Temperature  float32 // 0の場合はモデルのデフォルト
+	SystemPrompt string
+	Summary      string // 予算に収まらず省いた古いターンの要約
+	Messages     []ChatMessage
+	MaxTokens    int // 応答の最大トークン数（0の場合は指定しない）
+}
+
+// ChatResponse プロバイダーに依存しないGPT応答
+type ChatResponse struct {
+	ID           string    `json:"id,omitempty"`
+	Provider     string    `json:"provider"`
+	Model        string    `json:"model"` // 実際に応答したモデル
+	Content      string    `json:"content"`
+	FinishReason string    `json:"finish_reason,omitempty"`
+	Usage        ChatUsage `json:"usage"`
+	// FallbackModel 指定したプロバイダー・モデルが使えず、フォールバック先が応答した場合に代わりに呼び出したモデル
+	FallbackModel string `json:"fallback_model,omitempty"`
+}
+
+// TokenUsage 使用量として記録するトークン数。フォールバックした場合は代わりに呼び出したモデルの料金で計算する
+func (r ChatResponse) TokenUsage(conversation Conversation) TokenUsage {
+	modelName := conversation.ModelName()
+	if r.FallbackModel != "" {
+		modelName = r.FallbackModel
+	}
+	return TokenUsage{
+		Model:            modelName,
+		PromptTokens:     r.Usage.PromptTokens,
+		CompletionTokens: r.Usage.CompletionTokens,
+	}
+}
+
+// ChatUsage 1回の呼び出しで使用したトークン数
+type ChatUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+// ModelName 使用するモデルの名前を返す
+func (c Conversation) ModelName() string {
+	if c.Model == "" {
+		return DefaultChatModel
+	}
+	return c.Model
+}
+
+// summaryHeading システムメッセージで要約の前に付ける見出し
+const summaryHeading = "\n[これまでの会話の要約]\n"
+
+// CanFallbackTo この会話を組み立て直さずに別のモデルへ送れるか
+// トークンの予算や画像の有無は元のモデルに合わせているため、予算が小さいモデルや、画像を含む会話を画像を読み取れないモデルには送らない
+func (c Conversation) CanFallbackTo(modelName string) bool {
+	if modelName == c.ModelName() {
+		return true
+	}
+	if ContextTokenBudget(modelName) < ContextTokenBudget(c.ModelName()) {
+		return false
+	}
+	for _, message := range c.Messages {
+		if len(message.Images) > 0 && !SupportsVision(modelName) {
+			return false
+		}
+	}
+	return true
+}
+
+// SystemContent システムメッセージとして送る内容。要約がある場合はシステムプロンプトの後に付け加える
+func (c Conversation) SystemContent() string {
+	if c.Summary == "" {
+		return c.SystemPrompt
+	}
+	return c.SystemPrompt + summaryHeading + c.Summary + "\n"
+}
+
+// NewConversation 単発のプロンプトから会話を作成する
+func NewConversation(systemPrompt string, prompt string) Conversation {
+	return Conversation{
+		SystemPrompt: systemPrompt,
+		Messages: []ChatMessage{
+			{Role: ChatRoleUser, Content: prompt},
+		},
+	}
+}
+
 const CharacterSettings = `
 [この会話の概要と世界観]
 ロールプレイゲーム。ゲームの世界観はアニメ「とある科学の超電磁砲」の世界に基づきます。
@@ -22,3 +126,9 @@ const CharacterSettings = `
 私からの会話に「了解」「ありがとう」「また連絡する」といったキーワードが含まれている場合、それが会話終了の合図です。
 その際には、文末に「以上です、御坂10032号号より報告を終了します。」と付加し、そこで返答を終了してください。
 `
+
+// SpeakerFormatGuide スレッド履歴の発言形式をGPTに伝えるためにシステムプロンプトへ付け加える説明
+const SpeakerFormatGuide = `
+[発言の形式]
+ユーザーの発言は「<発言者の名前>: 発言内容」の形式で渡されます。メンションは「@名前」、チャンネルは「#チャンネル名」で表します。複数のユーザーが参加している場合は、誰の発言かを区別して回答してください。
+`
@@ -0,0 +1,135 @@
+package model
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+const (
+	// imageCommand メンションに続けて画像の生成を依頼するキーワード
+	imageCommand = "draw"
+
+	ImagePlaceholderMessage = "画像を作成しています..."
+	ImageErrorMessage       = "画像の作成中にエラーが発生しました。時間をおいて再度お試しください。"
+	ImageUsageMessage       = "作成する画像の説明を入力してください。例: `@GptBot draw 夕焼けの海` または `/gpt image 夕焼けの海`"
+	ImageAcceptedMessage    = "画像の作成を受け付けました。できあがったらチャンネルに投稿します。"
+)
+
+// MaxImageCount 1回の依頼で作成できる画像の枚数の上限（OpenAI APIの上限）
+const MaxImageCount = 10
+
+// ImageOptions 画像の生成の設定。空の項目はAPIのデフォルトを使う
+type ImageOptions struct {
+	Provider string // 使用するLLMのプロバイダー（空の場合はデフォルトのプロバイダー）
+	Model    string // dall-e-2 / dall-e-3 / gpt-image-1 など
+	Size     string // 1024x1024 など
+	Quality  string // standard / hd（dall-e-3）、low / medium / high（gpt-image-1）
+	Style    string // vivid / natural（dall-e-3 のみ）
+	Count    int    // 作成する枚数
+}
+
+// DefaultImageOptions Slackから画像を作成するときの設定
+var DefaultImageOptions = ImageOptions{
+	Model: "dall-e-2",
+	Size:  "256x256",
+	Count: 1,
+}
+
+// MaxImageCounts 1回の依頼で作成できる枚数が MaxImageCount より少ないモデル。前方一致で探す
+var MaxImageCounts = map[string]int{
+	"dall-e-3": 1,
+}
+
+// ImageCount 作成する枚数。1からモデルが1回に作成できる枚数の範囲に収める
+func (o ImageOptions) ImageCount() int {
+	limit := MaxImageCount
+	if n, ok := lookupByPrefix(MaxImageCounts, o.Model); ok {
+		limit = n
+	}
+	return min(max(o.Count, 1), limit)
+}
+
+// ImageDownloadError 画像は作成できたが、一部の内容を取得できなかった
+// 作成した画像は費用がかかっているため、取得できた枚数ではなく Generated で使用量を数える
+type ImageDownloadError struct {
+	Generated int // APIが作成した枚数
+	Err       error
+}
+
+func (e *ImageDownloadError) Error() string {
+	return fmt.Sprintf("failed to download %d generated images: %v", e.Generated, e.Err)
+}
+
+func (e *ImageDownloadError) Unwrap() error {
+	return e.Err
+}
+
+// GeneratedImage 生成した画像
+type GeneratedImage struct {
+	Data          []byte
+	ContentType   string // 画像の内容から判定したMIMEタイプ
+	RevisedPrompt string // モデルが書き換えたプロンプト（dall-e-3）
+}
+
+// NewGeneratedImage 画像の内容からMIMEタイプを判定する
+func NewGeneratedImage(data []byte, revisedPrompt string) GeneratedImage {
+	return GeneratedImage{
+		Data:          data,
+		ContentType:   http.DetectContentType(data),
+		RevisedPrompt: revisedPrompt,
+	}
+}
+
+// imageExtensions MIMEタイプごとのファイルの拡張子
+var imageExtensions = map[string]string{
+	"image/png":  "png",
+	"image/jpeg": "jpg",
+	"image/webp": "webp",
+	"image/gif":  "gif",
+}
+
+// Extension ファイル名に使う拡張子。画像として判定できない場合は png とする
+func (i GeneratedImage) Extension() string {
+	if ext, ok := imageExtensions[i.ContentType]; ok {
+		return ext
+	}
+	return "png"
+}
+
+// ImagePrices モデルごとの画像1枚あたりの料金（USD）。前方一致で探す
+var ImagePrices = map[string]float64{
+	"dall-e-2":    0.02,
+	"dall-e-3":    0.04,
+	"gpt-image-1": 0.042,
+}
+
+// DefaultImagePrice 料金が登録されていないモデルに使う画像1枚あたりの料金
+var DefaultImagePrice = 0.04
+
+// ImagePriceOf 画像1枚あたりの料金を返す。前方一致するモデルが複数ある場合は最も長い名前を優先する
+func ImagePriceOf(modelName string) float64 {
+	if price, ok := lookupByPrefix(ImagePrices, modelName); ok {
+		return price
+	}
+	return DefaultImagePrice
+}
+
+// leadingMentions 先頭のメンション（<@U123> や <@U123|name>）
+var leadingMentions = regexp.MustCompile(`^(\s*<@[^>]+>)+`)
+
+// ParseImageRequest "@GptBot draw <説明>" 形式のメッセージから画像の説明を取り出す
+// 画像の生成の依頼でない場合は false を返す。説明が空の場合は空文字と true を返す
+func ParseImageRequest(text string) (string, bool) {
+	text = strings.TrimSpace(leadingMentions.ReplaceAllString(text, ""))
+	keyword, prompt := text, ""
+	if i := strings.IndexFunc(text, unicode.IsSpace); i >= 0 {
+		keyword, prompt = text[:i], text[i:]
+	}
+	if !strings.EqualFold(keyword, imageCommand) {
+		return "", false
+	}
+	return strings.TrimSpace(prompt), true
+}
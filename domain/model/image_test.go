@@ -0,0 +1,118 @@
+package model
+
+import "testing"
+
+func TestParseImageRequest(t *testing.T) {
+	tests := []struct {
+		name       string
+		text       string
+		wantPrompt string
+		wantOK     bool
+	}{
+		{
+			name:       "mention and draw",
+			text:       "<@U0BOT> draw 夕焼けの海",
+			wantPrompt: "夕焼けの海",
+			wantOK:     true,
+		},
+		{
+			name:       "case insensitive",
+			text:       "<@U0BOT|gptbot>  Draw a red cat ",
+			wantPrompt: "a red cat",
+			wantOK:     true,
+		},
+		{
+			name:       "without mention",
+			text:       "draw a cat",
+			wantPrompt: "a cat",
+			wantOK:     true,
+		},
+		{
+			name:   "empty prompt",
+			text:   "<@U0BOT> draw",
+			wantOK: true,
+		},
+		{
+			name:   "not a draw request",
+			text:   "<@U0BOT> drawing のコツを教えて",
+			wantOK: false,
+		},
+		{
+			name:   "draw in the middle",
+			text:   "<@U0BOT> please draw a cat",
+			wantOK: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prompt, ok := ParseImageRequest(tt.text)
+			if ok != tt.wantOK || prompt != tt.wantPrompt {
+				t.Errorf("ParseImageRequest(%q) = (%q, %v), want (%q, %v)", tt.text, prompt, ok, tt.wantPrompt, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestNewGeneratedImage(t *testing.T) {
+	tests := []struct {
+		name            string
+		data            []byte
+		wantContentType string
+		wantExtension   string
+	}{
+		{
+			name:            "png",
+			data:            []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"),
+			wantContentType: "image/png",
+			wantExtension:   "png",
+		},
+		{
+			name:            "jpeg",
+			data:            []byte("\xff\xd8\xff\xe0\x00\x10JFIF"),
+			wantContentType: "image/jpeg",
+			wantExtension:   "jpg",
+		},
+		{
+			name:            "webp",
+			data:            []byte("RIFF\x00\x00\x00\x00WEBPVP8 "),
+			wantContentType: "image/webp",
+			wantExtension:   "webp",
+		},
+		{
+			name:            "unknown",
+			data:            []byte("not an image"),
+			wantContentType: "text/plain; charset=utf-8",
+			wantExtension:   "png",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			image := NewGeneratedImage(tt.data, "")
+			if image.ContentType != tt.wantContentType || image.Extension() != tt.wantExtension {
+				t.Errorf("NewGeneratedImage() = (%q, %q), want (%q, %q)", image.ContentType, image.Extension(), tt.wantContentType, tt.wantExtension)
+			}
+		})
+	}
+}
+
+func TestImageCount(t *testing.T) {
+	tests := []struct {
+		model string
+		count int
+		want  int
+	}{
+		{model: "dall-e-2", count: 0, want: 1},
+		{model: "dall-e-2", count: 3, want: 3},
+		{model: "dall-e-2", count: 20, want: MaxImageCount},
+		{model: "dall-e-3", count: 3, want: 1},
+		{model: "gpt-image-1", count: 3, want: 3},
+	}
+
+	for _, tt := range tests {
+		if got := (ImageOptions{Model: tt.model, Count: tt.count}).ImageCount(); got != tt.want {
+			t.Errorf("ImageCount() with %s, Count %d = %d, want %d", tt.model, tt.count, got, tt.want)
+		}
+	}
+}
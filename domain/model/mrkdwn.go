@@ -0,0 +1,525 @@
+package model
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/slack-go/slack"
+)
+
+const (
+	// maxSectionTextLength セクションブロックのテキストの最大文字数
+	maxSectionTextLength = 3000
+	// maxHeaderTextLength ヘッダーブロックのテキストの最大文字数
+	maxHeaderTextLength = 150
+	// MaxMessageBlocks 1つのメッセージに含められるブロックの最大数
+	MaxMessageBlocks = 50
+
+	// zeroWidthSpace 日本語などに隣接した装飾をSlackに認識させるために挟む文字
+	zeroWidthSpace = "\u200b"
+)
+
+// 装飾を変換する途中で使う印。最後にmrkdwnの記号に置き換える
+const (
+	boldMark   = '\x01'
+	italicMark = '\x02'
+	strikeMark = '\x03'
+)
+
+var (
+	headingPattern      = regexp.MustCompile(`^ {0,3}(#{1,6})\s+(.*?)(\s+#+)?\s*$`)
+	rulePattern         = regexp.MustCompile(`^ {0,3}([-*_])( *([-*_])){2,} *$`)
+	tableRowPattern     = regexp.MustCompile(`^\s*\|.*\|\s*$`)
+	tableDividerPattern = regexp.MustCompile(`^\s*\|?(\s*:?-+:?\s*\|)+\s*(:?-+:?\s*)?$`)
+	bulletPattern       = regexp.MustCompile(`^(\s*)[-*+]\s+(.*)$`)
+	orderedPattern      = regexp.MustCompile(`^(\s*)(\d+)[.)]\s+(.*)$`)
+	quotePattern        = regexp.MustCompile(`^\s*>\s?(.*)$`)
+	taskPattern         = regexp.MustCompile(`^\[([ xX])\]\s+(.*)$`)
+
+	inlineCodePattern = regexp.MustCompile("`[^`]+`")
+	imagePattern      = regexp.MustCompile(`!\[([^\]]*)\]\(([^)\s]+)(?:\s+"[^"]*")?\)`)
+	linkPattern       = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)(?:\s+"[^"]*")?\)`)
+	boldPattern       = regexp.MustCompile(`\*\*(.+?)\*\*`)
+	underscorePattern = regexp.MustCompile(`__([^_\s](?:[^_]*[^_\s])?)__`)
+	identifierPattern = regexp.MustCompile(`^\w+$`)
+	italicPattern     = regexp.MustCompile(`(^|[^*\w])\*([^*\s](?:[^*]*[^*\s])?)\*`)
+	strikePattern     = regexp.MustCompile(`~~(.+?)~~`)
+)
+
+// slackEscaper Slackのメッセージで特別な意味を持つ文字をエスケープする
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+type markdownBlockKind int
+
+const (
+	markdownText markdownBlockKind = iota
+	markdownHeading
+	markdownCode
+	markdownRule
+	markdownTable
+)
+
+// markdownBlock Markdownをブロック単位に分けたもの
+type markdownBlock struct {
+	kind  markdownBlockKind
+	lines []string // 見出しは1行、表は区切り行を除いた行
+}
+
+// ToMrkdwn GPTが返すMarkdownをSlackのmrkdwnに変換する
+// 見出しは太字に、表は等幅のコードブロックに変換する
+func ToMrkdwn(markdown string) string {
+	var parts []string
+	for _, block := range parseMarkdown(markdown) {
+		parts = append(parts, block.mrkdwn())
+	}
+	// 応答の前後の空行は除く
+	return strings.Trim(strings.Join(parts, "\n"), "\n")
+}
+
+// ToBlocks GPTが返すMarkdownをBlock Kitのブロックに変換する
+// 見出しはヘッダー、水平線は区切り線、コードと表はコードブロックを含むセクションになる
+func ToBlocks(markdown string) []slack.Block {
+	var blocks []slack.Block
+	for _, block := range parseMarkdown(markdown) {
+		switch block.kind {
+		case markdownHeading:
+			text := truncateRunes(plainInline(block.lines[0]), maxHeaderTextLength)
+			blocks = append(blocks, slack.NewHeaderBlock(slack.NewTextBlockObject(slack.PlainTextType, text, false, false)))
+		case markdownRule:
+			blocks = append(blocks, slack.NewDividerBlock())
+		case markdownCode, markdownTable:
+			lines := block.lines
+			if block.kind == markdownTable {
+				lines = formatTable(block.lines)
+			}
+			// エスケープで長くなる分も含めて上限に収める
+			escaped := make([]string, len(lines))
+			for i, line := range lines {
+				escaped[i] = slackEscaper.Replace(line)
+			}
+			for _, chunk := range chunkLines(escaped, maxSectionTextLength-len("```\n\n```")) {
+				blocks = append(blocks, mrkdwnSection("```\n"+chunk+"\n```"))
+			}
+		default:
+			// 装飾の途中で分けないよう、印のまま分けてから記号に置き換える
+			text := strings.TrimSpace(block.markedText())
+			if text == "" {
+				continue
+			}
+			for _, chunk := range chunkLines(strings.Split(text, "\n"), maxSectionTextLength) {
+				blocks = append(blocks, mrkdwnSection(applyMarks(chunk)))
+			}
+		}
+	}
+	return blocks
+}
+
+// BotReply Slackに投稿するGPT応答。Blocks が空の場合は Text だけを投稿する
+type BotReply struct {
+	Text   string        // mrkdwnに変換した応答。Block Kitを使う場合も通知やプレビューに使われる
+	Blocks []slack.Block // Block Kitで投稿する場合のブロック
+}
+
+// NewBotReply GPTが返したMarkdownを投稿用に変換する
+// blockKit が true でもブロック数が MaxMessageBlocks を超える場合は mrkdwn のテキストだけにする
+func NewBotReply(markdown string, blockKit bool) BotReply {
+	reply := BotReply{Text: ToMrkdwn(markdown)}
+	if blockKit {
+		if blocks := ToBlocks(markdown); len(blocks) > 0 && len(blocks) <= MaxMessageBlocks {
+			reply.Blocks = blocks
+		}
+	}
+	return reply
+}
+
+func mrkdwnSection(text string) slack.Block {
+	return slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil)
+}
+
+// parseMarkdown Markdownを見出し・コード・水平線・表・それ以外のテキストのブロックに分ける
+// 閉じられていないコードブロック（ストリーミングの途中など）は末尾までをコードとみなす
+func parseMarkdown(markdown string) []markdownBlock {
+	lines := strings.Split(strings.ReplaceAll(markdown, "\r\n", "\n"), "\n")
+
+	var blocks []markdownBlock
+	appendText := func(line string) {
+		if last := len(blocks) - 1; last >= 0 && blocks[last].kind == markdownText {
+			blocks[last].lines = append(blocks[last].lines, line)
+			return
+		}
+		blocks = append(blocks, markdownBlock{kind: markdownText, lines: []string{line}})
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if fence, ok := openingFence(line); ok {
+			code := markdownBlock{kind: markdownCode}
+			for i++; i < len(lines); i++ {
+				if isClosingFence(lines[i], fence) {
+					break
+				}
+				code.lines = append(code.lines, lines[i])
+			}
+			blocks = append(blocks, code)
+			continue
+		}
+		if m := headingPattern.FindStringSubmatch(line); m != nil {
+			blocks = append(blocks, markdownBlock{kind: markdownHeading, lines: []string{m[2]}})
+			continue
+		}
+		if rulePattern.MatchString(line) && sameRuleChars(line) {
+			blocks = append(blocks, markdownBlock{kind: markdownRule})
+			continue
+		}
+		if tableRowPattern.MatchString(line) && i+1 < len(lines) && tableDividerPattern.MatchString(lines[i+1]) {
+			table := markdownBlock{kind: markdownTable, lines: []string{line}}
+			for i += 2; i < len(lines) && tableRowPattern.MatchString(lines[i]); i++ {
+				table.lines = append(table.lines, lines[i])
+			}
+			i--
+			blocks = append(blocks, table)
+			continue
+		}
+		appendText(line)
+	}
+	return blocks
+}
+
+// openingFence ``` または ~~~ で始まる行の場合にフェンスの記号を返す
+func openingFence(line string) (string, bool) {
+	trimmed := strings.TrimLeft(line, " ")
+	if len(line)-len(trimmed) > 3 {
+		return "", false
+	}
+	for _, char := range []string{"`", "~"} {
+		n := len(trimmed) - len(strings.TrimLeft(trimmed, char))
+		if n >= 3 {
+			return strings.Repeat(char, n), true
+		}
+	}
+	return "", false
+}
+
+func isClosingFence(line string, fence string) bool {
+	trimmed := strings.TrimSpace(line)
+	return strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == ""
+}
+
+// sameRuleChars 水平線が1種類の記号だけでできているか（"- * -" のような行は水平線としない）
+func sameRuleChars(line string) bool {
+	chars := strings.ReplaceAll(strings.TrimSpace(line), " ", "")
+	return strings.Count(chars, chars[:1]) == len(chars)
+}
+
+func (b markdownBlock) mrkdwn() string {
+	switch b.kind {
+	case markdownHeading:
+		return applyMarks(string(boldMark) + slackEscaper.Replace(plainInline(b.lines[0])) + string(boldMark))
+	case markdownCode:
+		return "```\n" + slackEscaper.Replace(strings.Join(b.lines, "\n")) + "\n```"
+	case markdownRule:
+		return "――――――――――"
+	case markdownTable:
+		return "```\n" + slackEscaper.Replace(strings.Join(formatTable(b.lines), "\n")) + "\n```"
+	}
+
+	return applyMarks(b.markedText())
+}
+
+// markedText テキストのブロックを変換し、装飾を印のまま返す
+func (b markdownBlock) markedText() string {
+	lines := make([]string, len(b.lines))
+	for i, line := range b.lines {
+		lines[i] = convertLine(line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// convertLine 箇条書き・番号付きリスト・引用の1行を変換する
+func convertLine(line string) string {
+	if m := bulletPattern.FindStringSubmatch(line); m != nil {
+		level := len(strings.ReplaceAll(m[1], "\t", "  ")) / 2
+		text := m[2]
+		if task := taskPattern.FindStringSubmatch(text); task != nil {
+			check := "☐"
+			if task[1] != " " {
+				check = "☑"
+			}
+			text = check + " " + task[2]
+		}
+		bullet := "•"
+		if level > 0 {
+			bullet = strings.Repeat("    ", level) + "◦"
+		}
+		return bullet + " " + convertInline(text)
+	}
+	if m := orderedPattern.FindStringSubmatch(line); m != nil {
+		level := len(strings.ReplaceAll(m[1], "\t", "  ")) / 2
+		return strings.Repeat("    ", level) + m[2] + ". " + convertInline(m[3])
+	}
+	if m := quotePattern.FindStringSubmatch(line); m != nil {
+		return "> " + convertInline(m[1])
+	}
+	return convertInline(line)
+}
+
+// convertInline リンク・太字・斜体・打ち消し線を変換する。インラインコードの中は変換しない
+func convertInline(text string) string {
+	var builder strings.Builder
+	last := 0
+	for _, loc := range inlineCodePattern.FindAllStringIndex(text, -1) {
+		builder.WriteString(convertInlineText(text[last:loc[0]]))
+		builder.WriteString(slackEscaper.Replace(text[loc[0]:loc[1]]))
+		last = loc[1]
+	}
+	builder.WriteString(convertInlineText(text[last:]))
+	return builder.String()
+}
+
+func convertInlineText(text string) string {
+	text = slackEscaper.Replace(text)
+	text = imagePattern.ReplaceAllString(text, "<$2|$1>")
+	text = linkPattern.ReplaceAllString(text, "<$2|$1>")
+	text = replaceBold(text, func(inner string) string { return string(boldMark) + inner + string(boldMark) })
+	text = italicPattern.ReplaceAllString(text, "${1}"+string(italicMark)+"${2}"+string(italicMark))
+	text = strikePattern.ReplaceAllString(text, string(strikeMark)+"$1"+string(strikeMark))
+	return text
+}
+
+// plainInline 見出しや表の中の装飾を取り除く
+func plainInline(text string) string {
+	text = imagePattern.ReplaceAllString(text, "$1")
+	text = linkPattern.ReplaceAllString(text, "$1 ($2)")
+	text = replaceBold(text, func(inner string) string { return inner })
+	text = strikePattern.ReplaceAllString(text, "$1")
+	return strings.ReplaceAll(text, "`", "")
+}
+
+// replaceBold **太字** と __太字__ を replace で置き換える
+// __ は単語の途中（snake__case や obj.__init__）や識別子だけを囲む場合（__init__）は太字にしない
+func replaceBold(text string, replace func(inner string) string) string {
+	text = boldPattern.ReplaceAllStringFunc(text, func(match string) string {
+		return replace(match[2 : len(match)-2])
+	})
+
+	var builder strings.Builder
+	last := 0
+	for _, loc := range underscorePattern.FindAllStringSubmatchIndex(text, -1) {
+		before, _ := utf8.DecodeLastRuneInString(text[:loc[0]])
+		after, _ := utf8.DecodeRuneInString(text[loc[1]:])
+		inner := text[loc[2]:loc[3]]
+		if isNameRune(before) || before == '.' || isNameRune(after) || identifierPattern.MatchString(inner) {
+			continue
+		}
+		builder.WriteString(text[last:loc[0]])
+		builder.WriteString(replace(inner))
+		last = loc[1]
+	}
+	builder.WriteString(text[last:])
+	return builder.String()
+}
+
+func isNameRune(r rune) bool {
+	return isWordRune(r) || r == '_'
+}
+
+// applyMarks 装飾の印をmrkdwnの記号に置き換える
+// Slackは前後が文字の装飾を認識しないため、その場合はゼロ幅スペースを挟む
+func applyMarks(text string) string {
+	open := map[rune]bool{}
+
+	runes := []rune(text)
+	var builder strings.Builder
+	for i, r := range runes {
+		symbol, ok := markSymbols[r]
+		if !ok {
+			builder.WriteRune(r)
+			continue
+		}
+		if !open[r] {
+			if i > 0 && isWordRune(runes[i-1]) {
+				builder.WriteString(zeroWidthSpace)
+			}
+			builder.WriteString(symbol)
+		} else {
+			builder.WriteString(symbol)
+			if i+1 < len(runes) && isWordRune(runes[i+1]) {
+				builder.WriteString(zeroWidthSpace)
+			}
+		}
+		open[r] = !open[r]
+	}
+	return builder.String()
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// formatTable 表の列の幅をそろえる。全角文字は2文字分の幅として数える
+func formatTable(rows []string) []string {
+	var cells [][]string
+	var widths []int
+	for _, row := range rows {
+		row = strings.TrimSpace(row)
+		row = strings.TrimSuffix(strings.TrimPrefix(row, "|"), "|")
+		var rowCells []string
+		for i, cell := range strings.Split(row, "|") {
+			cell = plainInline(strings.TrimSpace(cell))
+			rowCells = append(rowCells, cell)
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			widths[i] = max(widths[i], displayWidth(cell))
+		}
+		cells = append(cells, rowCells)
+	}
+
+	formatted := make([]string, 0, len(cells)+1)
+	for r, rowCells := range cells {
+		padded := make([]string, len(rowCells))
+		for i, cell := range rowCells {
+			padded[i] = cell + strings.Repeat(" ", widths[i]-displayWidth(cell))
+		}
+		formatted = append(formatted, strings.TrimRight(strings.Join(padded, " | "), " "))
+		if r == 0 {
+			separators := make([]string, len(widths))
+			for i, width := range widths {
+				separators[i] = strings.Repeat("-", width)
+			}
+			formatted = append(formatted, strings.Join(separators, "-+-"))
+		}
+	}
+	return formatted
+}
+
+func displayWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) ||
+			(r >= 0xFF01 && r <= 0xFF60) || (r >= 0x3000 && r <= 0x303F) {
+			width += 2
+			continue
+		}
+		width++
+	}
+	return width
+}
+
+// chunkLines 行の区切りで maxLength 文字以内に分ける。1行で超える場合はその行を途中で分ける
+// 装飾の印は applyMarks で記号とゼロ幅スペースになるため2文字として数える
+func chunkLines(lines []string, maxLength int) []string {
+	var chunks []string
+	var current strings.Builder
+	currentLength := 0
+	flush := func() {
+		if current.Len() > 0 {
+			chunks = append(chunks, current.String())
+			current.Reset()
+			currentLength = 0
+		}
+	}
+
+	for _, line := range lines {
+		parts := splitLine(line, maxLength)
+		for _, part := range parts[:len(parts)-1] {
+			flush()
+			chunks = append(chunks, part)
+		}
+		line = parts[len(parts)-1]
+		length := markedLength([]rune(line))
+		if currentLength > 0 && currentLength+length+1 > maxLength {
+			flush()
+		}
+		if current.Len() > 0 {
+			current.WriteString("\n")
+			currentLength++
+		}
+		current.WriteString(line)
+		currentLength += length
+	}
+	flush()
+	return chunks
+}
+
+// splitLine maxLength を超える行を分ける。装飾の途中では分けず、1つの装飾だけで超える場合は
+// 分けた位置で装飾を閉じ、次の部分で開き直す
+func splitLine(line string, maxLength int) []string {
+	runes := []rune(line)
+	var parts []string
+	for markedLength(runes) > maxLength {
+		// 装飾がある場合は、閉じる印の分を空けておく
+		limit := maxLength
+		if markedLength(runes) != len(runes) {
+			limit -= 2 * len(markSymbols)
+		}
+		var open []rune
+		end, boundary, length := 0, 0, 0
+		for i, r := range runes {
+			if length+runeLength(r) > limit {
+				break
+			}
+			length += runeLength(r)
+			if _, ok := markSymbols[r]; ok {
+				open = toggleMark(open, r)
+			}
+			end = i + 1
+			if len(open) == 0 {
+				boundary = end
+			}
+		}
+		if boundary > 0 {
+			parts = append(parts, string(runes[:boundary]))
+			runes = runes[boundary:]
+			continue
+		}
+
+		// 閉じていない装飾を閉じてから分け、次の部分で同じ順に開き直す
+		end = max(end, 1)
+		part := append([]rune{}, runes[:end]...)
+		for i := len(open) - 1; i >= 0; i-- {
+			part = append(part, open[i])
+		}
+		parts = append(parts, string(part))
+		runes = append(append([]rune{}, open...), runes[end:]...)
+	}
+	return append(parts, string(runes))
+}
+
+// markSymbols 装飾の印と置き換えるmrkdwnの記号
+var markSymbols = map[rune]string{boldMark: "*", italicMark: "_", strikeMark: "~"}
+
+// toggleMark 開いている装飾の一覧に印を加える。すでに開いている場合は閉じる
+func toggleMark(open []rune, mark rune) []rune {
+	for i, r := range open {
+		if r == mark {
+			return append(open[:i:i], open[i+1:]...)
+		}
+	}
+	return append(open, mark)
+}
+
+func runeLength(r rune) int {
+	if _, ok := markSymbols[r]; ok {
+		return 2
+	}
+	return 1
+}
+
+func markedLength(runes []rune) int {
+	length := 0
+	for _, r := range runes {
+		length += runeLength(r)
+	}
+	return length
+}
+
+func truncateRunes(s string, maxLength int) string {
+	runes := []rune(s)
+	if len(runes) <= maxLength {
+		return s
+	}
+	return string(runes[:maxLength-1]) + "…"
+}
@@ -0,0 +1,269 @@
+package model
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/slack-go/slack"
+)
+
+func TestToMrkdwn(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown string
+		want     string
+	}{
+		{
+			name:     "plain text",
+			markdown: "こんにちは。今日はいい天気ですね。",
+			want:     "こんにちは。今日はいい天気ですね。",
+		},
+		{
+			name:     "bold and italic",
+			markdown: "This is **bold**, *italic* and ~~strike~~.",
+			want:     "This is *bold*, _italic_ and ~strike~.",
+		},
+		{
+			name:     "bold next to japanese",
+			markdown: "これは**重要**です",
+			want:     "これは​*重要*​です",
+		},
+		{
+			name:     "underscore bold",
+			markdown: "__注意__ してください",
+			want:     "*注意* してください",
+		},
+		{
+			name:     "dunder identifiers are not bold",
+			markdown: "__init__ と obj.__init__ と snake__case__name を呼ぶ",
+			want:     "__init__ と obj.__init__ と snake__case__name を呼ぶ",
+		},
+		{
+			name:     "links and images",
+			markdown: "詳しくは[公式ドキュメント](https://go.dev/doc)と![図](https://example.com/a.png)を参照",
+			want:     "詳しくは<https://go.dev/doc|公式ドキュメント>と<https://example.com/a.png|図>を参照",
+		},
+		{
+			name:     "headings",
+			markdown: "## 手順\n### 1. **準備**\n本文",
+			want:     "*手順*\n*1. 準備*\n本文",
+		},
+		{
+			name:     "bullet and nested lists",
+			markdown: "- りんご\n  - ふじ\n* みかん\n+ ぶどう",
+			want:     "• りんご\n    ◦ ふじ\n• みかん\n• ぶどう",
+		},
+		{
+			name:     "ordered list",
+			markdown: "1. 準備する\n2) **実行**する\n   1. 確認する",
+			want:     "1. 準備する\n2. *実行*​する\n    1. 確認する",
+		},
+		{
+			name:     "task list",
+			markdown: "- [x] 完了\n- [ ] 未完了",
+			want:     "• ☑ 完了\n• ☐ 未完了",
+		},
+		{
+			name:     "block quote",
+			markdown: "> 引用された **文章**",
+			want:     "> 引用された *文章*",
+		},
+		{
+			name:     "inline code is not converted",
+			markdown: "`**not bold**` と `a < b` は **bold**",
+			want:     "`**not bold**` と `a &lt; b` は *bold*",
+		},
+		{
+			name:     "code fence drops language and keeps content",
+			markdown: "例:\n```go\nfunc main() {\n\t// **comment**\n\tif a < b && c > d {}\n}\n```\n以上",
+			want:     "例:\n```\nfunc main() {\n\t// **comment**\n\tif a &lt; b &amp;&amp; c &gt; d {}\n}\n```\n以上",
+		},
+		{
+			name:     "unclosed fence while streaming",
+			markdown: "```python\nprint('hi')",
+			want:     "```\nprint('hi')\n```",
+		},
+		{
+			name:     "tilde fence",
+			markdown: "~~~\n# not a heading\n~~~",
+			want:     "```\n# not a heading\n```",
+		},
+		{
+			name:     "horizontal rule",
+			markdown: "上\n---\n下",
+			want:     "上\n――――――――――\n下",
+		},
+		{
+			name:     "table becomes aligned code block",
+			markdown: "| 名前 | 役割 |\n|------|:----:|\n| Go | **言語** |\n| Slack | chat |",
+			want:     "```\n名前  | 役割\n------+-----\nGo    | 言語\nSlack | chat\n```",
+		},
+		{
+			name:     "escape special characters",
+			markdown: "a < b & c > d",
+			want:     "a &lt; b &amp; c &gt; d",
+		},
+		{
+			name:     "asterisks in math are not italic",
+			markdown: "2 * 3 * 4 = 24",
+			want:     "2 * 3 * 4 = 24",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ToMrkdwn(tt.markdown); got != tt.want {
+				t.Errorf("ToMrkdwn() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToBlocks(t *testing.T) {
+	markdown := strings.Join([]string{
+		"# 概要",
+		"Goの**特徴**を説明します。",
+		"",
+		"- シンプル",
+		"- 高速",
+		"---",
+		"```go",
+		"fmt.Println(\"hi\")",
+		"```",
+		"| a | b |",
+		"|---|---|",
+		"| 1 | 2 |",
+	}, "\n")
+
+	blocks := ToBlocks(markdown)
+
+	wantTypes := []slack.MessageBlockType{
+		slack.MBTHeader,
+		slack.MBTSection,
+		slack.MBTDivider,
+		slack.MBTSection,
+		slack.MBTSection,
+	}
+	if len(blocks) != len(wantTypes) {
+		t.Fatalf("len(blocks) = %d, want %d: %+v", len(blocks), len(wantTypes), blocks)
+	}
+	for i, block := range blocks {
+		if block.BlockType() != wantTypes[i] {
+			t.Errorf("blocks[%d] = %s, want %s", i, block.BlockType(), wantTypes[i])
+		}
+	}
+
+	if header := blocks[0].(*slack.HeaderBlock); header.Text.Text != "概要" {
+		t.Errorf("header = %q", header.Text.Text)
+	}
+	if text := blocks[1].(*slack.SectionBlock).Text.Text; text != "Goの​*特徴*​を説明します。\n\n• シンプル\n• 高速" {
+		t.Errorf("section = %q", text)
+	}
+	if text := blocks[3].(*slack.SectionBlock).Text.Text; text != "```\nfmt.Println(\"hi\")\n```" {
+		t.Errorf("code = %q", text)
+	}
+	if text := blocks[4].(*slack.SectionBlock).Text.Text; text != "```\na | b\n--+--\n1 | 2\n```" {
+		t.Errorf("table = %q", text)
+	}
+}
+
+func TestToBlocksSplitsLongSections(t *testing.T) {
+	line := strings.Repeat("あ", 1000)
+	code := "```\n" + strings.Repeat(line+"\n", 7) + "```"
+
+	blocks := ToBlocks(code)
+
+	if len(blocks) < 3 {
+		t.Fatalf("len(blocks) = %d, want the code split into several sections", len(blocks))
+	}
+	for i, block := range blocks {
+		text := block.(*slack.SectionBlock).Text.Text
+		if n := len([]rune(text)); n > maxSectionTextLength {
+			t.Errorf("blocks[%d] has %d characters", i, n)
+		}
+		if !strings.HasPrefix(text, "```\n") || !strings.HasSuffix(text, "\n```") {
+			t.Errorf("blocks[%d] is not a closed code block", i)
+		}
+	}
+}
+
+func TestToBlocksDoesNotSplitInsideSpans(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown string
+	}{
+		{
+			// 区切りの位置が太字の途中になる
+			name:     "span across the limit",
+			markdown: strings.Repeat("あ", maxSectionTextLength-10) + " **" + strings.Repeat("い", 20) + "** おわり",
+		},
+		{
+			// 1つの装飾だけで上限を超える
+			name:     "span longer than the limit",
+			markdown: "**" + strings.Repeat("う", maxSectionTextLength+100) + "** と _" + "斜体" + "_",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blocks := ToBlocks(tt.markdown)
+			if len(blocks) < 2 {
+				t.Fatalf("len(blocks) = %d, want the text split into several sections", len(blocks))
+			}
+			for i, block := range blocks {
+				text := block.(*slack.SectionBlock).Text.Text
+				if n := len([]rune(text)); n > maxSectionTextLength {
+					t.Errorf("blocks[%d] has %d characters", i, n)
+				}
+				// 装飾の記号は各セクションの中で閉じている
+				if n := strings.Count(text, "*"); n%2 != 0 {
+					t.Errorf("blocks[%d] has an unclosed bold span: %d asterisks", i, n)
+				}
+			}
+		})
+	}
+}
+
+func TestNewBotReply(t *testing.T) {
+	tests := []struct {
+		name       string
+		markdown   string
+		blockKit   bool
+		wantText   string
+		wantBlocks int
+	}{
+		{
+			name:       "text only",
+			markdown:   "# 結果\n**OK**",
+			blockKit:   false,
+			wantText:   "*結果*\n*OK*",
+			wantBlocks: 0,
+		},
+		{
+			name:       "block kit",
+			markdown:   "# 結果\n**OK**",
+			blockKit:   true,
+			wantText:   "*結果*\n*OK*",
+			wantBlocks: 2,
+		},
+		{
+			name:       "too many blocks falls back to text",
+			markdown:   strings.Repeat("本文\n---\n", MaxMessageBlocks),
+			blockKit:   true,
+			wantText:   strings.TrimSuffix(strings.Repeat("本文\n――――――――――\n", MaxMessageBlocks), "\n"),
+			wantBlocks: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply := NewBotReply(tt.markdown, tt.blockKit)
+			if reply.Text != tt.wantText {
+				t.Errorf("Text = %q, want %q", reply.Text, tt.wantText)
+			}
+			if len(reply.Blocks) != tt.wantBlocks {
+				t.Errorf("len(Blocks) = %d, want %d", len(reply.Blocks), tt.wantBlocks)
+			}
+		})
+	}
+}
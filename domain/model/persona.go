@@ -0,0 +1,157 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// BuiltinPersonaName 設定ファイルがなくても使える組み込みのペルソナ（シスターズ）
+const BuiltinPersonaName = "sisters"
+
+// Persona チャンネルごとに切り替えるBotの人格（システムプロンプト・モデル・温度・LLMのプロバイダー）
+type Persona struct {
+	Name         string  `yaml:"name" json:"name"`
+	SystemPrompt string  `yaml:"system_prompt" json:"system_prompt"`
+	Model        string  `yaml:"model" json:"model"`
+	Temperature  float32 `yaml:"temperature" json:"temperature"` // 0の場合はモデルのデフォルト
+	Provider     string  `yaml:"provider" json:"provider"`       // 空の場合はデフォルトのプロバイダー
+}
+
+// PersonaRule チャンネルとペルソナの対応。ChannelIDs、ChannelPattern、DMのいずれかに一致すれば適用する
+type PersonaRule struct {
+	ChannelIDs     []string `yaml:"channel_ids" json:"channel_ids"`
+	ChannelPattern string   `yaml:"channel_pattern" json:"channel_pattern"` // チャンネル名の正規表現
+	DM             bool     `yaml:"dm" json:"dm"`
+	Persona        string   `yaml:"persona" json:"persona"`
+	Provider       string   `yaml:"provider" json:"provider"` // 指定した場合はペルソナのプロバイダーの代わりに使う
+
+	pattern *regexp.Regexp
+}
+
+// PersonaConfig ペルソナの設定。ルールは上から順に評価し、どれにも一致しなければ DefaultPersona を使う
+type PersonaConfig struct {
+	DefaultPersona string        `yaml:"default_persona" json:"default_persona"`
+	Personas       []Persona     `yaml:"personas" json:"personas"`
+	Rules          []PersonaRule `yaml:"rules" json:"rules"`
+}
+
+// ChannelInfo ペルソナの選択に使うチャンネルの情報
+type ChannelInfo struct {
+	ID      string
+	Name    string
+	IsIM    bool
+	Creator string // チャンネルを作成したユーザーのID
+}
+
+func BuiltinPersona() Persona {
+	return Persona{
+		Name:         BuiltinPersonaName,
+		SystemPrompt: CharacterSettings,
+		Model:        DefaultChatModel,
+	}
+}
+
+// NewBuiltinPersonaConfig 組み込みのペルソナだけを使う設定
+func NewBuiltinPersonaConfig() *PersonaConfig {
+	return &PersonaConfig{
+		DefaultPersona: BuiltinPersonaName,
+	}
+}
+
+// Prepare 設定を検証し、組み込みのペルソナの追加と正規表現のコンパイルを行う
+func (c *PersonaConfig) Prepare() error {
+	if c.DefaultPersona == "" {
+		c.DefaultPersona = BuiltinPersonaName
+	}
+
+	names := make(map[string]bool, len(c.Personas)+1)
+	for i, persona := range c.Personas {
+		if persona.Name == "" {
+			return fmt.Errorf("personas[%d]: name is required", i)
+		}
+		if names[persona.Name] {
+			return fmt.Errorf("personas[%d]: duplicate name %q", i, persona.Name)
+		}
+		if persona.SystemPrompt == "" {
+			return fmt.Errorf("personas[%d]: system_prompt is required", i)
+		}
+		if persona.Model == "" {
+			c.Personas[i].Model = DefaultChatModel
+		}
+		names[persona.Name] = true
+	}
+	// 同名のペルソナが定義されていなければ組み込みのペルソナを使えるようにする
+	if !names[BuiltinPersonaName] {
+		c.Personas = append(c.Personas, BuiltinPersona())
+		names[BuiltinPersonaName] = true
+	}
+
+	if !names[c.DefaultPersona] {
+		return fmt.Errorf("default_persona: unknown persona %q", c.DefaultPersona)
+	}
+	for i, rule := range c.Rules {
+		if !names[rule.Persona] {
+			return fmt.Errorf("rules[%d]: unknown persona %q", i, rule.Persona)
+		}
+		if len(rule.ChannelIDs) == 0 && rule.ChannelPattern == "" && !rule.DM {
+			return fmt.Errorf("rules[%d]: one of channel_ids, channel_pattern or dm is required", i)
+		}
+		if rule.ChannelPattern != "" {
+			pattern, err := regexp.Compile(rule.ChannelPattern)
+			if err != nil {
+				return fmt.Errorf("rules[%d]: invalid channel_pattern: %w", i, err)
+			}
+			c.Rules[i].pattern = pattern
+		}
+	}
+	return nil
+}
+
+// Persona 名前からペルソナを取得する
+func (c *PersonaConfig) Persona(name string) (Persona, bool) {
+	for _, persona := range c.Personas {
+		if persona.Name == name {
+			return persona, true
+		}
+	}
+	if name == BuiltinPersonaName {
+		return BuiltinPersona(), true
+	}
+	return Persona{}, false
+}
+
+// Resolve チャンネルに適用するペルソナと、一致したルールで指定したプロバイダーを返す
+// ルールのプロバイダーは /gpt コマンドでペルソナを変えても守るため、ペルソナとは別に返す
+func (c *PersonaConfig) Resolve(channel ChannelInfo) (Persona, string) {
+	for _, rule := range c.Rules {
+		if rule.matches(channel) {
+			if persona, ok := c.Persona(rule.Persona); ok {
+				return persona, rule.Provider
+			}
+		}
+	}
+	if persona, ok := c.Persona(c.DefaultPersona); ok {
+		return persona, ""
+	}
+	return BuiltinPersona(), ""
+}
+
+// WithProvider provider を指定した場合はペルソナのプロバイダーの代わりに使う
+func (p Persona) WithProvider(provider string) Persona {
+	if provider != "" {
+		p.Provider = provider
+	}
+	return p
+}
+
+func (r *PersonaRule) matches(channel ChannelInfo) bool {
+	if r.DM && channel.IsIM {
+		return true
+	}
+	for _, id := range r.ChannelIDs {
+		if id == channel.ID {
+			return true
+		}
+	}
+	return r.pattern != nil && channel.Name != "" && r.pattern.MatchString(channel.Name)
+}
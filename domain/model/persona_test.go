@@ -0,0 +1,163 @@
+package model
+
+import "testing"
+
+func TestPersonaConfigResolve(t *testing.T) {
+	config := &PersonaConfig{
+		DefaultPersona: "assistant",
+		Personas: []Persona{
+			{Name: "assistant", SystemPrompt: "You are a helpful assistant."},
+			{Name: "incident", SystemPrompt: "You are an SRE.", Model: "gpt-4o-mini", Temperature: 0.2, Provider: "azure"},
+		},
+		Rules: []PersonaRule{
+			{ChannelIDs: []string{"C_RANDOM"}, Persona: BuiltinPersonaName},
+			{ChannelIDs: []string{"C_PRIVATE"}, Persona: "incident", Provider: "local"},
+			{ChannelPattern: "^eng-incidents", Persona: "incident"},
+			{DM: true, Persona: BuiltinPersonaName},
+		},
+	}
+	if err := config.Prepare(); err != nil {
+		t.Fatalf("Prepare() error = %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		channel      ChannelInfo
+		want         string
+		wantProvider string
+	}{
+		{
+			name:    "channel id",
+			channel: ChannelInfo{ID: "C_RANDOM", Name: "random"},
+			want:    BuiltinPersonaName,
+		},
+		{
+			name:         "channel name pattern",
+			channel:      ChannelInfo{ID: "C_INCIDENT", Name: "eng-incidents-2024"},
+			want:         "incident",
+			wantProvider: "azure",
+		},
+		{
+			name:         "rule overrides provider",
+			channel:      ChannelInfo{ID: "C_PRIVATE", Name: "eng-incidents-private"},
+			want:         "incident",
+			wantProvider: "local",
+		},
+		{
+			name:    "direct message",
+			channel: ChannelInfo{ID: "D12345", IsIM: true},
+			want:    BuiltinPersonaName,
+		},
+		{
+			name:    "default persona",
+			channel: ChannelInfo{ID: "C_GENERAL", Name: "general"},
+			want:    "assistant",
+		},
+		{
+			name:    "channel name unknown",
+			channel: ChannelInfo{ID: "C_UNKNOWN"},
+			want:    "assistant",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			persona, provider := config.Resolve(tt.channel)
+			got := persona.WithProvider(provider)
+			if got.Name != tt.want {
+				t.Errorf("Resolve() = %v, want %v", got.Name, tt.want)
+			}
+			if got.Provider != tt.wantProvider {
+				t.Errorf("Resolve().Provider = %v, want %v", got.Provider, tt.wantProvider)
+			}
+			if got.Model == "" {
+				t.Errorf("Resolve().Model should default to %v", DefaultChatModel)
+			}
+		})
+	}
+}
+
+func TestRuleProviderSurvivesPreferences(t *testing.T) {
+	config := &PersonaConfig{
+		DefaultPersona: "assistant",
+		Personas: []Persona{
+			{Name: "assistant", SystemPrompt: "You are a helpful assistant."},
+		},
+		Rules: []PersonaRule{
+			{ChannelPattern: "^secret-", Persona: "assistant", Provider: "local"},
+		},
+	}
+	if err := config.Prepare(); err != nil {
+		t.Fatalf("Prepare() error = %v", err)
+	}
+
+	persona, provider := config.Resolve(ChannelInfo{ID: "C_SECRET", Name: "secret-project"})
+	for _, name := range []string{BuiltinPersonaName, "assistant"} {
+		t.Run(name, func(t *testing.T) {
+			// ユーザーがペルソナを選んでも、ルールで指定したプロバイダーに送る
+			user := &Preference{Scope: PreferenceScopeUser, ID: "U1", Persona: name}
+			got := ApplyPreferences(persona, config.Persona, user).WithProvider(provider)
+			if got.Name != name || got.Provider != "local" {
+				t.Errorf("persona = %v, provider = %q, want %v and local", got.Name, got.Provider, name)
+			}
+		})
+	}
+}
+
+func TestPersonaConfigPrepare(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  PersonaConfig
+		wantErr bool
+	}{
+		{
+			name:    "empty config uses builtin persona",
+			config:  PersonaConfig{},
+			wantErr: false,
+		},
+		{
+			name: "unknown default persona",
+			config: PersonaConfig{
+				DefaultPersona: "unknown",
+			},
+			wantErr: true,
+		},
+		{
+			name: "rule refers to unknown persona",
+			config: PersonaConfig{
+				Rules: []PersonaRule{{DM: true, Persona: "unknown"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "rule without condition",
+			config: PersonaConfig{
+				Rules: []PersonaRule{{Persona: BuiltinPersonaName}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid channel pattern",
+			config: PersonaConfig{
+				Rules: []PersonaRule{{ChannelPattern: "(", Persona: BuiltinPersonaName}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "persona without system prompt",
+			config: PersonaConfig{
+				Personas: []Persona{{Name: "empty"}},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Prepare()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Prepare() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package model
+
+type PreferenceScope string
+
+const (
+	PreferenceScopeUser    PreferenceScope = "user"
+	PreferenceScopeChannel PreferenceScope = "channel"
+)
+
+// SelectableModels スラッシュコマンドで選択できるモデル。プロバイダーを指定していないペルソナで使う
+var SelectableModels = []string{"gpt-4o", "gpt-4o-mini"}
+
+// ProviderSelectableModels プロバイダーごとにスラッシュコマンドで選択できるモデル
+// 登録のないプロバイダーのペルソナでは、送信先にないモデルを選ばないようモデルを変更できない
+var ProviderSelectableModels = map[string][]string{}
+
+// Preference ユーザーまたはチャンネルごとの設定。空の項目はペルソナの設定を使う
+type Preference struct {
+	Scope   PreferenceScope
+	ID      string // ユーザーIDまたはチャンネルID
+	Persona string
+	Model   string
+}
+
+// CanChangeChannelPreference チャンネル全体の設定を変更できるユーザーかどうか。チャンネルの作成者とワークスペースの管理者に限る
+func CanChangeChannelPreference(user SlackUser, channel ChannelInfo) bool {
+	if user.IsAdmin {
+		return true
+	}
+	return channel.Creator != "" && channel.Creator == user.ID
+}
+
+// SelectableModelsFor ペルソナのプロバイダーでスラッシュコマンドから選択できるモデルを返す
+func SelectableModelsFor(provider string) []string {
+	if models, ok := ProviderSelectableModels[provider]; ok {
+		return models
+	}
+	if provider == "" {
+		return SelectableModels
+	}
+	return nil
+}
+
+// IsSelectableModel ペルソナのプロバイダーでスラッシュコマンドから選択できるモデルかどうか
+func IsSelectableModel(provider string, modelName string) bool {
+	for _, m := range SelectableModelsFor(provider) {
+		if m == modelName {
+			return true
+		}
+	}
+	return false
+}
+
+// ApplyPreferences チャンネル、ユーザーの順に設定をペルソナに反映する（ユーザーの設定を優先する）
+// lookup は名前からペルソナを取得する関数で、存在しないペルソナの設定は無視する
+func ApplyPreferences(persona Persona, lookup func(name string) (Persona, bool), preferences ...*Preference) Persona {
+	for _, preference := range preferences {
+		if preference == nil {
+			continue
+		}
+		if preference.Persona != "" {
+			if p, ok := lookup(preference.Persona); ok {
+				persona = p
+			}
+		}
+		if preference.Model != "" {
+			persona.Model = preference.Model
+		}
+	}
+	return persona
+}
@@ -0,0 +1,53 @@
+package model
+
+import "testing"
+
+func TestCanChangeChannelPreference(t *testing.T) {
+	channel := ChannelInfo{ID: "C1", Creator: "UCREATOR"}
+
+	tests := []struct {
+		name    string
+		user    SlackUser
+		channel ChannelInfo
+		want    bool
+	}{
+		{name: "channel creator", user: SlackUser{ID: "UCREATOR"}, channel: channel, want: true},
+		{name: "workspace admin", user: SlackUser{ID: "UADMIN", IsAdmin: true}, channel: channel, want: true},
+		{name: "other member", user: SlackUser{ID: "UMEMBER"}, channel: channel, want: false},
+		{name: "channel without creator", user: SlackUser{ID: ""}, channel: ChannelInfo{ID: "D1", IsIM: true}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CanChangeChannelPreference(tt.user, tt.channel); got != tt.want {
+				t.Errorf("CanChangeChannelPreference() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsSelectableModel(t *testing.T) {
+	defer func(models map[string][]string) { ProviderSelectableModels = models }(ProviderSelectableModels)
+	ProviderSelectableModels = map[string][]string{"local": {"llama3.1:8b"}}
+
+	tests := []struct {
+		name      string
+		provider  string
+		modelName string
+		want      bool
+	}{
+		{name: "default provider", provider: "", modelName: "gpt-4o-mini", want: true},
+		{name: "default provider with unknown model", provider: "", modelName: "gpt-3.5-turbo", want: false},
+		{name: "provider with models", provider: "local", modelName: "llama3.1:8b", want: true},
+		{name: "OpenAI model on local provider", provider: "local", modelName: "gpt-4o", want: false},
+		{name: "provider without models", provider: "azure", modelName: "gpt-4o", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsSelectableModel(tt.provider, tt.modelName); got != tt.want {
+				t.Errorf("IsSelectableModel(%q, %q) = %v, want %v", tt.provider, tt.modelName, got, tt.want)
+			}
+		})
+	}
+}
@@ -3,22 +3,48 @@ package model
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/slack-go/slack"
 )
 
 const (
-	LimitMessage     = "本日の利用制限を超えました。明日以降に再度お試しください。"
-	MaxFetchMessages = 20
+	LimitMessage          = "本日の利用制限を超えました。明日以降に再度お試しください。"
+	UserLimitMessage      = "本日のあなたの利用制限を超えました。明日以降に再度お試しください。"
+	UserImageLimitMessage = "本日のあなたの画像の作成回数の上限を超えました。明日以降に再度お試しください。"
+	ImageLimitMessage     = "本日の画像の作成回数の上限を超えました。明日以降に再度お試しください。"
+	BusyMessage           = "混み合っています。時間をおいて再度お試しください。"
+	EmptyResponseMessage  = "GPTレスポンスが空です。"
+	PlaceholderMessage    = "回答を作成しています..."
+	ErrorMessage          = "回答の作成中にエラーが発生しました。時間をおいて再度お試しください。"
+	StreamingSuffix       = " ..."
+	// StreamUpdateInterval ストリーミング中にSlackのメッセージを更新する間隔
+	StreamUpdateInterval = time.Second
 )
 
 type SlackMessage struct {
-	Text string // メッセージの内容
-	User string // メッセージを送信したユーザーのID
+	TS    string            // メッセージのts
+	Text  string            // メッセージの内容
+	User  string            // メッセージを送信したユーザーのID
+	BotID string            // Botが送信したメッセージの場合のBotのID
+	Files []SlackAttachment // 添付ファイル
+	// 添付ファイルを読み込んだ結果（GPTに送る画像・テキストと、読み込めなかったファイルの注記）
+	Images []ChatImage
+	Texts  []TextAttachment
+	Notes  []string
 }
 
 type SlackMessages []SlackMessage
 
+// SlackFile Slackにアップロードするファイル
+type SlackFile struct {
+	Filename string
+	Title    string
+	Comment  string // ファイルと一緒に投稿するメッセージ
+	AltText  string // 画像の代替テキスト
+	Content  []byte
+}
+
 type BotMessage struct {
 	Client       *slack.Client
 	ChannelID    string
@@ -26,9 +52,9 @@ type BotMessage struct {
 	ControllerTS string
 }
 
-func (m *SlackMessage) OptimizeMessage(botUserID string) string {
-	// botの場合はbotであることもわかるようにIDとBOTをセットでメッセージを整形
-	text := strings.ReplaceAll(m.Text, botUserID, "[GptBot]")
+// OptimizeMessage メンションなどのIDを読める名前に置き換え、1行にまとめる
+func (m *SlackMessage) OptimizeMessage(directory SlackDirectory) string {
+	text := directory.FormatText(m.Text)
 	text = strings.TrimSpace(text)
 	text = formatMessage(text)
 	return text
@@ -38,42 +64,80 @@ func formatMessage(text string) string {
 	return strings.ReplaceAll(text, "\n", " ") // 改行をスペースに置換
 }
 
-func (messages SlackMessages) extractConversationFlow(botUserID string) []map[string]string {
-	var flow []map[string]string
-	for _, message := range messages {
-		flow = append(flow, map[string]string{
-			"speaker": message.User,                       // 発言者を取得
-			"message": message.OptimizeMessage(botUserID), // メッセージを整形
-		})
+// IsBot このBot（GPT）の発言かどうか。他のBotや連携アプリの投稿はこのBotの発言としない
+func (m *SlackMessage) IsBot(botUserID string, botID string) bool {
+	if m.User != "" && m.User == botUserID {
+		return true
 	}
-	return flow
+	return m.BotID != "" && m.BotID == botID
 }
 
-func (messages SlackMessages) LimitMessages(maxMessages int) SlackMessages {
-	if len(messages) > maxMessages {
-		return messages[len(messages)-maxMessages:] // 最新の`maxMessages`件のみ保持
+// Speaker 発言者のID。ユーザーのいない連携アプリの投稿はBotのIDとする
+func (m *SlackMessage) Speaker() string {
+	if m.User == "" {
+		return m.BotID
 	}
-	return messages
+	return m.User
 }
 
-func (messages SlackMessages) CreatePrompt(botUserID string) string {
-	messages = messages.LimitMessages(MaxFetchMessages)
+// CreateConversation スレッドの履歴をGPTに送る会話に変換する
+// Botの発言はassistant、それ以外は発言者の名前を付けたuserのターンとし、連続する同じ役割の発言は1つのターンにまとめる
+func (messages SlackMessages) CreateConversation(systemPrompt string, directory SlackDirectory) Conversation {
+	conversation := Conversation{
+		SystemPrompt: systemPrompt + SpeakerFormatGuide,
+	}
+	for _, message := range messages {
+		role := ChatRoleUser
+		content := fmt.Sprintf("%s: %s", directory.UserName(message.Speaker()), message.OptimizeMessage(directory))
+		if message.IsBot(directory.BotUserID, directory.BotID) {
+			role = ChatRoleAssistant
+			content = message.OptimizeMessage(directory)
+		}
+		if len(message.Notes) > 0 {
+			content += " " + strings.Join(message.Notes, " ")
+		}
+		// テキストの添付ファイルは改行を残したまま発言に続ける
+		for _, text := range message.Texts {
+			content += "\n" + text.Block()
+		}
 
-	var builder strings.Builder
-	builder.WriteString("以下はSlackスレッドの履歴を含んだGPTプロンプトです。下記を踏まえて答えてください\n")
-	for _, flow := range messages.extractConversationFlow(botUserID) {
-		builder.WriteString(fmt.Sprintf("%s, message: %s\n", flow["speaker"], flow["message"]))
+		last := len(conversation.Messages) - 1
+		if last >= 0 && conversation.Messages[last].Role == role {
+			conversation.Messages[last].Content += "\n" + content
+			conversation.Messages[last].Images = append(conversation.Messages[last].Images, message.Images...)
+			conversation.Messages[last].TS = message.TS
+			continue
+		}
+		conversation.Messages = append(conversation.Messages, ChatMessage{
+			Role:    role,
+			Content: content,
+			Images:  message.Images,
+			TS:      message.TS,
+		})
 	}
-	return builder.String()
+	return conversation
 }
 
 func ConvertToSlackMessages(messages []slack.Message) SlackMessages {
 	var slackMessages SlackMessages
 	for _, message := range messages {
-		slackMessages = append(slackMessages, SlackMessage{
-			Text: message.Text,
-			User: message.User,
-		})
+		slackMessage := SlackMessage{
+			TS:    message.Timestamp,
+			Text:  message.Text,
+			User:  message.User,
+			BotID: message.BotID,
+		}
+		for _, file := range message.Files {
+			slackMessage.Files = append(slackMessage.Files, SlackAttachment{
+				ID:       file.ID,
+				Name:     file.Name,
+				MimeType: file.Mimetype,
+				Mode:     file.Mode,
+				Size:     file.Size,
+				URL:      file.URLPrivateDownload,
+			})
+		}
+		slackMessages = append(slackMessages, slackMessage)
 	}
 	return slackMessages
 }
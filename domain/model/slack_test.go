@@ -1,6 +1,7 @@
 package model
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -18,7 +19,7 @@ func TestOptimizeMessage(t *testing.T) {
 				User: "U12345",
 			},
 			botUserID: "botUserID",
-			want:      "Hello <@[GptBot]>!",
+			want:      "Hello @[GptBot]!",
 		},
 		{
 			name: "Message without bot user ID",
@@ -36,7 +37,7 @@ func TestOptimizeMessage(t *testing.T) {
 				User: "U12345",
 			},
 			botUserID: "botUserID",
-			want:      "Hello <@[GptBot]>!",
+			want:      "Hello @[GptBot]!",
 		},
 		{
 			name: "Message with newlines",
@@ -45,13 +46,13 @@ func TestOptimizeMessage(t *testing.T) {
 				User: "U12345",
 			},
 			botUserID: "botUserID",
-			want:      "Hello <@[GptBot]>!",
+			want:      "Hello @[GptBot]!",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := tt.message.OptimizeMessage(tt.botUserID)
+			got := tt.message.OptimizeMessage(SlackDirectory{BotUserID: tt.botUserID})
 			if got != tt.want {
 				t.Errorf("OptimizeMessage() = %v, want %v", got, tt.want)
 			}
@@ -59,12 +60,12 @@ func TestOptimizeMessage(t *testing.T) {
 	}
 }
 
-func TestExtractConversationFlow(t *testing.T) {
+func TestCreateConversation(t *testing.T) {
 	tests := []struct {
 		name      string
 		messages  SlackMessages
 		botUserID string
-		want      []map[string]string
+		want      []ChatMessage
 	}{
 		{
 			name: "Single message",
@@ -75,54 +76,150 @@ func TestExtractConversationFlow(t *testing.T) {
 				},
 			},
 			botUserID: "botUserID",
-			want: []map[string]string{
+			want: []ChatMessage{
+				{Role: ChatRoleUser, Content: "U12345: Hello @[GptBot]!"},
+			},
+		},
+		{
+			name: "Bot replies become assistant turns",
+			messages: SlackMessages{
+				{
+					Text: "Hello <@botUserID>!",
+					User: "U12345",
+				},
 				{
-					"speaker": "U12345",
-					"message": "Hello <@[GptBot]>!",
+					Text:  "Hello!",
+					User:  "botUserID",
+					BotID: "B12345",
+				},
+				{
+					Text: "How are you?",
+					User: "U12345",
 				},
 			},
+			botUserID: "botUserID",
+			want: []ChatMessage{
+				{Role: ChatRoleUser, Content: "U12345: Hello @[GptBot]!"},
+				{Role: ChatRoleAssistant, Content: "Hello!"},
+				{Role: ChatRoleUser, Content: "U12345: How are you?"},
+			},
 		},
 		{
-			name: "Multiple messages",
+			name: "Other bots and integrations stay user turns",
 			messages: SlackMessages{
 				{
 					Text: "Hello <@botUserID>!",
 					User: "U12345",
 				},
 				{
+					Text:  "Build failed",
+					BotID: "BCI",
+				},
+				{
+					Text:  "Alert fired",
+					User:  "UAPP",
+					BotID: "BALERT",
+				},
+			},
+			botUserID: "botUserID",
+			want: []ChatMessage{
+				{Role: ChatRoleUser, Content: "U12345: Hello @[GptBot]!\nBCI: Build failed\nUAPP: Alert fired"},
+			},
+		},
+		{
+			name: "Messages with our bot ID are assistant turns",
+			messages: SlackMessages{
+				{
+					Text: "Hello <@botUserID>!",
+					User: "U12345",
+				},
+				{
+					Text:  "Hello!",
+					BotID: "B12345",
+				},
+			},
+			botUserID: "botUserID",
+			want: []ChatMessage{
+				{Role: ChatRoleUser, Content: "U12345: Hello @[GptBot]!"},
+				{Role: ChatRoleAssistant, Content: "Hello!"},
+			},
+		},
+		{
+			name: "Consecutive user messages are merged",
+			messages: SlackMessages{
+				{
+					TS:   "1700000000.000100",
+					Text: "Hello <@botUserID>!",
+					User: "U12345",
+				},
+				{
+					TS:   "1700000000.000200",
 					Text: "How are you?",
 					User: "U67890",
 				},
 			},
 			botUserID: "botUserID",
-			want: []map[string]string{
+			want: []ChatMessage{
+				{Role: ChatRoleUser, Content: "U12345: Hello @[GptBot]!\nU67890: How are you?", TS: "1700000000.000200"},
+			},
+		},
+		{
+			name: "Attached images and notes are kept with the user turn",
+			messages: SlackMessages{
+				{
+					Text:   "これを見て",
+					User:   "U12345",
+					Images: []ChatImage{{ContentType: "image/png", Data: []byte("a")}},
+				},
+				{
+					Text:  "こちらも",
+					User:  "U67890",
+					Notes: []string{OversizedImageNote("big.png")},
+				},
+			},
+			botUserID: "botUserID",
+			want: []ChatMessage{
 				{
-					"speaker": "U12345",
-					"message": "Hello <@[GptBot]>!",
+					Role:    ChatRoleUser,
+					Content: "U12345: これを見て\nU67890: こちらも [添付画像 big.png: サイズが大きすぎるため省略しました]",
+					Images:  []ChatImage{{ContentType: "image/png", Data: []byte("a")}},
 				},
+			},
+		},
+		{
+			name: "Text attachments follow the message with newlines",
+			messages: SlackMessages{
 				{
-					"speaker": "U67890",
-					"message": "How are you?",
+					Text:  "このログを見て",
+					User:  "U12345",
+					Texts: []TextAttachment{{Name: "app.log", Content: "line1\nline2\n"}},
 				},
 			},
+			botUserID: "botUserID",
+			want: []ChatMessage{
+				{Role: ChatRoleUser, Content: "U12345: このログを見て\n[添付ファイル app.log]\n```\nline1\nline2\n```"},
+			},
 		},
 		{
 			name:      "No messages",
 			messages:  SlackMessages{},
 			botUserID: "botUserID",
-			want:      []map[string]string{},
+			want:      []ChatMessage{},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := tt.messages.extractConversationFlow(tt.botUserID)
-			if len(got) != len(tt.want) {
-				t.Errorf("extractConversationFlow() length = %v, want %v", len(got), len(tt.want))
+			got := tt.messages.CreateConversation("system", SlackDirectory{BotUserID: tt.botUserID, BotID: "B12345"})
+			if got.SystemPrompt != "system"+SpeakerFormatGuide {
+				t.Errorf("CreateConversation().SystemPrompt = %v", got.SystemPrompt)
+			}
+			if len(got.Messages) != len(tt.want) {
+				t.Fatalf("CreateConversation() length = %v, want %v", len(got.Messages), len(tt.want))
 			}
-			for i := range got {
-				if got[i]["speaker"] != tt.want[i]["speaker"] || got[i]["message"] != tt.want[i]["message"] {
-					t.Errorf("extractConversationFlow() = %v, want %v", got, tt.want)
+			for i := range got.Messages {
+				if !reflect.DeepEqual(got.Messages[i], tt.want[i]) {
+					t.Errorf("CreateConversation() = %v, want %v", got.Messages, tt.want)
 				}
 			}
 		})
@@ -0,0 +1,187 @@
+package model
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	SnippetReplyMessage = "回答が長いため、ファイルとして投稿しました。"
+	SnippetFilename     = "answer.md"
+	SnippetTitle        = "GPTの回答"
+)
+
+// MaxReplyLength 1つのメッセージに投稿するGPT応答の最大文字数（変換前のMarkdown）。超える場合は複数のメッセージに分ける
+// Slackは4000文字を超えるテキストを切り詰めることがあるため、mrkdwnへの変換で増える分の余裕をみておく
+var MaxReplyLength = 3500
+
+// ReplySnippetLength GPT応答がこの文字数を超える場合はメッセージに分けずにファイルとして投稿する。0の場合は常にメッセージで投稿する
+var ReplySnippetLength = 0
+
+// IsSnippetReply GPT応答をファイルとして投稿するかどうか
+func IsSnippetReply(markdown string) bool {
+	return ReplySnippetLength > 0 && utf8.RuneCountInString(markdown) > ReplySnippetLength
+}
+
+// NewBotReplies GPT応答を MaxReplyLength ごとのメッセージに分けて投稿用に変換する
+func NewBotReplies(markdown string, blockKit bool) []BotReply {
+	var replies []BotReply
+	for _, chunk := range SplitMarkdown(markdown, MaxReplyLength) {
+		replies = append(replies, NewBotReply(chunk, blockKit))
+	}
+	return replies
+}
+
+// StreamingPreview ストリーミング中に仮のメッセージに表示するテキスト
+// 1つのメッセージに収まらなくなった後は、受け取った最後の部分を表示する
+func StreamingPreview(markdown string) string {
+	chunks := SplitMarkdown(markdown, MaxReplyLength)
+	if len(chunks) == 0 {
+		return PlaceholderMessage
+	}
+	preview := ToMrkdwn(chunks[len(chunks)-1])
+	if len(chunks) > 1 {
+		preview = truncatedMarker + "\n" + preview
+	}
+	return preview + StreamingSuffix
+}
+
+// SplitMarkdown Markdownを maxLength 文字以内に分ける
+// 段落（空行）・コードブロック・表の区切りで分け、1つで超えるものは行の区切りで分ける
+// コードブロックを途中で分ける場合は、それぞれをフェンスで閉じて次のメッセージで開き直す
+// 表を途中で分ける場合は、それぞれの先頭に見出し行と区切り行を繰り返す
+func SplitMarkdown(markdown string, maxLength int) []string {
+	var chunks []string
+	var current string
+	flush := func() {
+		if current != "" {
+			chunks = append(chunks, current)
+			current = ""
+		}
+	}
+
+	for _, unit := range markdownUnits(markdown) {
+		text := unit.text()
+		if current != "" && utf8.RuneCountInString(current)+len("\n\n")+utf8.RuneCountInString(text) <= maxLength {
+			current += "\n\n" + text
+			continue
+		}
+		flush()
+		if utf8.RuneCountInString(text) <= maxLength {
+			current = text
+			continue
+		}
+
+		// 1つで収まらない段落・コードブロック・表は行の区切りで分ける。最後の部分には続く段落をまとめる
+		pieces := unit.split(maxLength)
+		chunks = append(chunks, pieces[:len(pieces)-1]...)
+		current = pieces[len(pieces)-1]
+	}
+	flush()
+	return chunks
+}
+
+// markdownUnit 分割の単位になる段落・コードブロック・表
+type markdownUnit struct {
+	lines []string
+	// コードブロックの場合の開始行（言語の指定を含む）と閉じるフェンス
+	opening string
+	closing string
+	// 表の場合の見出し行と区切り行。lines は本文の行
+	header []string
+}
+
+func (u markdownUnit) isCode() bool {
+	return u.opening != ""
+}
+
+func (u markdownUnit) isTable() bool {
+	return len(u.header) > 0
+}
+
+func (u markdownUnit) text() string {
+	if u.isTable() {
+		return strings.Join(append(append([]string{}, u.header...), u.lines...), "\n")
+	}
+	if !u.isCode() {
+		return strings.Join(u.lines, "\n")
+	}
+	return strings.Join(append(append([]string{u.opening}, u.lines...), u.closing), "\n")
+}
+
+// split 行の区切りで maxLength 文字以内に分ける。コードブロックはそれぞれをフェンスで囲み、表はそれぞれに見出しを付ける
+func (u markdownUnit) split(maxLength int) []string {
+	if u.isTable() {
+		header := strings.Join(u.header, "\n")
+		var pieces []string
+		for _, chunk := range chunkLines(u.lines, max(maxLength-utf8.RuneCountInString(header)-len("\n"), 1)) {
+			pieces = append(pieces, header+"\n"+chunk)
+		}
+		if len(pieces) == 0 {
+			pieces = append(pieces, u.text())
+		}
+		return pieces
+	}
+	if !u.isCode() {
+		return chunkLines(u.lines, maxLength)
+	}
+
+	fenceLength := utf8.RuneCountInString(u.opening) + utf8.RuneCountInString(u.closing) + len("\n\n")
+	var pieces []string
+	for _, chunk := range chunkLines(u.lines, max(maxLength-fenceLength, 1)) {
+		pieces = append(pieces, u.opening+"\n"+chunk+"\n"+u.closing)
+	}
+	if len(pieces) == 0 {
+		pieces = append(pieces, u.text())
+	}
+	return pieces
+}
+
+// markdownUnits Markdownを空行で区切られた段落・コードブロック・表に分ける
+// 閉じられていないコードブロックは末尾までをコードとみなして閉じる。表は段落の途中にあっても1つの単位にする
+func markdownUnits(markdown string) []markdownUnit {
+	lines := strings.Split(strings.ReplaceAll(markdown, "\r\n", "\n"), "\n")
+
+	var units []markdownUnit
+	var paragraph []string
+	flush := func() {
+		if len(paragraph) > 0 {
+			units = append(units, markdownUnit{lines: paragraph})
+			paragraph = nil
+		}
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if fence, ok := openingFence(line); ok {
+			flush()
+			code := markdownUnit{opening: line, closing: fence}
+			for i++; i < len(lines); i++ {
+				if isClosingFence(lines[i], fence) {
+					code.closing = lines[i]
+					break
+				}
+				code.lines = append(code.lines, lines[i])
+			}
+			units = append(units, code)
+			continue
+		}
+		if tableRowPattern.MatchString(line) && i+1 < len(lines) && tableDividerPattern.MatchString(lines[i+1]) {
+			flush()
+			table := markdownUnit{header: []string{line, lines[i+1]}}
+			for i += 2; i < len(lines) && tableRowPattern.MatchString(lines[i]); i++ {
+				table.lines = append(table.lines, lines[i])
+			}
+			i--
+			units = append(units, table)
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+		paragraph = append(paragraph, line)
+	}
+	flush()
+	return units
+}
@@ -0,0 +1,155 @@
+package model
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitMarkdown(t *testing.T) {
+	tests := []struct {
+		name      string
+		markdown  string
+		maxLength int
+		want      []string
+	}{
+		{
+			name:      "short reply is not split",
+			markdown:  "はじめに\n\n本文",
+			maxLength: 100,
+			want:      []string{"はじめに\n\n本文"},
+		},
+		{
+			name:      "split on paragraphs",
+			markdown:  "aaaa\n\nbbbb\n\n\ncccc",
+			maxLength: 10,
+			want:      []string{"aaaa\n\nbbbb", "cccc"},
+		},
+		{
+			name:      "code block is kept whole",
+			markdown:  "説明\n\n```go\nx := 1\n```\n続き",
+			maxLength: 20,
+			want:      []string{"説明\n\n```go\nx := 1\n```", "続き"},
+		},
+		{
+			name:      "blank lines inside code are not boundaries",
+			markdown:  "```\na\n\nb\n```",
+			maxLength: 100,
+			want:      []string{"```\na\n\nb\n```"},
+		},
+		{
+			name:      "long code block is closed and reopened",
+			markdown:  "```python\nline1\nline2\nline3\nline4\n```\n\n以上",
+			maxLength: 30,
+			want: []string{
+				"```python\nline1\nline2\n```",
+				"```python\nline3\nline4\n```\n\n以上",
+			},
+		},
+		{
+			name:      "unclosed code block is closed",
+			markdown:  "~~~~\ncode",
+			maxLength: 100,
+			want:      []string{"~~~~\ncode\n~~~~"},
+		},
+		{
+			name:      "long paragraph is split on lines",
+			markdown:  "1234567\n89\nabcdefghijkl",
+			maxLength: 10,
+			want:      []string{"1234567\n89", "abcdefghij", "kl"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SplitMarkdown(tt.markdown, tt.maxLength); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SplitMarkdown() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitMarkdownKeepsFencesBalanced(t *testing.T) {
+	var builder strings.Builder
+	builder.WriteString("## 実装例\n\n以下のように実装します。\n\n```go\n")
+	for range 200 {
+		builder.WriteString("fmt.Println(\"こんにちは、世界\")\n")
+	}
+	builder.WriteString("```\n\n")
+	for range 50 {
+		builder.WriteString("補足の説明です。\n\n")
+	}
+
+	chunks := SplitMarkdown(builder.String(), 500)
+	if len(chunks) < 2 {
+		t.Fatalf("len(chunks) = %d, want several chunks", len(chunks))
+	}
+	for i, chunk := range chunks {
+		if n := utf8.RuneCountInString(chunk); n > 500 {
+			t.Errorf("chunks[%d] has %d characters", i, n)
+		}
+		if n := strings.Count(chunk, "```"); n%2 != 0 {
+			t.Errorf("chunks[%d] has %d fences:\n%s", i, n, chunk)
+		}
+	}
+}
+
+func TestSplitMarkdownRepeatsTableHeader(t *testing.T) {
+	var builder strings.Builder
+	builder.WriteString("比較表です。\n| 言語 | 用途 |\n| --- | --- |\n")
+	for i := range 100 {
+		fmt.Fprintf(&builder, "| Go%d | サーバー |\n", i)
+	}
+	builder.WriteString("\n以上です。")
+
+	chunks := SplitMarkdown(builder.String(), 200)
+	if len(chunks) < 2 {
+		t.Fatalf("len(chunks) = %d, want several chunks", len(chunks))
+	}
+	if chunks[0] != "比較表です。" {
+		t.Errorf("chunks[0] = %q, want the paragraph before the table", chunks[0])
+	}
+	rows := 0
+	for i, chunk := range chunks[1:] {
+		if n := utf8.RuneCountInString(chunk); n > 200 {
+			t.Errorf("chunks[%d] has %d characters", i+1, n)
+		}
+		// 分けた表もそれぞれ見出し行と区切り行から始まる
+		if !strings.HasPrefix(chunk, "| 言語 | 用途 |\n| --- | --- |\n| Go") {
+			t.Errorf("chunks[%d] does not start with the table header:\n%s", i+1, chunk)
+		}
+		rows += strings.Count(chunk, "| サーバー |")
+	}
+	if rows != 100 {
+		t.Errorf("rows = %d, want 100", rows)
+	}
+	if last := chunks[len(chunks)-1]; !strings.HasSuffix(last, "\n\n以上です。") {
+		t.Errorf("last chunk = %q, want the following paragraph", last)
+	}
+}
+
+func TestStreamingPreview(t *testing.T) {
+	original := MaxReplyLength
+	MaxReplyLength = 10
+	defer func() { MaxReplyLength = original }()
+
+	tests := []struct {
+		name     string
+		markdown string
+		want     string
+	}{
+		{name: "empty", markdown: "", want: PlaceholderMessage},
+		{name: "single chunk", markdown: "```go\nx", want: "```\nx\n```" + StreamingSuffix},
+		{name: "shows the last chunk", markdown: "**first**\n\nsecond one", want: "(前略)\nsecond one" + StreamingSuffix},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := StreamingPreview(tt.markdown); got != tt.want {
+				t.Errorf("StreamingPreview() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -7,17 +7,31 @@ import (
 
 type SpreadsheetID string
 
-const (
+var (
+	// ワークスペース全体で共有する1日あたりのトークン上限
 	DailyTokenLimit = 20000
+	// Slackユーザーごとの1日あたりのトークン上限
+	UserDailyTokenLimit = 5000
+	// ワークスペース全体で共有する1日あたりの画像の生成枚数の上限
+	DailyImageLimit = 50
+	// Slackユーザーごとの1日あたりの画像の生成枚数の上限
+	UserDailyImageLimit = 5
 )
 
 type SpreadsheetData struct {
-	UserID           string
-	TotalUsage       int
-	LastUsedAt       string
-	TokensUsage      int
-	DailyTokensUsage int
-	TotalTokensUsage int
+	UserID                string
+	TotalUsage            int
+	LastUsedAt            string
+	TokensUsage           int // 直近のリクエストで使用したトークン数
+	DailyTokensUsage      int
+	TotalTokensUsage      int
+	PromptTokensUsage     int    // 累計のプロンプトのトークン数
+	CompletionTokensUsage int    // 累計の応答のトークン数
+	Model                 string // 直近のリクエストで使用したモデル
+	DailyCostUSD          float64
+	TotalCostUSD          float64
+	DailyImageUsage       int // 本日生成した画像の枚数
+	TotalImageUsage       int // 累計で生成した画像の枚数
 }
 
 func NewSpreadsheet(
@@ -45,21 +59,61 @@ func (s *SpreadsheetData) CanUseDailyTokens() error {
 	return nil
 }
 
-func (s *SpreadsheetData) AddTokenUsage(tokens int) {
-	s.TotalUsage++
+func (s *SpreadsheetData) CanUseUserDailyTokens() error {
+	if s.DailyTokensUsage > UserDailyTokenLimit {
+		return errors.New("user daily token limit exceeded")
+	}
+	return nil
+}
+
+// CanUseDailyImages ワークスペース全体でさらに count 枚の画像を作成できるか
+func (s *SpreadsheetData) CanUseDailyImages(count int) error {
+	if s.DailyImageUsage+count > DailyImageLimit {
+		return errors.New("daily image limit exceeded")
+	}
+	return nil
+}
+
+// CanUseUserDailyImages さらに count 枚の画像を作成できるか
+func (s *SpreadsheetData) CanUseUserDailyImages(count int) error {
+	if s.DailyImageUsage+count > UserDailyImageLimit {
+		return errors.New("user daily image limit exceeded")
+	}
+	return nil
+}
+
+// AddUsage 1回のリクエストの使用量を加算する
+func (s *SpreadsheetData) AddUsage(usage TokenUsage) {
+	tokens := usage.TotalTokens()
+	cost := usage.CostUSD()
+
+	// 画像の予約と取り消し、要約などの応答とは別の呼び出しはリクエストとして数えない
+	if !usage.Reservation && !usage.Supplementary {
+		s.TotalUsage++
+		s.TokensUsage = tokens
+		s.Model = usage.Model
+	}
 	s.DailyTokensUsage += tokens
 	s.TotalTokensUsage += tokens
+	s.PromptTokensUsage += usage.PromptTokens
+	s.CompletionTokensUsage += usage.CompletionTokens
+	s.DailyCostUSD += cost
+	s.TotalCostUSD += cost
+	s.DailyImageUsage += usage.Images
+	s.TotalImageUsage += usage.Images
 }
 
 func (s *SpreadsheetData) ResetDailyUsageIfNeeded() {
-	now := time.Now().In(time.FixedZone("Asia/Tokyo", 9*60*60))
+	now := time.Now().In(JST)
 	nowDate := now.Format("2006-01-02")
 
 	if s.LastUsedAt != "" {
 		lastUsedTime, _ := time.Parse(time.RFC3339, s.LastUsedAt)
-		lastUsedDate := lastUsedTime.In(time.FixedZone("Asia/Tokyo", 9*60*60)).Format("2006-01-02")
+		lastUsedDate := lastUsedTime.In(JST).Format("2006-01-02")
 		if lastUsedDate != nowDate {
 			s.DailyTokensUsage = 0
+			s.DailyCostUSD = 0
+			s.DailyImageUsage = 0
 		}
 	}
 
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"math"
 	"testing"
 	"time"
 )
@@ -36,6 +37,193 @@ func TestCanUseDailyTokens(t *testing.T) {
 	}
 }
 
+func TestCanUseUserDailyTokens(t *testing.T) {
+	tests := []struct {
+		name        string
+		dailyTokens int
+		expectedErr bool
+	}{
+		{
+			name:        "within limit",
+			dailyTokens: 4000,
+			expectedErr: false,
+		},
+		{
+			name:        "exceeds limit",
+			dailyTokens: 5001,
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spreadsheet := &SpreadsheetData{
+				DailyTokensUsage: tt.dailyTokens,
+			}
+			err := spreadsheet.CanUseUserDailyTokens()
+			if (err != nil) != tt.expectedErr {
+				t.Errorf("expected error: %v, got: %v", tt.expectedErr, err != nil)
+			}
+		})
+	}
+}
+
+func TestCanUseUserDailyImages(t *testing.T) {
+	tests := []struct {
+		name        string
+		dailyImages int
+		count       int
+		expectedErr bool
+	}{
+		{
+			name:        "within limit",
+			dailyImages: 4,
+			count:       1,
+			expectedErr: false,
+		},
+		{
+			name:        "reached limit",
+			dailyImages: 5,
+			count:       1,
+			expectedErr: true,
+		},
+		{
+			name:        "count exceeds remaining",
+			dailyImages: 3,
+			count:       3,
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spreadsheet := &SpreadsheetData{
+				DailyImageUsage: tt.dailyImages,
+			}
+			err := spreadsheet.CanUseUserDailyImages(tt.count)
+			if (err != nil) != tt.expectedErr {
+				t.Errorf("expected error: %v, got: %v", tt.expectedErr, err != nil)
+			}
+		})
+	}
+}
+
+func TestAddUsage(t *testing.T) {
+	data := &SpreadsheetData{UserID: "U1", TotalUsage: 1, DailyTokensUsage: 100, TotalTokensUsage: 1000}
+	usage := TokenUsage{Model: "gpt-4o", PromptTokens: 1000, CompletionTokens: 500}
+
+	data.AddUsage(usage)
+
+	// 1000 * 2.5 / 1M + 500 * 10 / 1M
+	wantCost := 0.0075
+	if data.TotalUsage != 2 || data.TokensUsage != 1500 || data.DailyTokensUsage != 1600 || data.TotalTokensUsage != 2500 {
+		t.Errorf("tokens = %+v", data)
+	}
+	if data.PromptTokensUsage != 1000 || data.CompletionTokensUsage != 500 || data.Model != "gpt-4o" {
+		t.Errorf("prompt/completion = %+v", data)
+	}
+	if math.Abs(data.TotalCostUSD-wantCost) > 1e-9 || math.Abs(data.DailyCostUSD-wantCost) > 1e-9 {
+		t.Errorf("cost = %v / %v, want %v", data.DailyCostUSD, data.TotalCostUSD, wantCost)
+	}
+}
+
+func TestAddSupplementaryUsage(t *testing.T) {
+	data := &SpreadsheetData{UserID: "U1"}
+
+	// 要約は要約を作成したモデルの料金で加算し、リクエストは応答の1回だけ数える
+	data.AddUsage(TokenUsage{Model: "gpt-4o-mini", PromptTokens: 1000, CompletionTokens: 100, Supplementary: true})
+	data.AddUsage(TokenUsage{Model: "gpt-4o", PromptTokens: 2000, CompletionTokens: 500})
+
+	if data.TotalUsage != 1 || data.Model != "gpt-4o" || data.TokensUsage != 2500 {
+		t.Errorf("request = %+v", data)
+	}
+	if data.DailyTokensUsage != 3600 || data.TotalTokensUsage != 3600 {
+		t.Errorf("tokens = %+v", data)
+	}
+	wantCost := (1000*0.15+100*0.6)/1_000_000 + (2000*2.5+500*10)/1_000_000
+	if math.Abs(data.TotalCostUSD-wantCost) > 1e-9 || math.Abs(data.DailyCostUSD-wantCost) > 1e-9 {
+		t.Errorf("cost = %v / %v, want %v", data.DailyCostUSD, data.TotalCostUSD, wantCost)
+	}
+}
+
+func TestAddImageUsage(t *testing.T) {
+	data := &SpreadsheetData{UserID: "U1", DailyImageUsage: 2, TotalImageUsage: 10}
+
+	data.AddUsage(TokenUsage{Model: "dall-e-3", Images: 1})
+
+	if data.DailyImageUsage != 3 || data.TotalImageUsage != 11 || data.TokensUsage != 0 {
+		t.Errorf("images = %+v", data)
+	}
+	if math.Abs(data.TotalCostUSD-0.04) > 1e-9 {
+		t.Errorf("cost = %v, want %v", data.TotalCostUSD, 0.04)
+	}
+}
+
+func TestCanUseDailyImages(t *testing.T) {
+	data := &SpreadsheetData{DailyImageUsage: DailyImageLimit - 1}
+	if err := data.CanUseDailyImages(1); err != nil {
+		t.Errorf("CanUseDailyImages(1) error = %v", err)
+	}
+	if err := data.CanUseDailyImages(2); err == nil {
+		t.Error("CanUseDailyImages(2) error = nil, want limit exceeded")
+	}
+}
+
+func TestAddImageReservation(t *testing.T) {
+	tests := []struct {
+		name       string
+		settle     []TokenUsage
+		wantUsage  int
+		wantImages int
+		wantModel  string
+	}{
+		{
+			name:       "cancelled",
+			settle:     []TokenUsage{ImageCancellation("dall-e-3", 3)},
+			wantUsage:  0,
+			wantImages: 0,
+			wantModel:  "gpt-4o",
+		},
+		{
+			name:       "partially generated",
+			settle:     []TokenUsage{ImageSettlement("dall-e-3", 3, 1)},
+			wantUsage:  1,
+			wantImages: 1,
+			wantModel:  "dall-e-3",
+		},
+		{
+			name:       "fully generated",
+			settle:     []TokenUsage{ImageSettlement("dall-e-3", 3, 3)},
+			wantUsage:  1,
+			wantImages: 3,
+			wantModel:  "dall-e-3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := &SpreadsheetData{UserID: "U1", Model: "gpt-4o"}
+
+			// 予約はリクエストとして数えず、確定したときに1回だけ数える
+			data.AddUsage(ImageReservation("dall-e-3", 3))
+			for _, usage := range tt.settle {
+				data.AddUsage(usage)
+			}
+
+			if data.TotalUsage != tt.wantUsage || data.Model != tt.wantModel {
+				t.Errorf("usage = %v, model = %v, want %v and %v", data.TotalUsage, data.Model, tt.wantUsage, tt.wantModel)
+			}
+			if data.DailyImageUsage != tt.wantImages || data.TotalImageUsage != tt.wantImages {
+				t.Errorf("images = %v / %v, want %v", data.DailyImageUsage, data.TotalImageUsage, tt.wantImages)
+			}
+			wantCost := float64(tt.wantImages) * 0.04
+			if math.Abs(data.TotalCostUSD-wantCost) > 1e-9 || math.Abs(data.DailyCostUSD-wantCost) > 1e-9 {
+				t.Errorf("cost = %v / %v, want %v", data.DailyCostUSD, data.TotalCostUSD, wantCost)
+			}
+		})
+	}
+}
+
 func TestResetDailyUsageIfNeeded(t *testing.T) {
 	tests := []struct {
 		name             string
@@ -0,0 +1,81 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// ThreadSummaryTTL 更新のないスレッドの要約を保持する期間
+	ThreadSummaryTTL = 7 * 24 * time.Hour
+)
+
+// SummarySystemPrompt スレッドの古いターンを要約するときのシステムプロンプト
+const SummarySystemPrompt = `
+あなたはSlackスレッドの要約担当です。
+「これまでの要約」と「新しい会話」をもとに、スレッド全体の要約を日本語で作成してください。
+誰が何を質問・依頼し、どのような結論や未解決の事項があるかを、固有名詞・数値・コードの識別子を残して簡潔にまとめてください。
+要約のみを出力し、前置きや補足は不要です。
+`
+
+// ThreadSummary スレッドのうち予算に収まらず省いた古いターンの要約
+type ThreadSummary struct {
+	ChannelID string
+	ThreadTS  string
+	Summary   string
+	// SummarizedUntilTS 要約に含まれている最後のメッセージのts
+	// スレッドが伸びたり予算が変わったりするとターンの数や区切りが変わるため、位置ではなくtsで記録する
+	SummarizedUntilTS string
+	UpdatedAt         time.Time
+}
+
+// NewTurns turns のうち、まだ要約に含まれていないターン
+func (s *ThreadSummary) NewTurns(turns []ChatMessage) []ChatMessage {
+	if s == nil {
+		return turns
+	}
+	var newTurns []ChatMessage
+	for _, turn := range turns {
+		if CompareTS(turn.TS, s.SummarizedUntilTS) > 0 {
+			newTurns = append(newTurns, turn)
+		}
+	}
+	return newTurns
+}
+
+// CompareTS Slackのts（"1700000000.123456"）を時刻の順に比べる。a が前なら負、後なら正、同じなら0を返す
+func CompareTS(a string, b string) int {
+	aSeconds, aFraction, _ := strings.Cut(a, ".")
+	bSeconds, bFraction, _ := strings.Cut(b, ".")
+	if len(aSeconds) != len(bSeconds) {
+		return len(aSeconds) - len(bSeconds)
+	}
+	if c := strings.Compare(aSeconds, bSeconds); c != 0 {
+		return c
+	}
+	return strings.Compare(aFraction, bFraction)
+}
+
+// NewSummaryConversation これまでの要約と新たに省かれたターンから、要約を作成するための会話を作成する
+func NewSummaryConversation(previousSummary string, turns []ChatMessage) Conversation {
+	var builder strings.Builder
+	if previousSummary != "" {
+		builder.WriteString("[これまでの要約]\n")
+		builder.WriteString(previousSummary)
+		builder.WriteString("\n\n")
+	}
+	builder.WriteString("[新しい会話]\n")
+	for _, turn := range turns {
+		if turn.Role == ChatRoleAssistant {
+			builder.WriteString(fmt.Sprintf("[GptBot]: %s\n", turn.Content))
+			continue
+		}
+		builder.WriteString(turn.Content)
+		builder.WriteString("\n")
+	}
+
+	conversation := NewConversation(SummarySystemPrompt, builder.String())
+	conversation.MaxTokens = SummaryTokenReserve
+	return conversation
+}
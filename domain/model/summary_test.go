@@ -0,0 +1,99 @@
+package model
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestThreadSummaryNewTurns(t *testing.T) {
+	turns := []ChatMessage{
+		{Role: ChatRoleUser, Content: "a", TS: "1700000000.000100"},
+		{Role: ChatRoleAssistant, Content: "b", TS: "1700000000.000200"},
+		{Role: ChatRoleUser, Content: "c", TS: "1700000010.000100"},
+	}
+
+	tests := []struct {
+		name    string
+		summary *ThreadSummary
+		want    []string
+	}{
+		{
+			name:    "no summary",
+			summary: nil,
+			want:    []string{"a", "b", "c"},
+		},
+		{
+			name:    "summary covers dropped turns",
+			summary: &ThreadSummary{SummarizedUntilTS: "1700000010.000100"},
+			want:    nil,
+		},
+		{
+			name:    "more turns dropped since last summary",
+			summary: &ThreadSummary{SummarizedUntilTS: "1700000000.000100"},
+			want:    []string{"b", "c"},
+		},
+		{
+			// スレッドが伸びて予算内のターンが減っても、要約済みのターンは数え直さない
+			name:    "summary covers more than the dropped turns",
+			summary: &ThreadSummary{SummarizedUntilTS: "1700000020.000000"},
+			want:    nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, turn := range tt.summary.NewTurns(turns) {
+				got = append(got, turn.Content)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("NewTurns() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompareTS(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{a: "1700000000.000100", b: "1700000000.000100", want: 0},
+		{a: "1700000000.000100", b: "1700000000.000200", want: -1},
+		{a: "1700000001.000000", b: "1700000000.999999", want: 1},
+		{a: "999999999.000000", b: "1700000000.000000", want: -1},
+		{a: "", b: "1700000000.000000", want: -1},
+	}
+
+	for _, tt := range tests {
+		got := CompareTS(tt.a, tt.b)
+		if (got < 0) != (tt.want < 0) || (got > 0) != (tt.want > 0) {
+			t.Errorf("CompareTS(%q, %q) = %v, want sign of %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestNewSummaryConversation(t *testing.T) {
+	turns := []ChatMessage{
+		{Role: ChatRoleUser, Content: "U12345: ログを見てください"},
+		{Role: ChatRoleAssistant, Content: "タイムアウトが原因です"},
+	}
+
+	got := NewSummaryConversation("以前の要約", turns)
+
+	if got.SystemPrompt != SummarySystemPrompt {
+		t.Errorf("SystemPrompt = %v, want %v", got.SystemPrompt, SummarySystemPrompt)
+	}
+	if got.MaxTokens != SummaryTokenReserve {
+		t.Errorf("MaxTokens = %v, want %v", got.MaxTokens, SummaryTokenReserve)
+	}
+	if len(got.Messages) != 1 {
+		t.Fatalf("Messages length = %v, want 1", len(got.Messages))
+	}
+	content := got.Messages[0].Content
+	for _, want := range []string{"以前の要約", "U12345: ログを見てください", "[GptBot]: タイムアウトが原因です"} {
+		if !strings.Contains(content, want) {
+			t.Errorf("content %q does not contain %q", content, want)
+		}
+	}
+}
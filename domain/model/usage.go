@@ -0,0 +1,99 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// JST 日次の使用量をリセットする基準のタイムゾーン
+var JST = time.FixedZone("Asia/Tokyo", 9*60*60)
+
+// UsageReport /gpt usage で表示するユーザーの利用状況
+type UsageReport struct {
+	UserID          string
+	TodayTokens     int
+	DailyLimit      int
+	RemainingTokens int // ユーザーの上限とワークスペース全体の上限のうち少ない方
+	TotalTokens     int
+	TotalRequests   int
+	TodayCostUSD    float64
+	TotalCostUSD    float64
+	TodayImages     int
+	ImageLimit      int
+	ResetAt         time.Time
+}
+
+// NewUsageReport ユーザーとワークスペース全体の使用量から利用状況を作成する
+// 使用量は ResetDailyUsageIfNeeded で日付の切り替えを反映済みであること
+func NewUsageReport(user *SpreadsheetData, workspace *SpreadsheetData, now time.Time) UsageReport {
+	remaining := UserDailyTokenLimit - user.DailyTokensUsage
+	if workspaceRemaining := DailyTokenLimit - workspace.DailyTokensUsage; workspaceRemaining < remaining {
+		remaining = workspaceRemaining
+	}
+
+	return UsageReport{
+		UserID:          user.UserID,
+		TodayTokens:     user.DailyTokensUsage,
+		DailyLimit:      UserDailyTokenLimit,
+		RemainingTokens: max(remaining, 0),
+		TotalTokens:     user.TotalTokensUsage,
+		TotalRequests:   user.TotalUsage,
+		TodayCostUSD:    user.DailyCostUSD,
+		TotalCostUSD:    user.TotalCostUSD,
+		TodayImages:     user.DailyImageUsage,
+		ImageLimit:      UserDailyImageLimit,
+		ResetAt:         NextDailyReset(now),
+	}
+}
+
+// NextDailyReset 次に日次の使用量がリセットされる時刻（翌日0時 JST）
+func NextDailyReset(now time.Time) time.Time {
+	jstNow := now.In(JST)
+	return time.Date(jstNow.Year(), jstNow.Month(), jstNow.Day()+1, 0, 0, 0, 0, JST)
+}
+
+// Blocks 利用状況をBlock Kitで表示する
+func (r UsageReport) Blocks() []slack.Block {
+	fields := []*slack.TextBlockObject{
+		slack.NewTextBlockObject(slack.MarkdownType,
+			fmt.Sprintf("*本日の使用量*\n%s / %s トークン", formatNumber(r.TodayTokens), formatNumber(r.DailyLimit)), false, false),
+		slack.NewTextBlockObject(slack.MarkdownType,
+			fmt.Sprintf("*本日の残り*\n%s トークン", formatNumber(r.RemainingTokens)), false, false),
+		slack.NewTextBlockObject(slack.MarkdownType,
+			fmt.Sprintf("*累計*\n%s トークン（%s 回）", formatNumber(r.TotalTokens), formatNumber(r.TotalRequests)), false, false),
+		slack.NewTextBlockObject(slack.MarkdownType,
+			fmt.Sprintf("*推定費用*\n本日 $%.4f / 累計 $%.4f", r.TodayCostUSD, r.TotalCostUSD), false, false),
+		slack.NewTextBlockObject(slack.MarkdownType,
+			fmt.Sprintf("*本日の画像*\n%d / %d 枚", r.TodayImages, r.ImageLimit), false, false),
+	}
+
+	return []slack.Block{
+		slack.NewHeaderBlock(slack.NewTextBlockObject(slack.PlainTextType, "利用状況", false, false)),
+		slack.NewSectionBlock(nil, fields, nil),
+		slack.NewContextBlock("",
+			slack.NewTextBlockObject(slack.MarkdownType,
+				fmt.Sprintf("使用量は %s にリセットされます。推定費用はモデルごとのプロンプト・応答の料金から計算しています。",
+					r.ResetAt.In(JST).Format("1月2日 15:04 JST")), false, false),
+		),
+	}
+}
+
+// Text Block Kitを表示できない通知などで使う代替テキスト
+func (r UsageReport) Text() string {
+	return fmt.Sprintf("本日の使用量: %d / %d トークン（残り %d）、累計: %d トークン",
+		r.TodayTokens, r.DailyLimit, r.RemainingTokens, r.TotalTokens)
+}
+
+// formatNumber 3桁ごとにカンマを入れる
+func formatNumber(n int) string {
+	if n < 0 {
+		return "-" + formatNumber(-n)
+	}
+	s := fmt.Sprintf("%d", n)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
@@ -0,0 +1,92 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewUsageReport(t *testing.T) {
+	now := time.Date(2024, 3, 31, 23, 30, 0, 0, JST)
+
+	tests := []struct {
+		name          string
+		user          *SpreadsheetData
+		workspace     *SpreadsheetData
+		wantRemaining int
+	}{
+		{
+			name:          "user limit",
+			user:          &SpreadsheetData{DailyTokensUsage: 1200, TotalTokensUsage: 50000, TotalUsage: 30},
+			workspace:     &SpreadsheetData{DailyTokensUsage: 3000},
+			wantRemaining: UserDailyTokenLimit - 1200,
+		},
+		{
+			name:          "workspace limit is lower",
+			user:          &SpreadsheetData{DailyTokensUsage: 1200},
+			workspace:     &SpreadsheetData{DailyTokensUsage: DailyTokenLimit - 500},
+			wantRemaining: 500,
+		},
+		{
+			name:          "exceeded",
+			user:          &SpreadsheetData{DailyTokensUsage: UserDailyTokenLimit + 10},
+			workspace:     &SpreadsheetData{},
+			wantRemaining: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := NewUsageReport(tt.user, tt.workspace, now)
+			if report.RemainingTokens != tt.wantRemaining {
+				t.Errorf("RemainingTokens = %d, want %d", report.RemainingTokens, tt.wantRemaining)
+			}
+			if report.TodayTokens != tt.user.DailyTokensUsage || report.TotalTokens != tt.user.TotalTokensUsage {
+				t.Errorf("report = %+v, user = %+v", report, tt.user)
+			}
+			if len(report.Blocks()) == 0 {
+				t.Error("Blocks() is empty")
+			}
+		})
+	}
+}
+
+func TestNextDailyReset(t *testing.T) {
+	tests := []struct {
+		name string
+		now  time.Time
+		want time.Time
+	}{
+		{
+			name: "jst evening",
+			now:  time.Date(2024, 3, 31, 23, 30, 0, 0, JST),
+			want: time.Date(2024, 4, 1, 0, 0, 0, 0, JST),
+		},
+		{
+			name: "utc time before jst midnight",
+			now:  time.Date(2024, 3, 31, 14, 59, 0, 0, time.UTC),
+			want: time.Date(2024, 4, 1, 0, 0, 0, 0, JST),
+		},
+		{
+			name: "utc time after jst midnight",
+			now:  time.Date(2024, 3, 31, 15, 0, 0, 0, time.UTC),
+			want: time.Date(2024, 4, 2, 0, 0, 0, 0, JST),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NextDailyReset(tt.now); !got.Equal(tt.want) {
+				t.Errorf("NextDailyReset() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatNumber(t *testing.T) {
+	tests := map[int]string{0: "0", 999: "999", 1000: "1,000", 1234567: "1,234,567", -5000: "-5,000"}
+	for n, want := range tests {
+		if got := formatNumber(n); got != want {
+			t.Errorf("formatNumber(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+)
+
+// AuditRepository GPTの呼び出しごとの監査ログを記録する
+type AuditRepository interface {
+	RecordCompletion(ctx context.Context, record model.AuditRecord) error
+}
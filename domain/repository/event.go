@@ -0,0 +1,16 @@
+package repository
+
+import (
+	"context"
+	"time"
+)
+
+// EventDedupRepository Slackイベントの重複処理を防ぐためのストア
+type EventDedupRepository interface {
+	// TryAcquire すべてのキーの処理権を取得する。いずれかが処理中または処理済みの場合は何もせず false を返す
+	TryAcquire(ctx context.Context, keys []string, ttl time.Duration) (bool, error)
+	// MarkDone 処理済みとして ttl の間保持する
+	MarkDone(ctx context.Context, keys []string, ttl time.Duration) error
+	// Release 処理権を手放し、再送されたイベントを処理できるようにする
+	Release(ctx context.Context, keys []string) error
+}
@@ -3,10 +3,15 @@ package repository
 import (
 	"context"
 
-	"github.com/sashabaranov/go-openai"
+	"github.com/gs1068/slack-gpt-bot/domain/model"
 )
 
+// GptRepository LLMの呼び出し。conversation.Provider・options.Provider に応じてプロバイダーを切り替える
 type GptRepository interface {
-	CreateCompletion(ctx context.Context, prompt string) (openai.ChatCompletionResponse, error)
-	CreateImage(ctx context.Context, prompt string) (string, error)
+	CreateCompletion(ctx context.Context, conversation model.Conversation) (model.ChatResponse, error)
+	// CreateCompletionStream 応答を逐次 onDelta に渡し、最後に応答全体とトークン使用量を CreateCompletion と同じ形で返す
+	CreateCompletionStream(ctx context.Context, conversation model.Conversation, onDelta func(delta string) error) (model.ChatResponse, error)
+	// CreateImage 画像を生成し、URLで返された場合もダウンロードして内容を返す
+	// 一部の画像を取得できなかった場合は、取得できた画像と model.ImageDownloadError を返す
+	CreateImage(ctx context.Context, prompt string, options model.ImageOptions) ([]model.GeneratedImage, error)
 }
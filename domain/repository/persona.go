@@ -0,0 +1,15 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+)
+
+type PersonaRepository interface {
+	// FindPersona チャンネルに適用するペルソナと、チャンネルのルールで指定したプロバイダー（指定がなければ空）を返す
+	FindPersona(ctx context.Context, channel model.ChannelInfo) (model.Persona, string, error)
+	// GetPersona 名前からペルソナを取得する。存在しない場合は false を返す
+	GetPersona(ctx context.Context, name string) (model.Persona, bool, error)
+	ListPersonas(ctx context.Context) ([]model.Persona, error)
+}
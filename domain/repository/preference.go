@@ -0,0 +1,14 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+)
+
+type PreferenceRepository interface {
+	// GetPreference 設定を取得する。ない場合は nil を返す
+	GetPreference(ctx context.Context, scope model.PreferenceScope, id string) (*model.Preference, error)
+	SavePreference(ctx context.Context, preference model.Preference) error
+	DeletePreference(ctx context.Context, scope model.PreferenceScope, id string) error
+}
@@ -1,11 +1,30 @@
 package repository
 
 import (
+	"context"
+
+	"github.com/gs1068/slack-gpt-bot/domain/model"
 	"github.com/slack-go/slack"
 )
 
 type SlackRepository interface {
 	LoadConversationReplies(channelId string, timeStamp string) ([]slack.Message, error)
 	CreateNewBotMessage(channelId string, timeStamp string, msg string) error
-	GetBotUserId() (string, error)
+	// PostPlaceholderMessage 後から更新するためのメッセージを投稿する
+	PostPlaceholderMessage(channelId string, timeStamp string, msg string) (*model.BotMessage, error)
+	UpdateBotMessage(botMessage *model.BotMessage, msg string) error
+	// PostBotReply GPT応答をスレッドに投稿する。Blocks がある場合はBlock Kitで投稿する
+	PostBotReply(channelId string, timeStamp string, reply model.BotReply) error
+	// UpdateBotReply 投稿済みのメッセージをGPT応答で更新する
+	UpdateBotReply(botMessage *model.BotMessage, reply model.BotReply) error
+	// GetBotIdentity このBotのユーザーIDとBotのIDを返す
+	GetBotIdentity() (userID string, botID string, err error)
+	GetChannelInfo(channelId string) (model.ChannelInfo, error)
+	// GetUserInfo ユーザーの表示名などを返す。一定時間キャッシュし、取得できなかった場合も短い時間キャッシュする
+	GetUserInfo(userID string) (model.SlackUser, error)
+	// UploadFile ファイルをスレッドにアップロードする。timeStamp が空の場合はチャンネルに投稿する
+	UploadFile(ctx context.Context, channelId string, timeStamp string, file model.SlackFile) error
+	// DownloadFile 添付ファイルをBotのトークンでダウンロードする。maxBytes を超える場合はエラーを返す
+	// 同じファイルは一定時間ファイルIDごとにキャッシュする
+	DownloadFile(ctx context.Context, file model.SlackAttachment, maxBytes int) ([]byte, error)
 }
@@ -0,0 +1,13 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+)
+
+type ThreadSummaryRepository interface {
+	// GetThreadSummary スレッドの要約を取得する。ない場合は nil を返す
+	GetThreadSummary(ctx context.Context, channelID string, threadTS string) (*model.ThreadSummary, error)
+	SaveThreadSummary(ctx context.Context, summary model.ThreadSummary) error
+}
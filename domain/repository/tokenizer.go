@@ -0,0 +1,10 @@
+package repository
+
+import (
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+)
+
+type TokenizerRepository interface {
+	// TokenCounter モデルに対応するトークン数のカウンターを返す
+	TokenCounter(modelName string) (model.TokenCounter, error)
+}
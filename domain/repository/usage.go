@@ -0,0 +1,20 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+)
+
+// UsageRepository ユーザーごとのトークン使用量を保存する。保存先はスプレッドシート、SQLite、メモリから選択する
+type UsageRepository interface {
+	// GetUsage 指定したユーザーの使用量を返す。データがない場合は nil を返す
+	GetUsage(ctx context.Context, userID string) (*model.SpreadsheetData, error)
+	// UpdateUsage 使用量をそのまま保存する（保存先の移行に使う）
+	UpdateUsage(ctx context.Context, update model.SpreadsheetData) error
+	// IncrementUsage 使用回数、トークン数、費用を加算し、加算後の使用量を返す
+	// 日付が変わっていれば日次の使用量をリセットしてから加算する。同じユーザーへの同時の加算が失われないようにすること
+	IncrementUsage(ctx context.Context, userID string, usage model.TokenUsage) (*model.SpreadsheetData, error)
+	// ListUsage すべてのユーザーの使用量を返す（保存先の移行に使う）
+	ListUsage(ctx context.Context) ([]model.SpreadsheetData, error)
+}
@@ -0,0 +1,73 @@
+package gpt
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen 失敗が続いているため、プロバイダーを呼び出さなかった
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// BreakerPolicy プロバイダーごとのサーキットブレーカーの設定
+type BreakerPolicy struct {
+	Threshold int           // 連続して失敗した呼び出しがこの回数に達したら呼び出しを止める。0の場合は止めない
+	Cooldown  time.Duration // 呼び出しを止めてから、試しに1回呼び出すまでの時間
+}
+
+// Breaker プロバイダーのサーキットブレーカーの設定
+var Breaker = BreakerPolicy{
+	Threshold: 5,
+	Cooldown:  30 * time.Second,
+}
+
+// circuitBreaker 再試行しても失敗した呼び出しが続くプロバイダーを一定時間呼び出さないようにする
+// 止めてから Cooldown が経つと1回だけ呼び出しを通し、成功すれば元に戻し、失敗すればまた止める
+type circuitBreaker struct {
+	policy BreakerPolicy
+	now    func() time.Time
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+}
+
+func newCircuitBreaker(policy BreakerPolicy) *circuitBreaker {
+	return &circuitBreaker{
+		policy: policy,
+		now:    time.Now,
+	}
+}
+
+// allow 呼び出してよいかどうか
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.policy.Threshold <= 0 || b.failures < b.policy.Threshold {
+		return true
+	}
+	if b.now().Sub(b.openedAt) < b.policy.Cooldown {
+		return false
+	}
+	// 試しに呼び出すのは1回だけにし、結果が出るまでの他の呼び出しは止めたままにする
+	b.openedAt = b.now()
+	return true
+}
+
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+}
+
+func (b *circuitBreaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.failures >= b.policy.Threshold {
+		b.openedAt = b.now()
+	}
+}
@@ -2,67 +2,197 @@ package gpt
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/url"
+	"slices"
 
 	"github.com/gs1068/slack-gpt-bot/domain/model"
 	"github.com/gs1068/slack-gpt-bot/domain/repository"
+	"github.com/rs/zerolog/log"
 	"github.com/sashabaranov/go-openai"
 )
 
+// provider 1つのLLMのプロバイダーのAPI。OpenAIのAPI形式でないプロバイダーもこれを実装すれば追加できる
+type provider interface {
+	createCompletion(ctx context.Context, conversation model.Conversation) (model.ChatResponse, error)
+	createCompletionStream(ctx context.Context, conversation model.Conversation, onDelta func(delta string) error) (model.ChatResponse, error)
+	createImage(ctx context.Context, prompt string, options model.ImageOptions) ([]model.GeneratedImage, error)
+}
+
+// FallbackTarget 呼び出しが一時的な障害で失敗したときに代わりに呼び出すプロバイダーとモデル。Model が空の場合は元のモデルを使う
+type FallbackTarget struct {
+	Provider string
+	Model    string
+}
+
+// gptRepository 会話や画像の設定で指定されたプロバイダーに呼び出しを振り分ける
+// 再試行しても失敗した場合やプロバイダーの呼び出しを止めている場合は、フォールバック先を順に呼び出す
 type gptRepository struct {
-	gptClient *openai.Client
+	providers       map[string]provider
+	defaultProvider string
+	fallbacks       []FallbackTarget
 }
 
-func NewGptRepository(gptClient *openai.Client) repository.GptRepository {
-	return &gptRepository{
-		gptClient: gptClient,
+// NewGptRepository プロバイダーの設定からクライアントを作成する。defaultProvider はプロバイダーの指定がない場合に使う
+// fallbacks は会話の応答に使うフォールバック先で、並べた順に試す
+func NewGptRepository(configs []ProviderConfig, defaultProvider string, fallbacks []FallbackTarget) (repository.GptRepository, error) {
+	r := &gptRepository{
+		providers:       make(map[string]provider, len(configs)),
+		defaultProvider: defaultProvider,
+		fallbacks:       fallbacks,
+	}
+	for _, config := range configs {
+		if config.Name == "" {
+			return nil, errors.New("provider name is required")
+		}
+		if _, ok := r.providers[config.Name]; ok {
+			return nil, fmt.Errorf("duplicate provider %q", config.Name)
+		}
+		p, err := newOpenAIProvider(config)
+		if err != nil {
+			return nil, fmt.Errorf("provider %q: %w", config.Name, err)
+		}
+		r.providers[config.Name] = p
+	}
+	if _, ok := r.providers[defaultProvider]; !ok {
+		return nil, fmt.Errorf("unknown default provider %q", defaultProvider)
+	}
+	for i, fallback := range fallbacks {
+		if _, ok := r.providers[fallback.Provider]; !ok {
+			return nil, fmt.Errorf("fallbacks[%d]: unknown provider %q", i, fallback.Provider)
+		}
 	}
+	return r, nil
 }
 
-func GptClient(openAiApiKey string) *openai.Client {
-	client := openai.NewClient(openAiApiKey)
-	return client
+// targets 呼び出す順のプロバイダーとモデル。先頭は指定されたもので、同じ組み合わせのフォールバック先は除く
+// ペルソナやルールでプロバイダーを指定した会話は、その送信先以外に送らないようフォールバックしない
+// compatible が false を返すモデルのフォールバック先も除く
+func (r *gptRepository) targets(providerName string, modelName string, compatible func(modelName string) bool) []FallbackTarget {
+	if providerName != "" {
+		return []FallbackTarget{{Provider: providerName, Model: modelName}}
+	}
+	targets := []FallbackTarget{{Provider: r.defaultProvider, Model: modelName}}
+	for _, fallback := range r.fallbacks {
+		if fallback.Model == "" {
+			fallback.Model = modelName
+		}
+		if slices.Contains(targets, fallback) {
+			continue
+		}
+		if compatible != nil && !compatible(fallback.Model) {
+			log.Debug().Str("provider", fallback.Provider).Str("model", fallback.Model).Msg("skipping incompatible fallback target")
+			continue
+		}
+		targets = append(targets, fallback)
+	}
+	return targets
 }
 
-func (r *gptRepository) CreateCompletion(ctx context.Context, prompt string) (openai.ChatCompletionResponse, error) {
-	resp, err := r.gptClient.CreateChatCompletion(
-		ctx,
-		openai.ChatCompletionRequest{
-			Model: openai.GPT4o,
-			Messages: []openai.ChatCompletionMessage{
-				{
-					Role:    openai.ChatMessageRoleAssistant,
-					Content: model.CharacterSettings,
-				},
-				{
-					Role:    openai.ChatMessageRoleUser,
-					Content: prompt,
-				},
-			},
-		},
-	)
+// call 指定されたプロバイダーとモデルで fn を呼び出し、一時的な障害で失敗した場合はフォールバック先で呼び出し直す
+// canFallback が false を返す場合（応答の一部をすでに渡した場合など）は呼び出し直さない
+// 知らないプロバイダーを指定された場合は、意図しない送信先に会話を送らないようエラーにする
+func (r *gptRepository) call(ctx context.Context, providerName string, modelName string, canFallback func() bool, compatible func(modelName string) bool, fn func(target FallbackTarget, p provider, fallback bool) error) error {
+	var errs []error
+	for i, target := range r.targets(providerName, modelName, compatible) {
+		p, ok := r.providers[target.Provider]
+		if !ok {
+			return fmt.Errorf("unknown provider %q", target.Provider)
+		}
+		if i > 0 {
+			log.Warn().Err(errs[len(errs)-1]).Str("provider", target.Provider).Str("model", target.Model).Msg("falling back to another LLM provider")
+		}
 
-	if err != nil {
-		return openai.ChatCompletionResponse{}, fmt.Errorf("failed r.gptClient.CreateChatCompletion: %w", err)
+		err := fn(target, p, i > 0)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("provider %s: %w", target.Provider, err))
+		if !shouldFallback(ctx, err) || (canFallback != nil && !canFallback()) {
+			break
+		}
 	}
+	return errors.Join(errs...)
+}
 
+// shouldFallback 別のプロバイダーやモデルなら応答できる見込みのあるエラーかどうか
+// 429・5xx・通信エラーと、呼び出しを止めているプロバイダーが対象で、リクエストの内容の誤りや呼び出し元のキャンセルは対象にしない
+func shouldFallback(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if errors.Is(err, ErrCircuitOpen) {
+		return true
+	}
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		return isRetryableStatus(apiErr.HTTPStatusCode)
+	}
+	var requestErr *openai.RequestError
+	if errors.As(err, &requestErr) {
+		return isRetryableStatus(requestErr.HTTPStatusCode)
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+func (r *gptRepository) CreateCompletion(ctx context.Context, conversation model.Conversation) (model.ChatResponse, error) {
+	var resp model.ChatResponse
+	err := r.call(ctx, conversation.Provider, conversation.ModelName(), nil, conversation.CanFallbackTo, func(target FallbackTarget, p provider, fallback bool) error {
+		targetConversation := conversation
+		targetConversation.Model = target.Model
+		var err error
+		resp, err = p.createCompletion(ctx, targetConversation)
+		resp = withTarget(resp, target, fallback)
+		return err
+	})
+	if err != nil {
+		return model.ChatResponse{}, err
+	}
 	return resp, nil
 }
 
-func (r *gptRepository) CreateImage(ctx context.Context, prompt string) (string, error) {
-	respUrl, err := r.gptClient.CreateImage(
-		ctx,
-		openai.ImageRequest{
-			Prompt:         prompt,
-			Size:           openai.CreateImageSize256x256,
-			ResponseFormat: openai.CreateImageResponseFormatURL,
-			N:              1,
-		},
-	)
+func (r *gptRepository) CreateCompletionStream(ctx context.Context, conversation model.Conversation, onDelta func(delta string) error) (model.ChatResponse, error) {
+	// 応答の一部をすでに渡した後は、別のモデルの応答とつながらないようフォールバックしない
+	streamed := false
+	canFallback := func() bool { return !streamed }
+
+	var resp model.ChatResponse
+	err := r.call(ctx, conversation.Provider, conversation.ModelName(), canFallback, conversation.CanFallbackTo, func(target FallbackTarget, p provider, fallback bool) error {
+		targetConversation := conversation
+		targetConversation.Model = target.Model
+		var err error
+		resp, err = p.createCompletionStream(ctx, targetConversation, func(delta string) error {
+			streamed = true
+			return onDelta(delta)
+		})
+		resp = withTarget(resp, target, fallback)
+		return err
+	})
 	if err != nil {
-		return "", fmt.Errorf("failed to create image: %w", err)
+		return model.ChatResponse{}, err
+	}
+	return resp, nil
+}
+
+// withTarget 応答したプロバイダーと、フォールバックした場合は代わりに呼び出したモデルを記録する
+func withTarget(resp model.ChatResponse, target FallbackTarget, fallback bool) model.ChatResponse {
+	resp.Provider = target.Provider
+	if fallback {
+		resp.FallbackModel = target.Model
 	}
+	return resp
+}
 
-	// 生成されたURLを取得
-	return respUrl.Data[0].URL, nil
+// CreateImage 画像の作成はモデルごとに設定が異なるため、フォールバックしない
+// 一部の画像を取得できなかった場合は、取得できた画像と model.ImageDownloadError を返す
+func (r *gptRepository) CreateImage(ctx context.Context, prompt string, options model.ImageOptions) ([]model.GeneratedImage, error) {
+	var images []model.GeneratedImage
+	err := r.call(ctx, options.Provider, options.Model, func() bool { return false }, nil, func(target FallbackTarget, p provider, fallback bool) error {
+		var err error
+		images, err = p.createImage(ctx, prompt, options)
+		return err
+	})
+	return images, err
 }
@@ -0,0 +1,173 @@
+package gpt
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+)
+
+// fakeProvider 呼び出されたことを記録して決まった応答を返す
+type fakeProvider struct {
+	content string
+	calls   int
+}
+
+func (p *fakeProvider) createCompletion(ctx context.Context, conversation model.Conversation) (model.ChatResponse, error) {
+	p.calls++
+	return model.ChatResponse{Model: conversation.ModelName(), Content: p.content}, nil
+}
+
+func (p *fakeProvider) createCompletionStream(ctx context.Context, conversation model.Conversation, onDelta func(delta string) error) (model.ChatResponse, error) {
+	p.calls++
+	if err := onDelta(p.content); err != nil {
+		return model.ChatResponse{}, err
+	}
+	return model.ChatResponse{Model: conversation.ModelName(), Content: p.content}, nil
+}
+
+func (p *fakeProvider) createImage(ctx context.Context, prompt string, options model.ImageOptions) ([]model.GeneratedImage, error) {
+	p.calls++
+	return nil, nil
+}
+
+func TestGptRepositoryRoutesByProvider(t *testing.T) {
+	openAI := &fakeProvider{content: "from openai"}
+	local := &fakeProvider{content: "from local"}
+	r := &gptRepository{
+		providers:       map[string]provider{"openai": openAI, "local": local},
+		defaultProvider: "openai",
+	}
+
+	tests := []struct {
+		name         string
+		provider     string
+		wantProvider string
+		wantContent  string
+		wantErr      bool
+	}{
+		{name: "default provider", provider: "", wantProvider: "openai", wantContent: "from openai"},
+		{name: "named provider", provider: "local", wantProvider: "local", wantContent: "from local"},
+		{name: "unknown provider", provider: "azure", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conversation := model.NewConversation("", "hello")
+			conversation.Provider = tt.provider
+
+			resp, err := r.CreateCompletion(context.Background(), conversation)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CreateCompletion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if resp.Provider != tt.wantProvider || resp.Content != tt.wantContent {
+				t.Errorf("CreateCompletion() = %+v, want provider %s and content %q", resp, tt.wantProvider, tt.wantContent)
+			}
+		})
+	}
+}
+
+func TestNewGptRepository(t *testing.T) {
+	tests := []struct {
+		name            string
+		configs         []ProviderConfig
+		defaultProvider string
+		fallbacks       []FallbackTarget
+		wantErr         bool
+	}{
+		{
+			name: "openai, azure and compatible",
+			configs: []ProviderConfig{
+				{Name: "openai", Type: ProviderTypeOpenAI, APIKey: "sk-test"},
+				{Name: "azure", Type: ProviderTypeAzure, APIKey: "key", BaseURL: "https://example.openai.azure.com", Deployments: map[string]string{"gpt-4o": "chat"}},
+				{Name: "local", Type: ProviderTypeCompatible, BaseURL: "http://localhost:11434/v1"},
+			},
+			defaultProvider: "openai",
+			fallbacks:       []FallbackTarget{{Provider: "azure"}, {Provider: "local", Model: "llama3.1:8b"}},
+		},
+		{
+			name:            "unknown fallback provider",
+			configs:         []ProviderConfig{{Name: "openai", APIKey: "sk-test"}},
+			defaultProvider: "openai",
+			fallbacks:       []FallbackTarget{{Provider: "local"}},
+			wantErr:         true,
+		},
+		{
+			name:            "azure without base url",
+			configs:         []ProviderConfig{{Name: "azure", Type: ProviderTypeAzure, APIKey: "key"}},
+			defaultProvider: "azure",
+			wantErr:         true,
+		},
+		{
+			name:            "compatible without base url",
+			configs:         []ProviderConfig{{Name: "local", Type: ProviderTypeCompatible}},
+			defaultProvider: "local",
+			wantErr:         true,
+		},
+		{
+			name:            "unknown type",
+			configs:         []ProviderConfig{{Name: "other", Type: "anthropic"}},
+			defaultProvider: "other",
+			wantErr:         true,
+		},
+		{
+			name:            "unknown default provider",
+			configs:         []ProviderConfig{{Name: "openai", APIKey: "sk-test"}},
+			defaultProvider: "local",
+			wantErr:         true,
+		},
+		{
+			name:            "duplicate provider",
+			configs:         []ProviderConfig{{Name: "openai", APIKey: "a"}, {Name: "openai", APIKey: "b"}},
+			defaultProvider: "openai",
+			wantErr:         true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewGptRepository(tt.configs, tt.defaultProvider, tt.fallbacks)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewGptRepository() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCreateImagePartialDownload(t *testing.T) {
+	// 2枚作成したうち、URLで返した1枚はダウンロードできない
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	mux.HandleFunc("/images/generations", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"created":1,"data":[{"b64_json":%q},{"url":%q}]}`,
+			base64.StdEncoding.EncodeToString([]byte("\x89PNG\r\n\x1a\n")), server.URL+"/missing.png")
+	})
+	mux.HandleFunc("/missing.png", http.NotFound)
+
+	r, err := NewGptRepository([]ProviderConfig{{Name: "openai", Type: ProviderTypeCompatible, BaseURL: server.URL}}, "openai", nil)
+	if err != nil {
+		t.Fatalf("NewGptRepository() error = %v", err)
+	}
+
+	images, err := r.CreateImage(context.Background(), "prompt", model.ImageOptions{Model: "dall-e-2", Count: 2})
+	var downloadErr *model.ImageDownloadError
+	if !errors.As(err, &downloadErr) {
+		t.Fatalf("CreateImage() error = %v, want ImageDownloadError", err)
+	}
+	if downloadErr.Generated != 2 {
+		t.Errorf("Generated = %d, want 2", downloadErr.Generated)
+	}
+	if len(images) != 1 || images[0].ContentType != "image/png" {
+		t.Errorf("CreateImage() images = %+v, want the downloaded PNG", images)
+	}
+}
@@ -0,0 +1,254 @@
+package gpt
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	// imageDownloadTimeout URLで返された画像をダウンロードするときのタイムアウト
+	imageDownloadTimeout = 30 * time.Second
+	// maxImageBytes ダウンロードする画像の最大サイズ
+	maxImageBytes = 20 << 20
+	// gptImageModelPrefix GPT Imageのモデル（gpt-image-1 など）
+	gptImageModelPrefix = "gpt-image"
+)
+
+// openAIProvider OpenAIのAPI形式のプロバイダー。OpenAI・Azure OpenAI・OpenAI互換のサーバーはクライアントの設定だけが異なる
+type openAIProvider struct {
+	client     *openai.Client
+	httpClient *http.Client
+}
+
+func newOpenAIProvider(config ProviderConfig) (*openAIProvider, error) {
+	clientConfig, err := config.clientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed config.clientConfig: %w", err)
+	}
+	clientConfig.HTTPClient = newRetryingDoer(Retry, newCircuitBreaker(Breaker))
+	return &openAIProvider{
+		client:     openai.NewClientWithConfig(clientConfig),
+		httpClient: &http.Client{Timeout: imageDownloadTimeout},
+	}, nil
+}
+
+func (p *openAIProvider) createCompletion(ctx context.Context, conversation model.Conversation) (model.ChatResponse, error) {
+	resp, err := p.client.CreateChatCompletion(
+		ctx,
+		openai.ChatCompletionRequest{
+			Model:       conversation.ModelName(),
+			Messages:    toChatCompletionMessages(conversation),
+			MaxTokens:   conversation.MaxTokens,
+			Temperature: conversation.Temperature,
+		},
+	)
+
+	if err != nil {
+		return model.ChatResponse{}, fmt.Errorf("failed p.client.CreateChatCompletion: %w", err)
+	}
+
+	response := model.ChatResponse{
+		ID:    resp.ID,
+		Model: resp.Model,
+		Usage: model.ChatUsage{
+			PromptTokens:     resp.Usage.PromptTokens,
+			CompletionTokens: resp.Usage.CompletionTokens,
+		},
+	}
+	if len(resp.Choices) > 0 {
+		response.Content = resp.Choices[0].Message.Content
+		response.FinishReason = string(resp.Choices[0].FinishReason)
+	}
+	return response, nil
+}
+
+func (p *openAIProvider) createCompletionStream(ctx context.Context, conversation model.Conversation, onDelta func(delta string) error) (model.ChatResponse, error) {
+	stream, err := p.client.CreateChatCompletionStream(
+		ctx,
+		openai.ChatCompletionRequest{
+			Model:       conversation.ModelName(),
+			Messages:    toChatCompletionMessages(conversation),
+			MaxTokens:   conversation.MaxTokens,
+			Temperature: conversation.Temperature,
+			Stream:      true,
+			// 最後のチャンクでトークン使用量を受け取る
+			StreamOptions: &openai.StreamOptions{IncludeUsage: true},
+		},
+	)
+	if err != nil {
+		return model.ChatResponse{}, fmt.Errorf("failed p.client.CreateChatCompletionStream: %w", err)
+	}
+	defer stream.Close()
+
+	// 受け取ったチャンクを通常の応答と同じ形にまとめる
+	var content strings.Builder
+	result := model.ChatResponse{Model: conversation.ModelName()}
+	for {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return model.ChatResponse{}, fmt.Errorf("failed stream.Recv: %w", err)
+		}
+
+		result.ID = resp.ID
+		if resp.Model != "" {
+			result.Model = resp.Model
+		}
+		if resp.Usage != nil {
+			result.Usage = model.ChatUsage{
+				PromptTokens:     resp.Usage.PromptTokens,
+				CompletionTokens: resp.Usage.CompletionTokens,
+			}
+		}
+		if len(resp.Choices) == 0 {
+			continue
+		}
+		if resp.Choices[0].FinishReason != "" {
+			result.FinishReason = string(resp.Choices[0].FinishReason)
+		}
+		if resp.Choices[0].Delta.Content == "" {
+			continue
+		}
+		content.WriteString(resp.Choices[0].Delta.Content)
+		if err := onDelta(resp.Choices[0].Delta.Content); err != nil {
+			return model.ChatResponse{}, fmt.Errorf("failed onDelta: %w", err)
+		}
+	}
+
+	result.Content = content.String()
+	return result, nil
+}
+
+// toChatCompletionMessages 会話をOpenAIのメッセージ形式に変換する。システムプロンプトは先頭のsystemメッセージになる
+func toChatCompletionMessages(conversation model.Conversation) []openai.ChatCompletionMessage {
+	messages := make([]openai.ChatCompletionMessage, 0, len(conversation.Messages)+1)
+	if systemContent := conversation.SystemContent(); systemContent != "" {
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: systemContent,
+		})
+	}
+	for _, message := range conversation.Messages {
+		if len(message.Images) == 0 {
+			messages = append(messages, openai.ChatCompletionMessage{
+				Role:    string(message.Role),
+				Content: message.Content,
+			})
+			continue
+		}
+
+		// 添付画像があるターンはテキストと画像のパートに分けて送る
+		parts := []openai.ChatMessagePart{{
+			Type: openai.ChatMessagePartTypeText,
+			Text: message.Content,
+		}}
+		for _, image := range message.Images {
+			parts = append(parts, openai.ChatMessagePart{
+				Type: openai.ChatMessagePartTypeImageURL,
+				ImageURL: &openai.ChatMessageImageURL{
+					URL:    image.DataURL(),
+					Detail: openai.ImageURLDetailAuto,
+				},
+			})
+		}
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role:         string(message.Role),
+			MultiContent: parts,
+		})
+	}
+	return messages
+}
+
+func (p *openAIProvider) createImage(ctx context.Context, prompt string, options model.ImageOptions) ([]model.GeneratedImage, error) {
+	request := openai.ImageRequest{
+		Prompt:  prompt,
+		Model:   options.Model,
+		Size:    options.Size,
+		Quality: options.Quality,
+		Style:   options.Style,
+		N:       options.ImageCount(),
+	}
+	// 短時間で失効するURLに頼らないよう画像そのものを受け取る。GPT Imageのモデルは常にbase64で返し、指定するとエラーになる
+	if !strings.HasPrefix(options.Model, gptImageModelPrefix) {
+		request.ResponseFormat = openai.CreateImageResponseFormatB64JSON
+	}
+
+	resp, err := p.client.CreateImage(ctx, request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create image: %w", err)
+	}
+	if len(resp.Data) == 0 {
+		return nil, errors.New("no image in response")
+	}
+
+	// 取得できなかった画像があっても、取得できた画像と作成した枚数を返す
+	images := make([]model.GeneratedImage, 0, len(resp.Data))
+	var errs []error
+	for _, data := range resp.Data {
+		content, err := p.imageContent(ctx, data)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		images = append(images, model.NewGeneratedImage(content, data.RevisedPrompt))
+	}
+	if len(errs) > 0 {
+		return images, &model.ImageDownloadError{Generated: len(resp.Data), Err: errors.Join(errs...)}
+	}
+	return images, nil
+}
+
+// imageContent base64で返された画像はデコードし、URLで返された画像はダウンロードする
+func (p *openAIProvider) imageContent(ctx context.Context, data openai.ImageResponseDataInner) ([]byte, error) {
+	if data.B64JSON != "" {
+		content, err := base64.StdEncoding.DecodeString(data.B64JSON)
+		if err != nil {
+			return nil, fmt.Errorf("failed base64.StdEncoding.DecodeString: %w", err)
+		}
+		return content, nil
+	}
+	if data.URL == "" {
+		return nil, errors.New("image has neither b64_json nor url")
+	}
+
+	content, err := p.downloadImage(ctx, data.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed p.downloadImage: %w", err)
+	}
+	return content, nil
+}
+
+func (p *openAIProvider) downloadImage(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed http.NewRequestWithContext: %w", err)
+	}
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed p.httpClient.Do: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status downloading image: %s", resp.Status)
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxImageBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed io.ReadAll: %w", err)
+	}
+	if len(content) > maxImageBytes {
+		return nil, fmt.Errorf("image exceeds %d bytes", maxImageBytes)
+	}
+	return content, nil
+}
@@ -0,0 +1,71 @@
+package gpt
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// プロバイダーの種類
+const (
+	ProviderTypeOpenAI     = "openai"            // OpenAIのAPI
+	ProviderTypeAzure      = "azure"             // Azure OpenAI
+	ProviderTypeCompatible = "openai_compatible" // OllamaやvLLMなど、OpenAI互換のAPIを持つサーバー
+)
+
+// DefaultProviderName OPENAI_API_KEY で設定するOpenAIのプロバイダーの名前
+const DefaultProviderName = "openai"
+
+// defaultAzureAPIVersion ストリーミングでトークン使用量を受け取れる（stream_options に対応した）APIのバージョン
+const defaultAzureAPIVersion = "2024-10-21"
+
+// ProviderConfig LLMのプロバイダーの接続先
+type ProviderConfig struct {
+	Name       string // ペルソナやチャンネルのルールで指定する名前
+	Type       string // ProviderTypeOpenAI / ProviderTypeAzure / ProviderTypeCompatible
+	APIKey     string
+	BaseURL    string // openai は省略可。azure はリソースのエンドポイント（https://xxx.openai.azure.com）
+	APIVersion string // azure のみ。省略した場合は defaultAzureAPIVersion
+	// Deployments azure のみ。モデル名 -> デプロイ名。設定のないモデルはモデル名をデプロイ名とする
+	Deployments map[string]string
+}
+
+// clientConfig 種類に応じたOpenAIのクライアントの設定を作る
+func (c ProviderConfig) clientConfig() (openai.ClientConfig, error) {
+	switch c.Type {
+	case "", ProviderTypeOpenAI:
+		config := openai.DefaultConfig(c.APIKey)
+		if c.BaseURL != "" {
+			config.BaseURL = strings.TrimRight(c.BaseURL, "/")
+		}
+		return config, nil
+	case ProviderTypeAzure:
+		if c.BaseURL == "" || c.APIKey == "" {
+			return openai.ClientConfig{}, errors.New("azure provider requires base url and api key")
+		}
+		config := openai.DefaultAzureConfig(c.APIKey, strings.TrimRight(c.BaseURL, "/"))
+		config.APIVersion = defaultAzureAPIVersion
+		if c.APIVersion != "" {
+			config.APIVersion = c.APIVersion
+		}
+		config.AzureModelMapperFunc = func(modelName string) string {
+			if deployment, ok := c.Deployments[modelName]; ok {
+				return deployment
+			}
+			return modelName
+		}
+		return config, nil
+	case ProviderTypeCompatible:
+		if c.BaseURL == "" {
+			return openai.ClientConfig{}, errors.New("openai_compatible provider requires base url")
+		}
+		// APIキーが不要なローカルのサーバーではキーを空のままにする
+		config := openai.DefaultConfig(c.APIKey)
+		config.BaseURL = strings.TrimRight(c.BaseURL, "/")
+		return config, nil
+	default:
+		return openai.ClientConfig{}, fmt.Errorf("unknown provider type %q", c.Type)
+	}
+}
@@ -0,0 +1,146 @@
+package gpt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy 一時的なエラー（429・5xx・通信エラー）を再試行する設定
+type RetryPolicy struct {
+	MaxRetries int           // 最初の呼び出しに加えて再試行する回数
+	BaseDelay  time.Duration // 1回目の再試行までの待ち時間の目安。再試行ごとに2倍にする
+	MaxDelay   time.Duration // 待ち時間の上限。Retry-After がこれを超える場合は再試行しない
+}
+
+// Retry プロバイダーの呼び出しの再試行の設定
+var Retry = RetryPolicy{
+	MaxRetries: 3,
+	BaseDelay:  500 * time.Millisecond,
+	MaxDelay:   20 * time.Second,
+}
+
+// delay attempt 回目（0から数える）の再試行までの待ち時間。同時に失敗した呼び出しが揃って再試行しないよう、半分から全体の間でばらつかせる
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	backoff := p.BaseDelay << attempt
+	if backoff <= 0 || backoff > p.MaxDelay {
+		backoff = p.MaxDelay
+	}
+	return backoff/2 + rand.N(backoff/2+1)
+}
+
+// isRetryableStatus 時間をおけば成功する見込みのあるステータスコードかどうか
+func isRetryableStatus(code int) bool {
+	return code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+}
+
+// retryAfter Retry-After ヘッダー（秒数またはHTTP日付）の待ち時間。指定がない場合は false を返す
+func retryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0), true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// retryingDoer OpenAIのクライアントが使うHTTPクライアント。一時的なエラーを再試行し、失敗が続くプロバイダーは一定時間呼び出さない
+// Retry-After などのヘッダーを読むため、OpenAIのクライアントのエラーに変換される前のレスポンスで判定する
+type retryingDoer struct {
+	client  *http.Client
+	policy  RetryPolicy
+	breaker *circuitBreaker
+	now     func() time.Time
+}
+
+func newRetryingDoer(policy RetryPolicy, breaker *circuitBreaker) *retryingDoer {
+	return &retryingDoer{
+		client:  &http.Client{},
+		policy:  policy,
+		breaker: breaker,
+		now:     time.Now,
+	}
+}
+
+func (d *retryingDoer) Do(req *http.Request) (*http.Response, error) {
+	if !d.breaker.allow() {
+		return nil, ErrCircuitOpen
+	}
+
+	ctx := req.Context()
+	for attempt := 0; ; attempt++ {
+		attemptReq, err := rewindRequest(req, attempt)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := d.client.Do(attemptReq)
+		if err == nil && !isRetryableStatus(resp.StatusCode) {
+			// 400などの再試行しないエラーもプロバイダーには届いているので、障害としては数えない
+			d.breaker.success()
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			// 呼び出し元のタイムアウトやキャンセルはプロバイダーの障害ではない
+			return resp, err
+		}
+
+		wait := d.policy.delay(attempt)
+		if err == nil {
+			if after, ok := retryAfter(resp.Header, d.now()); ok {
+				wait = after
+			}
+		}
+		if attempt >= d.policy.MaxRetries || wait > d.policy.MaxDelay {
+			// 最後のエラーはそのまま返し、OpenAIのクライアントにエラーの内容を読ませる
+			d.breaker.failure()
+			return resp, err
+		}
+		if err == nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		if err := sleepContext(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// rewindRequest 再試行のためにリクエストの本文を読み直せるようにする
+func rewindRequest(req *http.Request, attempt int) (*http.Request, error) {
+	if attempt == 0 || req.Body == nil || req.Body == http.NoBody {
+		return req, nil
+	}
+	if req.GetBody == nil {
+		return nil, errors.New("request body cannot be replayed")
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, fmt.Errorf("failed req.GetBody: %w", err)
+	}
+	clone := req.Clone(req.Context())
+	clone.Body = body
+	return clone, nil
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
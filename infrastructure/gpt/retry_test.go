@@ -0,0 +1,433 @@
+package gpt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+	"github.com/sashabaranov/go-openai"
+)
+
+// fakeOpenAI OpenAIのAPIの代わりに、リクエストごとに決まったステータスを返すサーバー
+type fakeOpenAI struct {
+	content    string
+	statuses   []int  // リクエストの順に返すステータス。使い切った後は200を返す
+	failAlways int    // 0以外の場合は常にこのステータスを返す
+	retryAfter string // エラーのときに返す Retry-After
+
+	mu       sync.Mutex
+	requests int
+	models   []string // 受け取ったリクエストのモデル
+}
+
+func (f *fakeOpenAI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Model  string `json:"model"`
+		Stream bool   `json:"stream"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&request)
+
+	f.mu.Lock()
+	status := http.StatusOK
+	if f.failAlways != 0 {
+		status = f.failAlways
+	} else if f.requests < len(f.statuses) {
+		status = f.statuses[f.requests]
+	}
+	f.requests++
+	f.models = append(f.models, request.Model)
+	f.mu.Unlock()
+
+	if status != http.StatusOK {
+		if f.retryAfter != "" {
+			w.Header().Set("Retry-After", f.retryAfter)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `{"error":{"message":"status %d","type":"server_error"}}`, status)
+		return
+	}
+
+	if request.Stream {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "data: {\"id\":\"chatcmpl-1\",\"model\":%q,\"choices\":[{\"index\":0,\"delta\":{\"content\":%q}}]}\n\n", request.Model, f.content)
+		fmt.Fprintf(w, "data: {\"id\":\"chatcmpl-1\",\"model\":%q,\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}]}\n\n", request.Model)
+		fmt.Fprintf(w, "data: {\"id\":\"chatcmpl-1\",\"model\":%q,\"choices\":[],\"usage\":{\"prompt_tokens\":3,\"completion_tokens\":2,\"total_tokens\":5}}\n\n", request.Model)
+		fmt.Fprint(w, "data: [DONE]\n\n")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"id":"chatcmpl-1","model":%q,"choices":[{"index":0,"message":{"role":"assistant","content":%q},"finish_reason":"stop"}],"usage":{"prompt_tokens":3,"completion_tokens":2,"total_tokens":5}}`, request.Model, f.content)
+}
+
+func (f *fakeOpenAI) requestCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests
+}
+
+// newFakeRepository fakeOpenAI をOpenAI互換のプロバイダーとして登録する。先頭のプロバイダーをデフォルトにする
+func newFakeRepository(t *testing.T, names []string, servers []*fakeOpenAI, fallbacks []FallbackTarget) *gptRepository {
+	t.Helper()
+
+	originalRetry, originalBreaker := Retry, Breaker
+	Retry = RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: 50 * time.Millisecond}
+	t.Cleanup(func() { Retry, Breaker = originalRetry, originalBreaker })
+
+	configs := make([]ProviderConfig, len(names))
+	for i, name := range names {
+		server := httptest.NewServer(servers[i])
+		t.Cleanup(server.Close)
+		configs[i] = ProviderConfig{Name: name, Type: ProviderTypeCompatible, BaseURL: server.URL}
+	}
+	r, err := NewGptRepository(configs, names[0], fallbacks)
+	if err != nil {
+		t.Fatalf("NewGptRepository() error = %v", err)
+	}
+	return r.(*gptRepository)
+}
+
+func testConversation() model.Conversation {
+	conversation := model.NewConversation("", "hello")
+	conversation.Model = "primary-model"
+	return conversation
+}
+
+func TestCreateCompletionRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		server       *fakeOpenAI
+		wantErr      bool
+		wantStatus   int
+		wantRequests int
+	}{
+		{
+			name:         "retry 429 and 503 then succeed",
+			server:       &fakeOpenAI{content: "ok", statuses: []int{429, 503}, retryAfter: "0"},
+			wantRequests: 3,
+		},
+		{
+			name:         "give up after max retries",
+			server:       &fakeOpenAI{failAlways: 500},
+			wantErr:      true,
+			wantStatus:   500,
+			wantRequests: 3,
+		},
+		{
+			name:         "retry-after longer than max delay",
+			server:       &fakeOpenAI{failAlways: 429, retryAfter: "60"},
+			wantErr:      true,
+			wantStatus:   429,
+			wantRequests: 1,
+		},
+		{
+			name:         "bad request is not retried",
+			server:       &fakeOpenAI{failAlways: 400},
+			wantErr:      true,
+			wantStatus:   400,
+			wantRequests: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newFakeRepository(t, []string{"primary"}, []*fakeOpenAI{tt.server}, nil)
+
+			resp, err := r.CreateCompletion(context.Background(), testConversation())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CreateCompletion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := tt.server.requestCount(); got != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", got, tt.wantRequests)
+			}
+			if tt.wantErr {
+				var apiErr *openai.APIError
+				if !errors.As(err, &apiErr) || apiErr.HTTPStatusCode != tt.wantStatus {
+					t.Errorf("CreateCompletion() error = %v, want status %d", err, tt.wantStatus)
+				}
+				return
+			}
+			if resp.Content != "ok" || resp.Provider != "primary" || resp.FallbackModel != "" {
+				t.Errorf("CreateCompletion() = %+v", resp)
+			}
+			if resp.Usage.PromptTokens != 3 || resp.Usage.CompletionTokens != 2 {
+				t.Errorf("Usage = %+v", resp.Usage)
+			}
+		})
+	}
+}
+
+func TestCreateCompletionFallback(t *testing.T) {
+	tests := []struct {
+		name              string
+		primary           *fakeOpenAI
+		wantErr           bool
+		wantProvider      string
+		wantFallbackModel string
+		wantBackupModels  []string
+	}{
+		{
+			name:              "server errors fall back",
+			primary:           &fakeOpenAI{failAlways: 502},
+			wantProvider:      "backup",
+			wantFallbackModel: "backup-model",
+			wantBackupModels:  []string{"backup-model"},
+		},
+		{
+			name:              "rate limit falls back",
+			primary:           &fakeOpenAI{failAlways: 429, retryAfter: "60"},
+			wantProvider:      "backup",
+			wantFallbackModel: "backup-model",
+			wantBackupModels:  []string{"backup-model"},
+		},
+		{
+			name:         "bad request does not fall back",
+			primary:      &fakeOpenAI{failAlways: 400},
+			wantErr:      true,
+			wantProvider: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backup := &fakeOpenAI{content: "from backup"}
+			r := newFakeRepository(t,
+				[]string{"primary", "backup"},
+				[]*fakeOpenAI{tt.primary, backup},
+				[]FallbackTarget{{Provider: "primary"}, {Provider: "backup", Model: "backup-model"}},
+			)
+
+			resp, err := r.CreateCompletion(context.Background(), testConversation())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CreateCompletion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if resp.Provider != tt.wantProvider || resp.FallbackModel != tt.wantFallbackModel {
+				t.Errorf("CreateCompletion() = %+v, want provider %q and fallback model %q", resp, tt.wantProvider, tt.wantFallbackModel)
+			}
+			if len(backup.models) != len(tt.wantBackupModels) {
+				t.Fatalf("backup models = %v, want %v", backup.models, tt.wantBackupModels)
+			}
+			for i, m := range tt.wantBackupModels {
+				if backup.models[i] != m {
+					t.Errorf("backup models = %v, want %v", backup.models, tt.wantBackupModels)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateCompletionPinnedProviderDoesNotFallBack(t *testing.T) {
+	primary := &fakeOpenAI{content: "from primary"}
+	local := &fakeOpenAI{failAlways: 503}
+	backup := &fakeOpenAI{content: "from backup"}
+	r := newFakeRepository(t,
+		[]string{"primary", "local", "backup"},
+		[]*fakeOpenAI{primary, local, backup},
+		[]FallbackTarget{{Provider: "backup"}},
+	)
+
+	// ペルソナやルールで local を指定した会話は、local が落ちていても他のプロバイダーに送らない
+	conversation := testConversation()
+	conversation.Provider = "local"
+	if _, err := r.CreateCompletion(context.Background(), conversation); err == nil {
+		t.Fatal("CreateCompletion() error = nil, want the pinned provider's error")
+	}
+	if backup.requestCount() != 0 || primary.requestCount() != 0 {
+		t.Errorf("requests to primary = %d, backup = %d, want 0", primary.requestCount(), backup.requestCount())
+	}
+}
+
+func TestCreateCompletionSkipsIncompatibleFallbacks(t *testing.T) {
+	originalBudgets := model.ContextTokenBudgets
+	model.ContextTokenBudgets = map[string]int{"primary-model": 16000, "small-model": 4000, "large-model": 16000, "text-model": 16000}
+	t.Cleanup(func() { model.ContextTokenBudgets = originalBudgets })
+	originalVision := model.VisionModels
+	model.VisionModels = []string{"primary-model", "large-model"}
+	t.Cleanup(func() { model.VisionModels = originalVision })
+
+	withImage := testConversation()
+	withImage.Messages[0].Images = []model.ChatImage{{ContentType: "image/png", Data: []byte("a")}}
+
+	tests := []struct {
+		name         string
+		conversation model.Conversation
+		fallbacks    []FallbackTarget
+		wantErr      bool
+		wantModel    string
+	}{
+		{
+			name:         "smaller budget is skipped",
+			conversation: testConversation(),
+			fallbacks:    []FallbackTarget{{Provider: "backup", Model: "small-model"}, {Provider: "backup", Model: "large-model"}},
+			wantModel:    "large-model",
+		},
+		{
+			name:         "non-vision model is skipped for images",
+			conversation: withImage,
+			fallbacks:    []FallbackTarget{{Provider: "backup", Model: "text-model"}},
+			wantErr:      true,
+		},
+		{
+			name:         "non-vision model is used without images",
+			conversation: testConversation(),
+			fallbacks:    []FallbackTarget{{Provider: "backup", Model: "text-model"}},
+			wantModel:    "text-model",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backup := &fakeOpenAI{content: "from backup"}
+			r := newFakeRepository(t,
+				[]string{"primary", "backup"},
+				[]*fakeOpenAI{{failAlways: 503}, backup},
+				tt.fallbacks,
+			)
+
+			resp, err := r.CreateCompletion(context.Background(), tt.conversation)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CreateCompletion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if backup.requestCount() != 0 {
+					t.Errorf("backup requests = %d, want 0", backup.requestCount())
+				}
+				return
+			}
+			if resp.FallbackModel != tt.wantModel || backup.requestCount() != 1 {
+				t.Errorf("FallbackModel = %q, backup requests = %d, want %q and 1", resp.FallbackModel, backup.requestCount(), tt.wantModel)
+			}
+		})
+	}
+}
+
+func TestCreateCompletionStreamFallback(t *testing.T) {
+	primary := &fakeOpenAI{failAlways: 503}
+	backup := &fakeOpenAI{content: "streamed"}
+	r := newFakeRepository(t,
+		[]string{"primary", "backup"},
+		[]*fakeOpenAI{primary, backup},
+		[]FallbackTarget{{Provider: "backup"}},
+	)
+
+	var deltas string
+	resp, err := r.CreateCompletionStream(context.Background(), testConversation(), func(delta string) error {
+		deltas += delta
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("CreateCompletionStream() error = %v", err)
+	}
+	if deltas != "streamed" || resp.Content != "streamed" {
+		t.Errorf("deltas = %q, content = %q", deltas, resp.Content)
+	}
+	if resp.Provider != "backup" || resp.FallbackModel != "primary-model" || resp.FinishReason != "stop" {
+		t.Errorf("CreateCompletionStream() = %+v", resp)
+	}
+	if resp.Usage.CompletionTokens != 2 {
+		t.Errorf("Usage = %+v", resp.Usage)
+	}
+}
+
+func TestCircuitBreakerSkipsFailingProvider(t *testing.T) {
+	// ブレーカーの設定はプロバイダーの作成時に読むため、先に差し替える
+	originalBreaker := Breaker
+	Breaker = BreakerPolicy{Threshold: 1, Cooldown: time.Hour}
+	t.Cleanup(func() { Breaker = originalBreaker })
+
+	primary := &fakeOpenAI{failAlways: 500}
+	backup := &fakeOpenAI{content: "from backup"}
+	r := newFakeRepository(t,
+		[]string{"primary", "backup"},
+		[]*fakeOpenAI{primary, backup},
+		[]FallbackTarget{{Provider: "backup"}},
+	)
+
+	for i := range 2 {
+		resp, err := r.CreateCompletion(context.Background(), testConversation())
+		if err != nil {
+			t.Fatalf("call %d: CreateCompletion() error = %v", i, err)
+		}
+		if resp.Provider != "backup" {
+			t.Errorf("call %d: Provider = %q, want backup", i, resp.Provider)
+		}
+	}
+	// 2回目は止めているため、primary には最初の呼び出しの分しか届かない
+	if got := primary.requestCount(); got != Retry.MaxRetries+1 {
+		t.Errorf("primary requests = %d, want %d", got, Retry.MaxRetries+1)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := newCircuitBreaker(BreakerPolicy{Threshold: 2, Cooldown: time.Minute})
+	b.now = func() time.Time { return now }
+
+	b.failure()
+	if !b.allow() {
+		t.Fatal("allow() = false before reaching the threshold")
+	}
+	b.failure()
+	if b.allow() {
+		t.Fatal("allow() = true after reaching the threshold")
+	}
+
+	now = now.Add(time.Minute)
+	if !b.allow() {
+		t.Fatal("allow() = false after the cooldown")
+	}
+	if b.allow() {
+		t.Fatal("allow() = true while the trial call is in flight")
+	}
+	b.success()
+	if !b.allow() {
+		t.Fatal("allow() = false after a successful trial call")
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{name: "seconds", value: "3", want: 3 * time.Second, wantOK: true},
+		{name: "http date", value: now.Add(10 * time.Second).Format(http.TimeFormat), want: 10 * time.Second, wantOK: true},
+		{name: "past date", value: now.Add(-time.Minute).Format(http.TimeFormat), want: 0, wantOK: true},
+		{name: "missing", value: "", wantOK: false},
+		{name: "invalid", value: "soon", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.value != "" {
+				header.Set("Retry-After", tt.value)
+			}
+			got, ok := retryAfter(header, now)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("retryAfter() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for attempt := range 10 {
+		backoff := min(policy.BaseDelay<<attempt, policy.MaxDelay)
+		got := policy.delay(attempt)
+		if got < backoff/2 || got > backoff {
+			t.Errorf("delay(%d) = %v, want between %v and %v", attempt, got, backoff/2, backoff)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package jsonl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+	"github.com/gs1068/slack-gpt-bot/domain/repository"
+)
+
+// auditRepository 監査ログを1行1レコードのJSONで書き込む
+type auditRepository struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewAuditRepository(w io.Writer) repository.AuditRepository {
+	return &auditRepository{
+		w: w,
+	}
+}
+
+// OpenFile 追記用にファイルを開く。ファイルがない場合は作成する
+func OpenFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed os.OpenFile: %w", err)
+	}
+	return f, nil
+}
+
+func (r *auditRepository) RecordCompletion(ctx context.Context, record model.AuditRecord) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed json.Marshal: %w", err)
+	}
+
+	// 複数のジョブから同時に書き込んでも行が混ざらないようにする
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, err := r.w.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("failed r.w.Write: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package jsonl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+)
+
+func TestRecordCompletion(t *testing.T) {
+	var buf bytes.Buffer
+	repo := NewAuditRepository(&buf)
+
+	const records = 50
+	var wg sync.WaitGroup
+	for i := 0; i < records; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := repo.RecordCompletion(context.Background(), model.AuditRecord{
+				Time:         time.Now(),
+				Kind:         model.AuditKindReply,
+				ChannelID:    "C1",
+				Model:        "gpt-4o",
+				PromptTokens: i,
+				PromptHash:   "abc",
+			})
+			if err != nil {
+				t.Errorf("RecordCompletion() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	scanner := bufio.NewScanner(&buf)
+	var lines int
+	for scanner.Scan() {
+		var record model.AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("line %d is not a JSON record: %v", lines, err)
+		}
+		if record.ChannelID != "C1" || record.Model != "gpt-4o" {
+			t.Errorf("record = %+v", record)
+		}
+		lines++
+	}
+	if lines != records {
+		t.Errorf("lines = %d, want %d", lines, records)
+	}
+}
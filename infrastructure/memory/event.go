@@ -0,0 +1,77 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gs1068/slack-gpt-bot/domain/repository"
+)
+
+const sweepInterval = time.Minute
+
+type eventDedupRepository struct {
+	mu        sync.Mutex
+	entries   map[string]time.Time // キー -> 有効期限
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewEventDedupRepository() repository.EventDedupRepository {
+	return &eventDedupRepository{
+		entries: make(map[string]time.Time),
+		now:     time.Now,
+	}
+}
+
+func (r *eventDedupRepository) TryAcquire(ctx context.Context, keys []string, ttl time.Duration) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	r.sweep(now)
+
+	for _, key := range keys {
+		if expiresAt, ok := r.entries[key]; ok && now.Before(expiresAt) {
+			return false, nil
+		}
+	}
+	for _, key := range keys {
+		r.entries[key] = now.Add(ttl)
+	}
+	return true, nil
+}
+
+func (r *eventDedupRepository) MarkDone(ctx context.Context, keys []string, ttl time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	expiresAt := r.now().Add(ttl)
+	for _, key := range keys {
+		r.entries[key] = expiresAt
+	}
+	return nil
+}
+
+func (r *eventDedupRepository) Release(ctx context.Context, keys []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, key := range keys {
+		delete(r.entries, key)
+	}
+	return nil
+}
+
+// sweep 期限切れのキーを定期的に削除する
+func (r *eventDedupRepository) sweep(now time.Time) {
+	if now.Sub(r.lastSweep) < sweepInterval {
+		return
+	}
+	for key, expiresAt := range r.entries {
+		if !now.Before(expiresAt) {
+			delete(r.entries, key)
+		}
+	}
+	r.lastSweep = now
+}
@@ -0,0 +1,64 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestEventDedupRepository(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	r := &eventDedupRepository{
+		entries: make(map[string]time.Time),
+		now:     func() time.Time { return now },
+	}
+
+	if ok, _ := r.TryAcquire(ctx, []string{"event:1", "message:C1:1"}, time.Minute); !ok {
+		t.Fatal("first delivery should be acquired")
+	}
+	if ok, _ := r.TryAcquire(ctx, []string{"event:1"}, time.Minute); ok {
+		t.Error("retry while processing should not be acquired")
+	}
+	// app_mentionとmessageのように別のevent_idでも同じメッセージなら重複とみなす
+	if ok, _ := r.TryAcquire(ctx, []string{"event:2", "message:C1:1"}, time.Minute); ok {
+		t.Error("another event for the same message should not be acquired")
+	}
+	if _, ok := r.entries["event:2"]; ok {
+		t.Error("keys of a rejected event should not be stored")
+	}
+
+	// 処理に失敗したら再送を処理できる
+	_ = r.Release(ctx, []string{"event:1", "message:C1:1"})
+	if ok, _ := r.TryAcquire(ctx, []string{"event:1", "message:C1:1"}, time.Minute); !ok {
+		t.Error("redelivery after failure should be acquired")
+	}
+
+	// 処理済みのイベントはTTLの間は再処理しない
+	_ = r.MarkDone(ctx, []string{"event:1", "message:C1:1"}, time.Hour)
+	now = now.Add(30 * time.Minute)
+	if ok, _ := r.TryAcquire(ctx, []string{"event:1"}, time.Minute); ok {
+		t.Error("redelivery after success should not be acquired")
+	}
+
+	now = now.Add(time.Hour)
+	if ok, _ := r.TryAcquire(ctx, []string{"event:1"}, time.Minute); !ok {
+		t.Error("expired key should be acquired again")
+	}
+}
+
+func TestEventDedupRepositoryProcessingExpires(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	r := &eventDedupRepository{
+		entries: make(map[string]time.Time),
+		now:     func() time.Time { return now },
+	}
+
+	_, _ = r.TryAcquire(ctx, []string{"event:1"}, time.Minute)
+	// 処理中にプロセスが落ちた場合でも、TTLが過ぎれば再送を処理できる
+	now = now.Add(2 * time.Minute)
+	if ok, _ := r.TryAcquire(ctx, []string{"event:1"}, time.Minute); !ok {
+		t.Error("stale processing key should be acquired again")
+	}
+}
@@ -0,0 +1,51 @@
+package memory
+
+import (
+	"context"
+	"sync"
+
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+	"github.com/gs1068/slack-gpt-bot/domain/repository"
+)
+
+type preferenceRepository struct {
+	mu          sync.RWMutex
+	preferences map[string]model.Preference // scope+ID -> 設定
+}
+
+func NewPreferenceRepository() repository.PreferenceRepository {
+	return &preferenceRepository{
+		preferences: make(map[string]model.Preference),
+	}
+}
+
+func (r *preferenceRepository) GetPreference(ctx context.Context, scope model.PreferenceScope, id string) (*model.Preference, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	preference, ok := r.preferences[preferenceKey(scope, id)]
+	if !ok {
+		return nil, nil
+	}
+	return &preference, nil
+}
+
+func (r *preferenceRepository) SavePreference(ctx context.Context, preference model.Preference) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.preferences[preferenceKey(preference.Scope, preference.ID)] = preference
+	return nil
+}
+
+func (r *preferenceRepository) DeletePreference(ctx context.Context, scope model.PreferenceScope, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.preferences, preferenceKey(scope, id))
+	return nil
+}
+
+func preferenceKey(scope model.PreferenceScope, id string) string {
+	return string(scope) + ":" + id
+}
@@ -0,0 +1,64 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+	"github.com/gs1068/slack-gpt-bot/domain/repository"
+)
+
+type threadSummaryRepository struct {
+	mu        sync.Mutex
+	summaries map[string]model.ThreadSummary // channel+thread_ts -> 要約
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewThreadSummaryRepository() repository.ThreadSummaryRepository {
+	return &threadSummaryRepository{
+		summaries: make(map[string]model.ThreadSummary),
+		now:       time.Now,
+	}
+}
+
+func (r *threadSummaryRepository) GetThreadSummary(ctx context.Context, channelID string, threadTS string) (*model.ThreadSummary, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	summary, ok := r.summaries[threadKey(channelID, threadTS)]
+	if !ok {
+		return nil, nil
+	}
+	return &summary, nil
+}
+
+func (r *threadSummaryRepository) SaveThreadSummary(ctx context.Context, summary model.ThreadSummary) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	r.sweep(now)
+
+	summary.UpdatedAt = now
+	r.summaries[threadKey(summary.ChannelID, summary.ThreadTS)] = summary
+	return nil
+}
+
+// sweep 長期間更新のないスレッドの要約を定期的に削除する
+func (r *threadSummaryRepository) sweep(now time.Time) {
+	if now.Sub(r.lastSweep) < sweepInterval {
+		return
+	}
+	for key, summary := range r.summaries {
+		if now.Sub(summary.UpdatedAt) > model.ThreadSummaryTTL {
+			delete(r.summaries, key)
+		}
+	}
+	r.lastSweep = now
+}
+
+func threadKey(channelID string, threadTS string) string {
+	return channelID + ":" + threadTS
+}
@@ -0,0 +1,69 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+	"github.com/gs1068/slack-gpt-bot/domain/repository"
+)
+
+// usageRepository プロセス内で使用量を保持する。再起動すると使用量は失われるため、テストやローカル開発で使う
+type usageRepository struct {
+	mu     sync.RWMutex
+	usages map[string]model.SpreadsheetData // ユーザーID -> 使用量
+}
+
+func NewUsageRepository() repository.UsageRepository {
+	return &usageRepository{
+		usages: make(map[string]model.SpreadsheetData),
+	}
+}
+
+func (r *usageRepository) GetUsage(ctx context.Context, userID string) (*model.SpreadsheetData, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	usage, ok := r.usages[userID]
+	if !ok {
+		return nil, nil
+	}
+	return &usage, nil
+}
+
+func (r *usageRepository) UpdateUsage(ctx context.Context, update model.SpreadsheetData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.usages[update.UserID] = update
+	return nil
+}
+
+func (r *usageRepository) IncrementUsage(ctx context.Context, userID string, usage model.TokenUsage) (*model.SpreadsheetData, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data, ok := r.usages[userID]
+	if !ok {
+		data = *model.NewSpreadsheet(userID, 0, "", 0, 0, 0)
+	}
+	data.ResetDailyUsageIfNeeded()
+	data.AddUsage(usage)
+	r.usages[userID] = data
+	return &data, nil
+}
+
+func (r *usageRepository) ListUsage(ctx context.Context) ([]model.SpreadsheetData, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	usages := make([]model.SpreadsheetData, 0, len(r.usages))
+	for _, usage := range r.usages {
+		usages = append(usages, usage)
+	}
+	sort.Slice(usages, func(i, j int) bool {
+		return usages[i].UserID < usages[j].UserID
+	})
+	return usages, nil
+}
@@ -0,0 +1,40 @@
+package memory
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+)
+
+func TestUsageRepository(t *testing.T) {
+	ctx := context.Background()
+	repo := NewUsageRepository()
+
+	got, err := repo.GetUsage(ctx, "U1")
+	if err != nil || got != nil {
+		t.Fatalf("GetUsage() for unknown user = %+v, %v, want nil", got, err)
+	}
+
+	u1 := model.SpreadsheetData{UserID: "U1", TotalUsage: 2, DailyTokensUsage: 250, TotalTokensUsage: 900}
+	u0 := model.SpreadsheetData{UserID: "U0", TotalUsage: 1, DailyTokensUsage: 10, TotalTokensUsage: 10}
+	for _, usage := range []model.SpreadsheetData{u1, u0} {
+		if err := repo.UpdateUsage(ctx, usage); err != nil {
+			t.Fatalf("UpdateUsage() error = %v", err)
+		}
+	}
+
+	got, err = repo.GetUsage(ctx, "U1")
+	if err != nil || !reflect.DeepEqual(*got, u1) {
+		t.Errorf("GetUsage() = %+v, %v, want %+v", got, err, u1)
+	}
+
+	list, err := repo.ListUsage(ctx)
+	if err != nil {
+		t.Fatalf("ListUsage() error = %v", err)
+	}
+	if want := []model.SpreadsheetData{u0, u1}; !reflect.DeepEqual(list, want) {
+		t.Errorf("ListUsage() = %+v, want %+v", list, want)
+	}
+}
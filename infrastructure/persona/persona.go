@@ -0,0 +1,130 @@
+package persona
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+	"github.com/gs1068/slack-gpt-bot/domain/repository"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+type PersonaRepository struct {
+	path string
+
+	mu      sync.RWMutex
+	config  *model.PersonaConfig
+	modTime time.Time
+}
+
+// NewPersonaRepository ペルソナの設定ファイル（YAMLまたはJSON）を読み込む。path が空の場合は組み込みのペルソナだけを使う
+func NewPersonaRepository(path string) (*PersonaRepository, error) {
+	r := &PersonaRepository{
+		path:   path,
+		config: model.NewBuiltinPersonaConfig(),
+	}
+	if err := r.config.Prepare(); err != nil {
+		return nil, fmt.Errorf("failed r.config.Prepare: %w", err)
+	}
+	if path == "" {
+		return r, nil
+	}
+
+	if _, err := r.reload(); err != nil {
+		return nil, fmt.Errorf("failed r.reload: %w", err)
+	}
+	return r, nil
+}
+
+var _ repository.PersonaRepository = (*PersonaRepository)(nil)
+
+func (r *PersonaRepository) FindPersona(ctx context.Context, channel model.ChannelInfo) (model.Persona, string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	persona, provider := r.config.Resolve(channel)
+	return persona, provider, nil
+}
+
+func (r *PersonaRepository) GetPersona(ctx context.Context, name string) (model.Persona, bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	persona, ok := r.config.Persona(name)
+	return persona, ok, nil
+}
+
+func (r *PersonaRepository) ListPersonas(ctx context.Context) ([]model.Persona, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return append([]model.Persona{}, r.config.Personas...), nil
+}
+
+// Watch 設定ファイルの更新を interval ごとに確認し、変更があれば読み込み直す。ctx が終了するまでブロックする
+// 読み込みに失敗した場合は直前の設定を使い続ける
+func (r *PersonaRepository) Watch(ctx context.Context, interval time.Duration) error {
+	if r.path == "" {
+		return nil
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			reloaded, err := r.reload()
+			if err != nil {
+				log.Error().Err(err).Str("path", r.path).Msg("failed to reload persona config")
+				continue
+			}
+			if reloaded {
+				log.Info().Str("path", r.path).Msg("persona config reloaded")
+			}
+		}
+	}
+}
+
+// reload 設定ファイルが前回の読み込みから更新されていれば読み込み直す
+func (r *PersonaRepository) reload() (bool, error) {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return false, fmt.Errorf("failed os.Stat: %w", err)
+	}
+
+	r.mu.Lock()
+	unchanged := info.ModTime().Equal(r.modTime)
+	// 不正な設定でも同じファイルを何度も読み込まないよう、先に記録する
+	r.modTime = info.ModTime()
+	r.mu.Unlock()
+	if unchanged {
+		return false, nil
+	}
+
+	b, err := os.ReadFile(r.path)
+	if err != nil {
+		return false, fmt.Errorf("failed os.ReadFile: %w", err)
+	}
+
+	// JSONはYAMLとしても読み込める
+	config := &model.PersonaConfig{}
+	if err := yaml.Unmarshal(b, config); err != nil {
+		return false, fmt.Errorf("failed yaml.Unmarshal: %w", err)
+	}
+	if err := config.Prepare(); err != nil {
+		return false, fmt.Errorf("invalid persona config: %w", err)
+	}
+
+	r.mu.Lock()
+	r.config = config
+	r.mu.Unlock()
+
+	return true, nil
+}
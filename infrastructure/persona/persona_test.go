@@ -0,0 +1,128 @@
+package persona
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+)
+
+const yamlConfig = `
+default_persona: assistant
+personas:
+  - name: assistant
+    system_prompt: You are a helpful assistant.
+    model: gpt-4o-mini
+    temperature: 0.3
+rules:
+  - dm: true
+    persona: sisters
+`
+
+const jsonConfig = `{
+  "default_persona": "sisters",
+  "personas": [{"name": "incident", "system_prompt": "You are an SRE."}],
+  "rules": [{"channel_pattern": "^eng-incidents", "persona": "incident"}]
+}`
+
+func TestNewPersonaRepository(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name     string
+		file     string
+		content  string
+		channel  model.ChannelInfo
+		wantName string
+	}{
+		{
+			name:     "no config file",
+			channel:  model.ChannelInfo{ID: "C12345"},
+			wantName: model.BuiltinPersonaName,
+		},
+		{
+			name:     "yaml default persona",
+			file:     "personas.yaml",
+			content:  yamlConfig,
+			channel:  model.ChannelInfo{ID: "C12345", Name: "general"},
+			wantName: "assistant",
+		},
+		{
+			name:     "yaml dm rule",
+			file:     "personas.yaml",
+			content:  yamlConfig,
+			channel:  model.ChannelInfo{ID: "D12345", IsIM: true},
+			wantName: model.BuiltinPersonaName,
+		},
+		{
+			name:     "json channel pattern rule",
+			file:     "personas.json",
+			content:  jsonConfig,
+			channel:  model.ChannelInfo{ID: "C12345", Name: "eng-incidents"},
+			wantName: "incident",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var path string
+			if tt.file != "" {
+				path = filepath.Join(t.TempDir(), tt.file)
+				if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			r, err := NewPersonaRepository(path)
+			if err != nil {
+				t.Fatalf("NewPersonaRepository() error = %v", err)
+			}
+			got, _, _ := r.FindPersona(ctx, tt.channel)
+			if got.Name != tt.wantName {
+				t.Errorf("FindPersona() = %v, want %v", got.Name, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestPersonaRepositoryReload(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "personas.yaml")
+	if err := os.WriteFile(path, []byte(yamlConfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewPersonaRepository(path)
+	if err != nil {
+		t.Fatalf("NewPersonaRepository() error = %v", err)
+	}
+	channel := model.ChannelInfo{ID: "C12345", Name: "general"}
+
+	// 不正な設定に更新された場合は直前の設定を使い続ける
+	writeWithModTime(t, path, "default_persona: unknown", time.Now().Add(time.Minute))
+	if _, err := r.reload(); err == nil {
+		t.Error("reload() should fail for invalid config")
+	}
+	if got, _, _ := r.FindPersona(ctx, channel); got.Name != "assistant" {
+		t.Errorf("FindPersona() after invalid reload = %v, want assistant", got.Name)
+	}
+
+	writeWithModTime(t, path, jsonConfig, time.Now().Add(2*time.Minute))
+	if reloaded, err := r.reload(); err != nil || !reloaded {
+		t.Fatalf("reload() = %v, %v", reloaded, err)
+	}
+	if got, _, _ := r.FindPersona(ctx, channel); got.Name != model.BuiltinPersonaName {
+		t.Errorf("FindPersona() after reload = %v, want %v", got.Name, model.BuiltinPersonaName)
+	}
+}
+
+func writeWithModTime(t *testing.T, path string, content string, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
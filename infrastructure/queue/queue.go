@@ -0,0 +1,131 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrQueueFull   = errors.New("job queue is full")
+	ErrQueueClosed = errors.New("job queue is closed")
+)
+
+// Job キューで非同期に実行する処理
+type Job struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// Queue 同時実行数とキューの長さに上限を持つワーカープール
+type Queue struct {
+	jobs        chan Job
+	concurrency int
+	jobTimeout  time.Duration
+
+	mu     sync.RWMutex
+	closed bool
+
+	// 強制終了時に実行中のジョブをキャンセルするためのコンテキスト
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewQueue(concurrency int, size int, jobTimeout time.Duration) *Queue {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if size < 0 {
+		size = 0
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Queue{
+		jobs:        make(chan Job, size),
+		concurrency: concurrency,
+		jobTimeout:  jobTimeout,
+		ctx:         ctx,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+}
+
+// Run ワーカーを起動し、Shutdownが呼ばれてキューが空になるまでブロックする
+func (q *Queue) Run() error {
+	var wg sync.WaitGroup
+	for i := 0; i < q.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range q.jobs {
+				q.run(job)
+			}
+		}()
+	}
+	wg.Wait()
+	close(q.done)
+	return nil
+}
+
+// Enqueue ジョブを追加する。キューが満杯の場合は待たずにErrQueueFullを返す
+func (q *Queue) Enqueue(job Job) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		return ErrQueueClosed
+	}
+
+	select {
+	case q.jobs <- job:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Shutdown 新規ジョブの受付を止め、キューに残ったジョブを処理し終えるまで待つ
+// ctxが先に終了した場合は実行中のジョブをキャンセルしてctx.Err()を返す
+func (q *Queue) Shutdown(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.jobs)
+	}
+	q.mu.Unlock()
+
+	select {
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+		q.cancel()
+		return fmt.Errorf("failed to drain job queue: %w", ctx.Err())
+	}
+}
+
+func (q *Queue) run(job Job) {
+	ctx := q.ctx
+	if q.jobTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, q.jobTimeout)
+		defer cancel()
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error().Str("job", job.Name).Interface("panic", r).Msg("job panicked")
+		}
+	}()
+
+	start := time.Now()
+	if err := job.Run(ctx); err != nil {
+		log.Error().Err(err).Str("job", job.Name).Dur("elapsed", time.Since(start)).Msg("job failed")
+		return
+	}
+	log.Debug().Str("job", job.Name).Dur("elapsed", time.Since(start)).Msg("job finished")
+}
@@ -0,0 +1,125 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestQueueConcurrencyLimit(t *testing.T) {
+	q := NewQueue(2, 10, time.Second)
+	go func() { _ = q.Run() }()
+
+	var running, maxRunning int32
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		err := q.Enqueue(Job{Name: "test", Run: func(ctx context.Context) error {
+			defer wg.Done()
+			n := atomic.AddInt32(&running, 1)
+			for {
+				m := atomic.LoadInt32(&maxRunning)
+				if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			return nil
+		}})
+		if err != nil {
+			t.Fatalf("Enqueue() error = %v", err)
+		}
+	}
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&maxRunning); got > 2 {
+		t.Errorf("max concurrent jobs = %v, want <= 2", got)
+	}
+	if err := q.Shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown() error = %v", err)
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	// Runを呼ばないのでジョブは消費されない
+	q := NewQueue(1, 1, time.Second)
+	noop := Job{Name: "noop", Run: func(ctx context.Context) error { return nil }}
+
+	if err := q.Enqueue(noop); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	if err := q.Enqueue(noop); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Enqueue() error = %v, want %v", err, ErrQueueFull)
+	}
+}
+
+func TestQueueShutdownDrainsJobs(t *testing.T) {
+	q := NewQueue(1, 10, time.Second)
+	var processed int32
+	for i := 0; i < 5; i++ {
+		_ = q.Enqueue(Job{Name: "test", Run: func(ctx context.Context) error {
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&processed, 1)
+			return nil
+		}})
+	}
+	go func() { _ = q.Run() }()
+
+	if err := q.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	if got := atomic.LoadInt32(&processed); got != 5 {
+		t.Errorf("processed jobs = %v, want 5", got)
+	}
+	if err := q.Enqueue(Job{Name: "late"}); !errors.Is(err, ErrQueueClosed) {
+		t.Errorf("Enqueue() after shutdown error = %v, want %v", err, ErrQueueClosed)
+	}
+}
+
+func TestQueueShutdownTimeoutCancelsJobs(t *testing.T) {
+	q := NewQueue(1, 1, 0)
+	canceled := make(chan struct{})
+	_ = q.Enqueue(Job{Name: "blocking", Run: func(ctx context.Context) error {
+		<-ctx.Done()
+		close(canceled)
+		return ctx.Err()
+	}})
+	go func() { _ = q.Run() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := q.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Error("running job was not canceled")
+	}
+}
+
+func TestQueueJobTimeout(t *testing.T) {
+	q := NewQueue(1, 1, 10*time.Millisecond)
+	result := make(chan error, 1)
+	_ = q.Enqueue(Job{Name: "slow", Run: func(ctx context.Context) error {
+		<-ctx.Done()
+		result <- ctx.Err()
+		return ctx.Err()
+	}})
+	go func() { _ = q.Run() }()
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("job context error = %v, want %v", err, context.DeadlineExceeded)
+		}
+	case <-time.After(time.Second):
+		t.Error("job was not timed out")
+	}
+	_ = q.Shutdown(context.Background())
+}
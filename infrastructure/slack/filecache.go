@@ -0,0 +1,100 @@
+package slack
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// fileCacheTTL ダウンロードした添付ファイルをキャッシュする時間
+	fileCacheTTL = time.Hour
+	// fileCacheMaxBytes キャッシュする添付ファイルの合計サイズの上限。超えた場合は古いものから捨てる
+	fileCacheMaxBytes = 64 << 20
+)
+
+// fileCache スレッドに返信するたびに同じ添付ファイルをダウンロードし直さないよう、ファイルIDごとに内容を保持する
+// Slackのファイルは内容を書き換えられないため、IDが同じなら同じ内容として扱う
+type fileCache struct {
+	mu    sync.Mutex
+	files map[string]cachedFile
+	order []fileCacheKey // 追加した順
+	size  int
+	seq   uint64
+	now   func() time.Time
+}
+
+type cachedFile struct {
+	data      []byte
+	expiresAt time.Time
+	seq       uint64
+}
+
+// fileCacheKey 同じIDのファイルを追加し直した場合に、古い順番で新しい内容を捨てないよう追加した順番も持つ
+type fileCacheKey struct {
+	id  string
+	seq uint64
+}
+
+func newFileCache() *fileCache {
+	return &fileCache{
+		files: make(map[string]cachedFile),
+		now:   time.Now,
+	}
+}
+
+func (c *fileCache) get(id string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	file, ok := c.files[id]
+	if !ok {
+		return nil, false
+	}
+	if !c.now().Before(file.expiresAt) {
+		c.remove(id)
+		return nil, false
+	}
+	return file.data, true
+}
+
+func (c *fileCache) put(id string, data []byte) {
+	// 上限より大きいファイルはキャッシュしない
+	if len(data) > fileCacheMaxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.remove(id)
+	c.seq++
+	c.files[id] = cachedFile{data: data, expiresAt: c.now().Add(fileCacheTTL), seq: c.seq}
+	c.order = append(c.order, fileCacheKey{id: id, seq: c.seq})
+	c.size += len(data)
+
+	for c.size > fileCacheMaxBytes && len(c.order) > 0 {
+		oldest := c.order[0]
+		c.order = c.order[1:]
+		if file, ok := c.files[oldest.id]; ok && file.seq == oldest.seq {
+			c.remove(oldest.id)
+		}
+	}
+	// 削除済みのキーが溜まり続けないよう、順番の一覧を詰め直す
+	if len(c.order) > 2*len(c.files)+16 {
+		order := make([]fileCacheKey, 0, len(c.files))
+		for _, key := range c.order {
+			if file, ok := c.files[key.id]; ok && file.seq == key.seq {
+				order = append(order, key)
+			}
+		}
+		c.order = order
+	}
+}
+
+// remove ロックを取得済みの状態で呼ぶ
+func (c *fileCache) remove(id string) {
+	if file, ok := c.files[id]; ok {
+		c.size -= len(file.data)
+		delete(c.files, id)
+	}
+}
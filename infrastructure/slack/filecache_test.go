@@ -0,0 +1,57 @@
+package slack
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestFileCache(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := newFileCache()
+	c.now = func() time.Time { return now }
+
+	c.put("F1", []byte("one"))
+	if data, ok := c.get("F1"); !ok || !bytes.Equal(data, []byte("one")) {
+		t.Fatalf("get(F1) = %q, %v", data, ok)
+	}
+	if _, ok := c.get("F2"); ok {
+		t.Error("get(F2) ok = true, want false")
+	}
+
+	now = now.Add(fileCacheTTL)
+	if _, ok := c.get("F1"); ok {
+		t.Error("get(F1) after TTL ok = true, want false")
+	}
+	if c.size != 0 {
+		t.Errorf("size = %d, want 0", c.size)
+	}
+}
+
+func TestFileCacheEvictsOldest(t *testing.T) {
+	c := newFileCache()
+	half := make([]byte, fileCacheMaxBytes/2)
+
+	c.put("F1", half)
+	c.put("F2", half)
+	// F1 を入れ直すと F2 より新しくなる
+	c.put("F1", half)
+	c.put("F3", half)
+
+	if _, ok := c.get("F2"); ok {
+		t.Error("get(F2) ok = true, want evicted")
+	}
+	for _, id := range []string{"F1", "F3"} {
+		if _, ok := c.get(id); !ok {
+			t.Errorf("get(%s) ok = false, want cached", id)
+		}
+	}
+	if c.size != fileCacheMaxBytes {
+		t.Errorf("size = %d, want %d", c.size, fileCacheMaxBytes)
+	}
+
+	c.put("F4", make([]byte, fileCacheMaxBytes+1))
+	if _, ok := c.get("F4"); ok {
+		t.Error("get(F4) ok = true, want files larger than the cache to be skipped")
+	}
+}
@@ -1,21 +1,62 @@
 package slack
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"sync"
+	"time"
 
+	"github.com/gs1068/slack-gpt-bot/domain/model"
 	"github.com/gs1068/slack-gpt-bot/domain/repository"
 	"github.com/slack-go/slack"
 )
 
 var SlackBotUserID string
 
+// SlackBotID このBotのBotのID。このBotが投稿したメッセージの判定に使う
+var SlackBotID string
+
+const (
+	// channelCacheTTL チャンネル情報をキャッシュする時間
+	channelCacheTTL = time.Hour
+	// userCacheTTL ユーザー情報をキャッシュする時間。表示名の変更はこの時間だけ遅れて反映される
+	userCacheTTL = time.Hour
+	// userErrorCacheTTL ユーザー情報を取得できなかったことをキャッシュする時間
+	// 削除されたユーザーなどを返信のたびに問い合わせて、レート制限に掛からないようにする
+	userErrorCacheTTL = 5 * time.Minute
+)
+
+// errFileTooLarge ダウンロードしたファイルが上限のサイズを超えた
+var errFileTooLarge = errors.New("file exceeds the size limit")
+
 type slackRepository struct {
 	slackClient *slack.Client
+
+	mu           sync.Mutex
+	channelCache map[string]cachedChannel
+	userCache    map[string]cachedUser
+	files        *fileCache
+}
+
+type cachedChannel struct {
+	info      model.ChannelInfo
+	expiresAt time.Time
+}
+
+type cachedUser struct {
+	user      model.SlackUser
+	err       error // 取得できなかった場合のエラー
+	expiresAt time.Time
 }
 
 func NewSlackRepository(slackClient *slack.Client) repository.SlackRepository {
 	return &slackRepository{
-		slackClient: slackClient,
+		slackClient:  slackClient,
+		channelCache: make(map[string]cachedChannel),
+		userCache:    make(map[string]cachedUser),
+		files:        newFileCache(),
 	}
 }
 
@@ -51,13 +92,88 @@ func (r *slackRepository) LoadConversationReplies(channelId string, timeStamp st
 	return messages, nil
 }
 
-func (r *slackRepository) GetBotUserId() (string, error) {
+func (r *slackRepository) GetBotIdentity() (string, string, error) {
 	authTestResponse, err := r.slackClient.AuthTest()
 	if err != nil {
-		return "", fmt.Errorf("failed r.slackClient.AuthTest: %w", err)
+		return "", "", fmt.Errorf("failed r.slackClient.AuthTest: %w", err)
+	}
+
+	return authTestResponse.UserID, authTestResponse.BotID, nil
+}
+
+func (r *slackRepository) GetChannelInfo(channelId string) (model.ChannelInfo, error) {
+	r.mu.Lock()
+	cached, ok := r.channelCache[channelId]
+	r.mu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.info, nil
+	}
+
+	channel, err := r.slackClient.GetConversationInfo(&slack.GetConversationInfoInput{
+		ChannelID: channelId,
+	})
+	if err != nil {
+		return model.ChannelInfo{}, fmt.Errorf("failed r.slackClient.GetConversationInfo: %w", err)
+	}
+
+	info := model.ChannelInfo{
+		ID:      channelId,
+		Name:    channel.Name,
+		IsIM:    channel.IsIM,
+		Creator: channel.Creator,
+	}
+	r.mu.Lock()
+	r.channelCache[channelId] = cachedChannel{
+		info:      info,
+		expiresAt: time.Now().Add(channelCacheTTL),
+	}
+	r.mu.Unlock()
+
+	return info, nil
+}
+
+func (r *slackRepository) GetUserInfo(userID string) (model.SlackUser, error) {
+	r.mu.Lock()
+	cached, ok := r.userCache[userID]
+	r.mu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.user, cached.err
+	}
+
+	info, err := r.slackClient.GetUserInfo(userID)
+	if err != nil {
+		err = fmt.Errorf("failed r.slackClient.GetUserInfo: %w", err)
+		r.mu.Lock()
+		r.userCache[userID] = cachedUser{
+			err:       err,
+			expiresAt: time.Now().Add(userErrorCacheTTL),
+		}
+		r.mu.Unlock()
+		return model.SlackUser{}, err
 	}
 
-	return authTestResponse.UserID, nil
+	// 表示名が未設定のユーザーは氏名、それもなければユーザー名を使う
+	name := info.Profile.DisplayName
+	if name == "" {
+		name = info.RealName
+	}
+	if name == "" {
+		name = info.Name
+	}
+	user := model.SlackUser{
+		ID:          userID,
+		DisplayName: name,
+		IsBot:       info.IsBot,
+		IsAdmin:     info.IsAdmin || info.IsOwner,
+	}
+	r.mu.Lock()
+	r.userCache[userID] = cachedUser{
+		user:      user,
+		expiresAt: time.Now().Add(userCacheTTL),
+	}
+	r.mu.Unlock()
+
+	return user, nil
 }
 
 func (r *slackRepository) CreateNewBotMessage(channelId string, timeStamp string, msg string) error {
@@ -72,3 +188,119 @@ func (r *slackRepository) CreateNewBotMessage(channelId string, timeStamp string
 
 	return nil
 }
+
+func (r *slackRepository) PostPlaceholderMessage(channelId string, timeStamp string, msg string) (*model.BotMessage, error) {
+	_, outputTS, err := r.slackClient.PostMessage(
+		channelId,
+		slack.MsgOptionText(msg, false),
+		slack.MsgOptionTS(timeStamp),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed r.slackClient.PostMessage: %w", err)
+	}
+
+	return &model.BotMessage{
+		Client:       r.slackClient,
+		ChannelID:    channelId,
+		OutputTS:     outputTS,
+		ControllerTS: timeStamp,
+	}, nil
+}
+
+func (r *slackRepository) UpdateBotMessage(botMessage *model.BotMessage, msg string) error {
+	_, _, _, err := botMessage.Client.UpdateMessage(
+		botMessage.ChannelID,
+		botMessage.OutputTS,
+		slack.MsgOptionText(msg, false),
+	)
+	if err != nil {
+		return fmt.Errorf("failed botMessage.Client.UpdateMessage: %w", err)
+	}
+
+	return nil
+}
+
+func (r *slackRepository) PostBotReply(channelId string, timeStamp string, reply model.BotReply) error {
+	_, _, err := r.slackClient.PostMessage(
+		channelId,
+		botReplyOption(reply),
+		slack.MsgOptionTS(timeStamp),
+	)
+	if err != nil {
+		return fmt.Errorf("failed r.slackClient.PostMessage: %w", err)
+	}
+
+	return nil
+}
+
+func (r *slackRepository) UpdateBotReply(botMessage *model.BotMessage, reply model.BotReply) error {
+	_, _, _, err := botMessage.Client.UpdateMessage(
+		botMessage.ChannelID,
+		botMessage.OutputTS,
+		botReplyOption(reply),
+	)
+	if err != nil {
+		return fmt.Errorf("failed botMessage.Client.UpdateMessage: %w", err)
+	}
+
+	return nil
+}
+
+// botReplyOption Block Kitで投稿する場合も、通知やブロックを表示できないクライアント向けにテキストを添える
+func botReplyOption(reply model.BotReply) slack.MsgOption {
+	if len(reply.Blocks) == 0 {
+		return slack.MsgOptionText(reply.Text, false)
+	}
+	return slack.MsgOptionCompose(
+		slack.MsgOptionText(reply.Text, false),
+		slack.MsgOptionBlocks(reply.Blocks...),
+	)
+}
+
+func (r *slackRepository) UploadFile(ctx context.Context, channelId string, timeStamp string, file model.SlackFile) error {
+	// files.getUploadURLExternal と files.completeUploadExternal によるアップロード（v2）
+	_, err := r.slackClient.UploadFileContext(ctx, slack.UploadFileParameters{
+		Reader:          bytes.NewReader(file.Content),
+		FileSize:        len(file.Content),
+		Filename:        file.Filename,
+		Title:           file.Title,
+		InitialComment:  file.Comment,
+		AltTxt:          file.AltText,
+		Channel:         channelId,
+		ThreadTimestamp: timeStamp,
+	})
+	if err != nil {
+		return fmt.Errorf("failed r.slackClient.UploadFileContext: %w", err)
+	}
+
+	return nil
+}
+
+func (r *slackRepository) DownloadFile(ctx context.Context, file model.SlackAttachment, maxBytes int) ([]byte, error) {
+	if data, ok := r.files.get(file.ID); ok && len(data) <= maxBytes {
+		return data, nil
+	}
+
+	w := &limitedBuffer{max: maxBytes}
+	if err := r.slackClient.GetFileContext(ctx, file.URL, w); err != nil {
+		return nil, fmt.Errorf("failed r.slackClient.GetFileContext: %w", err)
+	}
+	data := w.buf.Bytes()
+	if file.ID != "" {
+		r.files.put(file.ID, data)
+	}
+	return data, nil
+}
+
+// limitedBuffer 上限のサイズを超えて書き込もうとするとエラーを返す
+type limitedBuffer struct {
+	buf bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.buf.Len()+len(p) > b.max {
+		return 0, errFileTooLarge
+	}
+	return b.buf.Write(p)
+}
@@ -0,0 +1,221 @@
+package spreadsheet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/api/sheets/v4"
+)
+
+const (
+	auditSheetName = "Audit"
+	auditRange     = auditSheetName + "!A:M"
+	auditHeader    = auditSheetName + "!A1:M1"
+	// maxCellLength Google Sheetsの1セルに書き込める文字数の上限
+	maxCellLength = 50000
+
+	// auditBufferSize 書き込み待ちにできる行数。これを超えた分は記録しない
+	auditBufferSize = 1000
+	// auditBatchSize 1回の追記でまとめて書き込む行数の上限
+	auditBatchSize = 100
+	// auditFlushInterval 書き込み待ちの行を追記する間隔
+	auditFlushInterval = 5 * time.Second
+	// auditFlushTimeout 終了時に残りの行を追記するまで待つ時間
+	auditFlushTimeout = 10 * time.Second
+)
+
+var (
+	// errAuditBufferFull 書き込み待ちの行が上限に達した
+	errAuditBufferFull = errors.New("audit buffer is full")
+	// errAuditClosed Close した後に記録しようとした
+	errAuditClosed = errors.New("audit repository is closed")
+)
+
+var auditHeaderRow = []interface{}{
+	"time",
+	"kind",
+	"channel_id",
+	"thread_ts",
+	"user_id",
+	"model",
+	"prompt_tokens",
+	"completion_tokens",
+	"latency_ms",
+	"finish_reason",
+	"error",
+	"prompt",
+	"prompt_hash",
+}
+
+// AuditRepository 監査ログを Audit シートに追記する
+// GPTの応答を待たせないよう、記録した行はバッファに溜めてバックグラウンドでまとめて追記する
+type AuditRepository struct {
+	ssClient *sheets.Service
+
+	headerMu  sync.Mutex
+	hasHeader bool
+
+	rows      chan []interface{}
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+// NewAuditRepository 追記するゴルーチンを開始する。終了時は Close で残りの行を書き込む
+func NewAuditRepository(ssClient *sheets.Service) *AuditRepository {
+	r := &AuditRepository{
+		ssClient: ssClient,
+		rows:     make(chan []interface{}, auditBufferSize),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+// RecordCompletion 行をバッファに追加する。シートへの書き込みは待たない
+func (r *AuditRepository) RecordCompletion(ctx context.Context, record model.AuditRecord) error {
+	select {
+	case <-r.done:
+		return errAuditClosed
+	default:
+	}
+
+	select {
+	case r.rows <- auditRow(record):
+		return nil
+	default:
+		return errAuditBufferFull
+	}
+}
+
+// Close バッファに残っている行を書き込んでから終了する
+func (r *AuditRepository) Close() error {
+	r.closeOnce.Do(func() { close(r.done) })
+	<-r.stopped
+	return nil
+}
+
+// run 一定間隔、または auditBatchSize 行が溜まるたびにまとめて追記する
+func (r *AuditRepository) run() {
+	defer close(r.stopped)
+
+	ticker := time.NewTicker(auditFlushInterval)
+	defer ticker.Stop()
+
+	var batch [][]interface{}
+	for {
+		select {
+		case row := <-r.rows:
+			batch = append(batch, row)
+			if len(batch) >= auditBatchSize {
+				r.flush(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			r.flush(batch)
+			batch = nil
+		case <-r.done:
+			// Close までに追加された行をすべて書き込む
+			for {
+				select {
+				case row := <-r.rows:
+					batch = append(batch, row)
+				default:
+					r.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush 行をまとめて追記する。失敗した行は記録しない
+func (r *AuditRepository) flush(batch [][]interface{}) {
+	for len(batch) > 0 {
+		n := min(len(batch), auditBatchSize)
+		ctx, cancel := context.WithTimeout(context.Background(), auditFlushTimeout)
+		if err := r.appendRows(ctx, batch[:n]); err != nil {
+			log.Error().Err(err).Int("rows", n).Msg("failed to append audit rows")
+		}
+		cancel()
+		batch = batch[n:]
+	}
+}
+
+func (r *AuditRepository) appendRows(ctx context.Context, rows [][]interface{}) error {
+	if err := r.ensureHeader(ctx); err != nil {
+		return fmt.Errorf("failed r.ensureHeader: %w", err)
+	}
+
+	_, err := r.ssClient.Spreadsheets.Values.Append(SpreadsheetID, auditRange, &sheets.ValueRange{Values: rows}).
+		ValueInputOption("RAW").
+		InsertDataOption("INSERT_ROWS").
+		Context(ctx).
+		Do()
+	if err != nil {
+		return fmt.Errorf("failed r.ssClient.Spreadsheets.Values.Append: %w", err)
+	}
+	return nil
+}
+
+// auditRow 監査ログ1件分の行
+func auditRow(record model.AuditRecord) []interface{} {
+	return []interface{}{
+		record.Time.Format(time.RFC3339),
+		string(record.Kind),
+		record.ChannelID,
+		record.ThreadTS,
+		record.UserID,
+		record.Model,
+		strconv.Itoa(record.PromptTokens),
+		strconv.Itoa(record.CompletionTokens),
+		strconv.FormatInt(record.LatencyMS, 10),
+		record.FinishReason,
+		truncateCell(record.Error),
+		truncateCell(record.Prompt),
+		record.PromptHash,
+	}
+}
+
+// ensureHeader シートが空の場合はヘッダー行を書き込む
+func (r *AuditRepository) ensureHeader(ctx context.Context) error {
+	r.headerMu.Lock()
+	defer r.headerMu.Unlock()
+
+	if r.hasHeader {
+		return nil
+	}
+
+	resp, err := r.ssClient.Spreadsheets.Values.Get(SpreadsheetID, auditHeader).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed r.ssClient.Spreadsheets.Values.Get: %w", err)
+	}
+	if len(resp.Values) == 0 {
+		_, err := r.ssClient.Spreadsheets.Values.Update(SpreadsheetID, auditHeader, &sheets.ValueRange{Values: [][]interface{}{auditHeaderRow}}).
+			ValueInputOption("RAW").
+			Context(ctx).
+			Do()
+		if err != nil {
+			return fmt.Errorf("failed r.ssClient.Spreadsheets.Values.Update: %w", err)
+		}
+	}
+
+	r.hasHeader = true
+	return nil
+}
+
+// truncateCell セルの文字数の上限を超える部分を切り捨てる
+func truncateCell(s string) string {
+	runes := []rune(s)
+	if len(runes) <= maxCellLength {
+		return s
+	}
+	return string(runes[:maxCellLength])
+}
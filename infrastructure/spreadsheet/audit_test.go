@@ -0,0 +1,120 @@
+package spreadsheet
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
+)
+
+// fakeAuditSheet Audit シートへの追記だけを記録する偽のサーバー
+type fakeAuditSheet struct {
+	mu      sync.Mutex
+	header  []interface{}
+	appends [][][]interface{} // 追記のリクエストごとの行
+}
+
+func (f *fakeAuditSheet) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet:
+		resp := sheets.ValueRange{}
+		if f.header != nil {
+			resp.Values = [][]interface{}{f.header}
+		}
+		writeJSON(w, resp)
+	case r.Method == http.MethodPut:
+		var body sheets.ValueRange
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.header = body.Values[0]
+		writeJSON(w, sheets.UpdateValuesResponse{})
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, ":append"):
+		var body sheets.ValueRange
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.appends = append(f.appends, body.Values)
+		writeJSON(w, sheets.AppendValuesResponse{})
+	default:
+		http.Error(w, "unsupported", http.StatusNotImplemented)
+	}
+}
+
+func newTestAuditRepository(t *testing.T, fake *fakeAuditSheet) *AuditRepository {
+	t.Helper()
+	SpreadsheetID = "test-sheet"
+
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	client, err := sheets.NewService(context.Background(),
+		option.WithEndpoint(server.URL+"/"),
+		option.WithHTTPClient(server.Client()),
+	)
+	if err != nil {
+		t.Fatalf("sheets.NewService() error = %v", err)
+	}
+	return NewAuditRepository(client)
+}
+
+func TestAuditRepositoryBatchesRows(t *testing.T) {
+	fake := &fakeAuditSheet{}
+	repo := newTestAuditRepository(t, fake)
+
+	const records = auditBatchSize + 5
+	for i := 0; i < records; i++ {
+		record := model.AuditRecord{Time: time.Now(), Kind: model.AuditKindReply, ChannelID: "C1"}
+		if err := repo.RecordCompletion(context.Background(), record); err != nil {
+			t.Fatalf("RecordCompletion() error = %v", err)
+		}
+	}
+	if err := repo.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.header) != len(auditHeaderRow) {
+		t.Errorf("header = %v, want %v", fake.header, auditHeaderRow)
+	}
+	var total int
+	for _, rows := range fake.appends {
+		if len(rows) > auditBatchSize {
+			t.Errorf("appended %d rows at once, want at most %d", len(rows), auditBatchSize)
+		}
+		total += len(rows)
+	}
+	if total != records {
+		t.Errorf("appended %d rows, want %d", total, records)
+	}
+	if len(fake.appends) >= records {
+		t.Errorf("append requests = %d, want rows to be batched", len(fake.appends))
+	}
+}
+
+func TestAuditRepositoryAfterClose(t *testing.T) {
+	repo := newTestAuditRepository(t, &fakeAuditSheet{})
+	if err := repo.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := repo.Close(); err != nil {
+		t.Fatalf("second Close() error = %v", err)
+	}
+	if err := repo.RecordCompletion(context.Background(), model.AuditRecord{}); err != errAuditClosed {
+		t.Errorf("RecordCompletion() error = %v, want %v", err, errAuditClosed)
+	}
+}
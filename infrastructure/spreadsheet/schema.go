@@ -0,0 +1,190 @@
+package spreadsheet
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+)
+
+// シートの列構成
+//
+//	v1: ヘッダー行がなく、ユーザーID・使用回数・最終使用日時・累計トークン数・本日のトークン数の5列
+//	v2: 1行目がヘッダー行。列の位置はヘッダーの名前で判定する
+//	v3: v2に画像の生成枚数の列を追加
+const schemaVersion = 3
+
+const (
+	columnUserID                = "user_id"
+	columnTotalUsage            = "total_usage"
+	columnLastUsedAt            = "last_used_at"
+	columnTokensUsage           = "tokens_usage"
+	columnDailyTokensUsage      = "daily_tokens_usage"
+	columnTotalTokensUsage      = "total_tokens_usage"
+	columnPromptTokensUsage     = "prompt_tokens_usage"
+	columnCompletionTokensUsage = "completion_tokens_usage"
+	columnModel                 = "model"
+	columnDailyCostUSD          = "daily_cost_usd"
+	columnTotalCostUSD          = "total_cost_usd"
+	columnDailyImageUsage       = "daily_image_usage"
+	columnTotalImageUsage       = "total_image_usage"
+)
+
+// headerRow 最新のヘッダー行
+var headerRow = []interface{}{
+	columnUserID,
+	columnTotalUsage,
+	columnLastUsedAt,
+	columnTokensUsage,
+	columnDailyTokensUsage,
+	columnTotalTokensUsage,
+	columnPromptTokensUsage,
+	columnCompletionTokensUsage,
+	columnModel,
+	columnDailyCostUSD,
+	columnTotalCostUSD,
+	columnDailyImageUsage,
+	columnTotalImageUsage,
+}
+
+// sheetSchema ヘッダー行から読み取った列の位置
+type sheetSchema struct {
+	version int
+	columns map[string]int // 列名 -> 位置
+	width   int
+}
+
+// detectSchema 1行目がヘッダー行かどうかで列構成を判定する
+// ヘッダー行に最新の列がそろっていない場合は v2 とみなす
+func detectSchema(values [][]interface{}) *sheetSchema {
+	if len(values) == 0 || len(values[0]) == 0 || fmt.Sprint(values[0][0]) != columnUserID {
+		return &sheetSchema{version: 1}
+	}
+
+	schema := &sheetSchema{
+		version: schemaVersion,
+		columns: make(map[string]int, len(values[0])),
+		width:   len(values[0]),
+	}
+	for i, name := range values[0] {
+		schema.columns[fmt.Sprint(name)] = i
+	}
+	if len(schema.missingColumns()) > 0 {
+		schema.version = 2
+	}
+	return schema
+}
+
+// missingColumns ヘッダー行にない最新の列
+func (s *sheetSchema) missingColumns() []interface{} {
+	var missing []interface{}
+	for _, name := range headerRow {
+		if _, ok := s.columns[fmt.Sprint(name)]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// header 列の位置の順に並べたヘッダー行
+func (s *sheetSchema) header() []interface{} {
+	row := make([]interface{}, s.width)
+	for name, i := range s.columns {
+		row[i] = name
+	}
+	return row
+}
+
+// parseRow 1行を使用量に変換する。ヘッダー行やユーザーIDのない行は無視する
+func (s *sheetSchema) parseRow(row []interface{}) (model.SpreadsheetData, bool) {
+	if s.version == 1 {
+		return parseLegacyRow(row)
+	}
+
+	cell := func(name string) string {
+		i, ok := s.columns[name]
+		if !ok || i >= len(row) {
+			return ""
+		}
+		return fmt.Sprint(row[i])
+	}
+
+	userID := cell(columnUserID)
+	if userID == "" || userID == columnUserID {
+		return model.SpreadsheetData{}, false
+	}
+	return model.SpreadsheetData{
+		UserID:                userID,
+		TotalUsage:            atoi(cell(columnTotalUsage)),
+		LastUsedAt:            cell(columnLastUsedAt),
+		TokensUsage:           atoi(cell(columnTokensUsage)),
+		DailyTokensUsage:      atoi(cell(columnDailyTokensUsage)),
+		TotalTokensUsage:      atoi(cell(columnTotalTokensUsage)),
+		PromptTokensUsage:     atoi(cell(columnPromptTokensUsage)),
+		CompletionTokensUsage: atoi(cell(columnCompletionTokensUsage)),
+		Model:                 cell(columnModel),
+		DailyCostUSD:          atof(cell(columnDailyCostUSD)),
+		TotalCostUSD:          atof(cell(columnTotalCostUSD)),
+		DailyImageUsage:       atoi(cell(columnDailyImageUsage)),
+		TotalImageUsage:       atoi(cell(columnTotalImageUsage)),
+	}, true
+}
+
+// formatRow 使用量をヘッダー行の列の並びに合わせた1行に変換する
+func (s *sheetSchema) formatRow(data model.SpreadsheetData) []interface{} {
+	values := map[string]string{
+		columnUserID:                data.UserID,
+		columnTotalUsage:            strconv.Itoa(data.TotalUsage),
+		columnLastUsedAt:            data.LastUsedAt,
+		columnTokensUsage:           strconv.Itoa(data.TokensUsage),
+		columnDailyTokensUsage:      strconv.Itoa(data.DailyTokensUsage),
+		columnTotalTokensUsage:      strconv.Itoa(data.TotalTokensUsage),
+		columnPromptTokensUsage:     strconv.Itoa(data.PromptTokensUsage),
+		columnCompletionTokensUsage: strconv.Itoa(data.CompletionTokensUsage),
+		columnModel:                 data.Model,
+		columnDailyCostUSD:          strconv.FormatFloat(data.DailyCostUSD, 'f', 6, 64),
+		columnTotalCostUSD:          strconv.FormatFloat(data.TotalCostUSD, 'f', 6, 64),
+		columnDailyImageUsage:       strconv.Itoa(data.DailyImageUsage),
+		columnTotalImageUsage:       strconv.Itoa(data.TotalImageUsage),
+	}
+
+	row := make([]interface{}, s.width)
+	for name, i := range s.columns {
+		row[i] = values[name]
+	}
+	return row
+}
+
+// parseLegacyRow v1の行を変換する
+// v1では4列目に累計のトークン数を書き込んでいたため、直近のトークン数ではなく累計として読む
+func parseLegacyRow(row []interface{}) (model.SpreadsheetData, bool) {
+	if len(row) < 5 {
+		return model.SpreadsheetData{}, false
+	}
+	userID := fmt.Sprint(row[0])
+	if userID == "" {
+		return model.SpreadsheetData{}, false
+	}
+	return model.SpreadsheetData{
+		UserID:           userID,
+		TotalUsage:       atoi(fmt.Sprint(row[1])),
+		LastUsedAt:       fmt.Sprint(row[2]),
+		DailyTokensUsage: atoi(fmt.Sprint(row[4])),
+		TotalTokensUsage: atoi(fmt.Sprint(row[3])),
+	}, true
+}
+
+// newSchema ヘッダー行どおりの列構成
+func newSchema() *sheetSchema {
+	return detectSchema([][]interface{}{headerRow})
+}
+
+func atoi(s string) int {
+	i, _ := strconv.Atoi(s)
+	return i
+}
+
+func atof(s string) float64 {
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
@@ -2,30 +2,54 @@ package spreadsheet
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"os"
-	"sort"
+	"reflect"
+	"regexp"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gs1068/slack-gpt-bot/domain/model"
 	"github.com/gs1068/slack-gpt-bot/domain/repository"
+	"github.com/rs/zerolog/log"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/option"
 	"google.golang.org/api/sheets/v4"
 )
 
 const (
-	dataRange = "Activity!A:E"
+	sheetName  = "Activity"
+	dataRange  = sheetName + "!A:M"
+	lastColumn = "M"
+
+	// maxUpdateAttempts 他のプロセスと書き込みが競合した場合に試行する回数
+	maxUpdateAttempts = 5
+	// retryInterval 競合した場合に再試行するまでの間隔（試行回数に比例して延ばす）
+	retryInterval = 100 * time.Millisecond
+	// lockStripes ユーザーごとのロックに使うロックの数。ユーザーが増えてもロックは増やさない
+	lockStripes = 64
 )
 
+// errConflict 読み込んでから書き込むまでの間に他のプロセスが同じユーザーの行を更新した
+var errConflict = errors.New("usage row was modified concurrently")
+
+// rangeStartRow "Activity!A3:E10" のような範囲の開始行
+var rangeStartRow = regexp.MustCompile(`![A-Z]+(\d+)`)
+
 var SpreadsheetID string
 
 type SpreadsheetRepository struct {
 	ssClient *sheets.Service
+	locks    [lockStripes]sync.Mutex // ユーザーIDのハッシュで選ぶ
+
+	schemaMu sync.Mutex
+	schema   *sheetSchema
 }
 
-func NewSpreadsheetRepository(ssClient *sheets.Service) repository.SpreadsheetRepository {
+func NewSpreadsheetRepository(ssClient *sheets.Service) repository.UsageRepository {
 	return &SpreadsheetRepository{
 		ssClient: ssClient,
 	}
@@ -48,98 +72,242 @@ func SpreadSheetClient() (*sheets.Service, error) {
 	return sheets.NewService(ctx, option.WithHTTPClient(client))
 }
 
-func (r *SpreadsheetRepository) GetSpreadsheetDataBySlackID(ctx context.Context, userID string) (*model.SpreadsheetData, error) {
-	values, err := r.readSpreadsheet(ctx, dataRange)
+func (r *SpreadsheetRepository) GetUsage(ctx context.Context, userID string) (*model.SpreadsheetData, error) {
+	schema, err := r.loadSchema(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed r.loadSchema: %w", err)
+	}
+	values, _, err := r.readSpreadsheet(ctx, dataRange)
 	if err != nil {
 		return nil, fmt.Errorf("failed r.readSpreadsheet: %w", err)
 	}
 
-	for _, row := range values {
-		if len(row) < 5 {
-			continue
-		}
-
-		if row[0].(string) == userID {
-			totalUsage, _ := strconv.Atoi(row[1].(string))
-			tokensUsage, _ := strconv.Atoi(row[3].(string))
-			dailyTokensUsage, _ := strconv.Atoi(row[4].(string))
-			return &model.SpreadsheetData{
-				UserID:           userID,
-				TotalUsage:       totalUsage,
-				LastUsedAt:       row[2].(string),
-				TokensUsage:      tokensUsage,
-				DailyTokensUsage: dailyTokensUsage,
-				TotalTokensUsage: tokensUsage,
-			}, nil
-		}
+	if index := findUserRow(schema, values, userID); index >= 0 {
+		data, _ := schema.parseRow(values[index])
+		return &data, nil
 	}
-
 	return nil, nil
 }
 
-func (r *SpreadsheetRepository) UpdateSpreadsheet(ctx context.Context, update model.SpreadsheetData) error {
-	values, err := r.readSpreadsheet(ctx, dataRange)
+func (r *SpreadsheetRepository) ListUsage(ctx context.Context) ([]model.SpreadsheetData, error) {
+	values, _, err := r.readSpreadsheet(ctx, dataRange)
 	if err != nil {
-		return fmt.Errorf("failed r.readSpreadsheet: %w", err)
+		return nil, fmt.Errorf("failed r.readSpreadsheet: %w", err)
+	}
+
+	// 移行元として読むだけなので、v1のシートでも書き換えずに読む
+	schema := detectSchema(values)
+	usages := make([]model.SpreadsheetData, 0, len(values))
+	for _, row := range values {
+		if data, ok := schema.parseRow(row); ok {
+			usages = append(usages, data)
+		}
 	}
+	return usages, nil
+}
+
+func (r *SpreadsheetRepository) UpdateUsage(ctx context.Context, update model.SpreadsheetData) error {
+	_, err := r.modifyUsage(ctx, update.UserID, func(data *model.SpreadsheetData) {
+		*data = update
+	})
+	return err
+}
+
+func (r *SpreadsheetRepository) IncrementUsage(ctx context.Context, userID string, usage model.TokenUsage) (*model.SpreadsheetData, error) {
+	return r.modifyUsage(ctx, userID, func(data *model.SpreadsheetData) {
+		data.ResetDailyUsageIfNeeded()
+		data.AddUsage(usage)
+	})
+}
 
-	userData := r.mapSpreadsheetData(values)
-	now := time.Now().Format(time.RFC3339)
-	userData[update.UserID] = r.convertActivityData(update, now)
+// loadSchema シートの列構成を判定する。古い形式のシートは最新の列構成に移行する
+func (r *SpreadsheetRepository) loadSchema(ctx context.Context) (*sheetSchema, error) {
+	r.schemaMu.Lock()
+	defer r.schemaMu.Unlock()
 
-	updatedValues := r.mapToSortedSlice(userData)
+	if r.schema != nil {
+		return r.schema, nil
+	}
 
-	err = r.writeSpreadsheet(ctx, dataRange, updatedValues)
+	values, _, err := r.readSpreadsheet(ctx, dataRange)
 	if err != nil {
-		return fmt.Errorf("failed r.writeSpreadsheet: %w", err)
+		return nil, fmt.Errorf("failed r.readSpreadsheet: %w", err)
+	}
+	schema := detectSchema(values)
+	switch {
+	case schema.version == 1:
+		if err := r.migrateSchema(ctx, values); err != nil {
+			return nil, fmt.Errorf("failed r.migrateSchema: %w", err)
+		}
+		schema = newSchema()
+	case schema.version < schemaVersion:
+		if schema, err = r.addMissingColumns(ctx, schema); err != nil {
+			return nil, fmt.Errorf("failed r.addMissingColumns: %w", err)
+		}
 	}
 
-	return nil
+	r.schema = schema
+	return schema, nil
 }
 
-func (r *SpreadsheetRepository) mapSpreadsheetData(values [][]interface{}) map[string][]interface{} {
-	userData := make(map[string][]interface{}, len(values))
+// migrateSchema v1の行を最新の列構成に変換し、先頭にヘッダー行を追加してシート全体を書き換える
+// 変換できない行もそのまま残し、書き換え前より行数が減らないようにする
+func (r *SpreadsheetRepository) migrateSchema(ctx context.Context, values [][]interface{}) error {
+	schema := newSchema()
+	migrated := make([][]interface{}, 0, len(values)+1)
+	migrated = append(migrated, headerRow)
 	for _, row := range values {
-		if len(row) < 5 {
+		if data, ok := parseLegacyRow(row); ok {
+			migrated = append(migrated, schema.formatRow(data))
 			continue
 		}
+		migrated = append(migrated, row)
+	}
 
-		userID, ok := row[0].(string)
-		if !ok {
-			continue
+	if err := r.writeSpreadsheet(ctx, dataRange, migrated); err != nil {
+		return fmt.Errorf("failed r.writeSpreadsheet: %w", err)
+	}
+	log.Info().Int("rows", len(values)).Int("schema_version", schemaVersion).Msg("migrated usage sheet")
+	return nil
+}
+
+// addMissingColumns ヘッダー行の末尾に足りない列を追加する。既存の行の値は動かさない
+func (r *SpreadsheetRepository) addMissingColumns(ctx context.Context, schema *sheetSchema) (*sheetSchema, error) {
+	header := append(schema.header(), schema.missingColumns()...)
+	if err := r.writeSpreadsheet(ctx, rowRange(1), [][]interface{}{header}); err != nil {
+		return nil, fmt.Errorf("failed r.writeSpreadsheet: %w", err)
+	}
+	log.Info().Int("columns", len(header)).Int("schema_version", schemaVersion).Msg("added columns to usage sheet")
+	return detectSchema([][]interface{}{header}), nil
+}
+
+// modifyUsage ユーザーの行だけを読み込んで変更し、同じ行に書き戻す
+// 同じプロセス内ではユーザーごとにロックするため、同時の加算は失われない
+// スプレッドシートには条件付きの書き込みがないため、複数のプロセスから書き込む場合は書き込み直前の再読み込みで
+// 競合の多くを検知して再試行するが、確認から書き込みまでの間の他のプロセスの更新は失われることがある
+func (r *SpreadsheetRepository) modifyUsage(ctx context.Context, userID string, modify func(data *model.SpreadsheetData)) (*model.SpreadsheetData, error) {
+	mu := r.lockFor(userID)
+	mu.Lock()
+	defer mu.Unlock()
+
+	for attempt := 1; ; attempt++ {
+		data, err := r.tryModifyUsage(ctx, userID, modify)
+		if err == nil {
+			return data, nil
+		}
+		if !errors.Is(err, errConflict) || attempt >= maxUpdateAttempts {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(retryInterval * time.Duration(attempt)):
 		}
-		userData[userID] = row
 	}
-	return userData
 }
 
-func (r *SpreadsheetRepository) convertActivityData(update model.SpreadsheetData, now string) []interface{} {
-	return []interface{}{
-		update.UserID,
-		fmt.Sprintf("%d", update.TotalUsage),
-		now,
-		fmt.Sprintf("%d", update.TotalTokensUsage),
-		fmt.Sprintf("%d", update.DailyTokensUsage),
+// lockFor ユーザーのロック。別のユーザーが同じロックを共有することもあるが、待つだけで正しさには影響しない
+func (r *SpreadsheetRepository) lockFor(userID string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(userID))
+	return &r.locks[h.Sum32()%lockStripes]
+}
+
+func (r *SpreadsheetRepository) tryModifyUsage(ctx context.Context, userID string, modify func(data *model.SpreadsheetData)) (*model.SpreadsheetData, error) {
+	schema, err := r.loadSchema(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed r.loadSchema: %w", err)
+	}
+	values, startRow, err := r.readSpreadsheet(ctx, dataRange)
+	if err != nil {
+		return nil, fmt.Errorf("failed r.readSpreadsheet: %w", err)
 	}
+
+	index := findUserRow(schema, values, userID)
+	if index < 0 {
+		data := model.NewSpreadsheet(userID, 0, "", 0, 0, 0)
+		modify(data)
+		if err := r.appendUsage(ctx, schema, *data); err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+
+	row := values[index]
+	data, _ := schema.parseRow(row)
+	modify(&data)
+
+	rowNumber := startRow + index
+	// 読み込んでから他のプロセスが同じ行を更新していないか確認する（この確認の後の更新は検知できない）
+	current, _, err := r.readSpreadsheet(ctx, rowRange(rowNumber))
+	if err != nil {
+		return nil, fmt.Errorf("failed r.readSpreadsheet: %w", err)
+	}
+	if len(current) != 1 || !reflect.DeepEqual(current[0], row) {
+		return nil, errConflict
+	}
+
+	if err := r.writeSpreadsheet(ctx, rowRange(rowNumber), [][]interface{}{schema.formatRow(data)}); err != nil {
+		return nil, fmt.Errorf("failed r.writeSpreadsheet: %w", err)
+	}
+	return &data, nil
 }
 
-func (r *SpreadsheetRepository) mapToSortedSlice(userData map[string][]interface{}) [][]interface{} {
-	updatedValues := make([][]interface{}, 0, len(userData))
-	for _, row := range userData {
-		updatedValues = append(updatedValues, row)
+// appendUsage 新しいユーザーの行を末尾に追加する
+// 他のプロセスが同時に同じユーザーを追加した場合は、先に追加された行を残して自分の行を消し、再試行させる
+func (r *SpreadsheetRepository) appendUsage(ctx context.Context, schema *sheetSchema, data model.SpreadsheetData) error {
+	valueRange := &sheets.ValueRange{
+		Values: [][]interface{}{schema.formatRow(data)},
 	}
-	sort.Slice(updatedValues, func(i, j int) bool {
-		return updatedValues[i][0].(string) < updatedValues[j][0].(string)
-	})
-	return updatedValues
+	resp, err := r.ssClient.Spreadsheets.Values.Append(SpreadsheetID, dataRange, valueRange).
+		ValueInputOption("RAW").
+		InsertDataOption("INSERT_ROWS").
+		Context(ctx).
+		Do()
+	if err != nil {
+		return fmt.Errorf("failed r.ssClient.Spreadsheets.Values.Append: %w", err)
+	}
+	if resp.Updates == nil {
+		return nil
+	}
+	appendedRow := parseStartRow(resp.Updates.UpdatedRange)
+
+	values, startRow, err := r.readSpreadsheet(ctx, dataRange)
+	if err != nil {
+		return fmt.Errorf("failed r.readSpreadsheet: %w", err)
+	}
+	index := findUserRow(schema, values, data.UserID)
+	if index < 0 || startRow+index == appendedRow {
+		return nil
+	}
+
+	_, err = r.ssClient.Spreadsheets.Values.Clear(SpreadsheetID, rowRange(appendedRow), &sheets.ClearValuesRequest{}).
+		Context(ctx).
+		Do()
+	if err != nil {
+		return fmt.Errorf("failed r.ssClient.Spreadsheets.Values.Clear: %w", err)
+	}
+	return errConflict
 }
 
-func (r *SpreadsheetRepository) readSpreadsheet(ctx context.Context, readRange string) ([][]interface{}, error) {
+// findUserRow ユーザーの最初の行の位置を返す。ない場合は -1 を返す
+func findUserRow(schema *sheetSchema, values [][]interface{}, userID string) int {
+	for i, row := range values {
+		if data, ok := schema.parseRow(row); ok && data.UserID == userID {
+			return i
+		}
+	}
+	return -1
+}
+
+// readSpreadsheet 範囲の値と、その先頭の行番号を返す
+func (r *SpreadsheetRepository) readSpreadsheet(ctx context.Context, readRange string) ([][]interface{}, int, error) {
 	resp, err := r.ssClient.Spreadsheets.Values.Get(SpreadsheetID, readRange).Context(ctx).Do()
 	if err != nil {
-		return nil, fmt.Errorf("failed to r.ssClient.Spreadsheets.Values.Get: %w", err)
+		return nil, 0, fmt.Errorf("failed to r.ssClient.Spreadsheets.Values.Get: %w", err)
 	}
-	return resp.Values, nil
+	return resp.Values, parseStartRow(resp.Range), nil
 }
 
 func (r *SpreadsheetRepository) writeSpreadsheet(ctx context.Context, writeRange string, values [][]interface{}) error {
@@ -155,3 +323,21 @@ func (r *SpreadsheetRepository) writeSpreadsheet(ctx context.Context, writeRange
 	}
 	return nil
 }
+
+// rowRange 1行分の範囲（例: "Activity!A3:E3"）
+func rowRange(row int) string {
+	return fmt.Sprintf("%s!A%d:%s%d", sheetName, row, lastColumn, row)
+}
+
+// parseStartRow A1形式の範囲から開始行を返す。行番号がない場合（"Activity!A:E"）は1行目とみなす
+func parseStartRow(a1Range string) int {
+	m := rangeStartRow.FindStringSubmatch(a1Range)
+	if m == nil {
+		return 1
+	}
+	row, err := strconv.Atoi(m[1])
+	if err != nil {
+		return 1
+	}
+	return row
+}
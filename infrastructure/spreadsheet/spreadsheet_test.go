@@ -0,0 +1,320 @@
+package spreadsheet
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
+)
+
+// fakeSheets Sheets API の values.get / update / append / clear だけを実装した偽のサーバー
+type fakeSheets struct {
+	mu   sync.Mutex
+	rows [][]interface{} // rows[i] がシートの i+1 行目
+
+	// onGetRow 1行分の範囲を読み込んだときに呼ばれる（他のプロセスの書き込みを再現する）
+	onGetRow func(f *fakeSheets, row int)
+	// onAppend 行を追加する前に呼ばれる
+	onAppend func(f *fakeSheets)
+}
+
+func (f *fakeSheets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	prefix := "/v4/spreadsheets/" + SpreadsheetID + "/values/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		http.NotFound(w, r)
+		return
+	}
+	rng := strings.TrimPrefix(r.URL.Path, prefix)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet:
+		row, ok := singleRow(rng)
+		if !ok {
+			writeJSON(w, sheets.ValueRange{Range: fmt.Sprintf("%s!A1:%s%d", sheetName, lastColumn, len(f.rows)), Values: f.rows})
+			return
+		}
+		if f.onGetRow != nil {
+			f.onGetRow(f, row)
+		}
+		resp := sheets.ValueRange{Range: rng}
+		if row <= len(f.rows) && len(f.rows[row-1]) > 0 {
+			resp.Values = [][]interface{}{f.rows[row-1]}
+		}
+		writeJSON(w, resp)
+	case r.Method == http.MethodPut:
+		row, ok := singleRow(rng)
+		if !ok {
+			row = 1
+		}
+		var body sheets.ValueRange
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for i, values := range body.Values {
+			for len(f.rows) < row+i {
+				f.rows = append(f.rows, nil)
+			}
+			f.rows[row+i-1] = values
+		}
+		writeJSON(w, sheets.UpdateValuesResponse{UpdatedRange: rng})
+	case r.Method == http.MethodPost && strings.HasSuffix(rng, ":append"):
+		if f.onAppend != nil {
+			f.onAppend(f)
+		}
+		var body sheets.ValueRange
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.rows = append(f.rows, body.Values[0])
+		writeJSON(w, sheets.AppendValuesResponse{Updates: &sheets.UpdateValuesResponse{UpdatedRange: rowRange(len(f.rows))}})
+	case r.Method == http.MethodPost && strings.HasSuffix(rng, ":clear"):
+		row, _ := singleRow(strings.TrimSuffix(rng, ":clear"))
+		f.rows[row-1] = nil
+		writeJSON(w, sheets.ClearValuesResponse{ClearedRange: rng})
+	default:
+		http.Error(w, "unsupported", http.StatusNotImplemented)
+	}
+}
+
+// setRow 指定した行を直接書き換える（ロックを取得済みの状態で呼ぶ）
+func (f *fakeSheets) setRow(row int, values ...interface{}) {
+	f.rows[row-1] = values
+}
+
+func (f *fakeSheets) usersRows(userID string) [][]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var rows [][]interface{}
+	for _, row := range f.rows {
+		if len(row) > 0 && row[0] == userID {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+func singleRow(rng string) (int, bool) {
+	var row, end int
+	if _, err := fmt.Sscanf(strings.TrimPrefix(rng, sheetName+"!"), "A%d:"+lastColumn+"%d", &row, &end); err != nil || row != end {
+		return 0, false
+	}
+	return row, true
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func newTestRepository(t *testing.T, fake *fakeSheets) *SpreadsheetRepository {
+	t.Helper()
+	SpreadsheetID = "test-sheet"
+
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	client, err := sheets.NewService(context.Background(),
+		option.WithEndpoint(server.URL+"/"),
+		option.WithHTTPClient(server.Client()),
+	)
+	if err != nil {
+		t.Fatalf("sheets.NewService() error = %v", err)
+	}
+	return NewSpreadsheetRepository(client).(*SpreadsheetRepository)
+}
+
+// usageRow 最新の列構成の1行
+func usageRow(data model.SpreadsheetData) []interface{} {
+	return newSchema().formatRow(data)
+}
+
+// testUsage 10トークン（プロンプト6、応答4）のリクエスト
+var testUsage = model.TokenUsage{Model: "gpt-4o", PromptTokens: 6, CompletionTokens: 4}
+
+func TestIncrementUsageConcurrent(t *testing.T) {
+	today := time.Now().Format(time.RFC3339)
+	fake := &fakeSheets{
+		rows: [][]interface{}{
+			headerRow,
+			usageRow(model.SpreadsheetData{UserID: "U_OTHER", TotalUsage: 3, LastUsedAt: today, DailyTokensUsage: 300, TotalTokensUsage: 300}),
+			usageRow(model.SpreadsheetData{UserID: "U_EXISTING", TotalUsage: 1, LastUsedAt: today, DailyTokensUsage: 100, TotalTokensUsage: 100}),
+		},
+	}
+	repo := newTestRepository(t, fake)
+
+	const workers = 20
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		for _, userID := range []string{"U_EXISTING", "U_NEW"} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := repo.IncrementUsage(context.Background(), userID, testUsage); err != nil {
+					t.Errorf("IncrementUsage(%s) error = %v", userID, err)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	tests := []struct {
+		userID     string
+		wantUsage  int
+		wantTokens int
+		wantPrompt int
+	}{
+		{userID: "U_OTHER", wantUsage: 3, wantTokens: 300},
+		{userID: "U_EXISTING", wantUsage: 1 + workers, wantTokens: 100 + workers*10, wantPrompt: workers * 6},
+		{userID: "U_NEW", wantUsage: workers, wantTokens: workers * 10, wantPrompt: workers * 6},
+	}
+	for _, tt := range tests {
+		if rows := fake.usersRows(tt.userID); len(rows) != 1 {
+			t.Fatalf("rows for %s = %d, want 1", tt.userID, len(rows))
+		}
+		got, err := repo.GetUsage(context.Background(), tt.userID)
+		if err != nil {
+			t.Fatalf("GetUsage(%s) error = %v", tt.userID, err)
+		}
+		if got.TotalUsage != tt.wantUsage || got.TotalTokensUsage != tt.wantTokens || got.PromptTokensUsage != tt.wantPrompt {
+			t.Errorf("GetUsage(%s) = %+v, want usage %d, tokens %d, prompt %d", tt.userID, got, tt.wantUsage, tt.wantTokens, tt.wantPrompt)
+		}
+	}
+}
+
+func TestIncrementUsageRetriesOnConflict(t *testing.T) {
+	today := time.Now().Format(time.RFC3339)
+	var once sync.Once
+	fake := &fakeSheets{
+		rows: [][]interface{}{
+			headerRow,
+			usageRow(model.SpreadsheetData{UserID: "U1", TotalUsage: 1, LastUsedAt: today, DailyTokensUsage: 100, TotalTokensUsage: 100}),
+		},
+		// 最初の書き込み前の確認で、他のプロセスが先に加算した状態にする
+		onGetRow: func(f *fakeSheets, row int) {
+			once.Do(func() {
+				f.setRow(row, usageRow(model.SpreadsheetData{UserID: "U1", TotalUsage: 2, LastUsedAt: today, DailyTokensUsage: 150, TotalTokensUsage: 150})...)
+			})
+		},
+	}
+	repo := newTestRepository(t, fake)
+
+	got, err := repo.IncrementUsage(context.Background(), "U1", testUsage)
+	if err != nil {
+		t.Fatalf("IncrementUsage() error = %v", err)
+	}
+	if got.TotalUsage != 3 || got.TotalTokensUsage != 160 {
+		t.Errorf("IncrementUsage() = %+v, want usage 3, tokens 160", got)
+	}
+}
+
+func TestIncrementUsageResolvesDuplicateAppend(t *testing.T) {
+	today := time.Now().Format(time.RFC3339)
+	var once sync.Once
+	fake := &fakeSheets{
+		rows: [][]interface{}{headerRow},
+		// 他のプロセスが同時に同じ新規ユーザーを追加した状態にする
+		onAppend: func(f *fakeSheets) {
+			once.Do(func() {
+				f.rows = append(f.rows, usageRow(model.SpreadsheetData{UserID: "U1", TotalUsage: 1, LastUsedAt: today, DailyTokensUsage: 50, TotalTokensUsage: 50}))
+			})
+		},
+	}
+	repo := newTestRepository(t, fake)
+
+	got, err := repo.IncrementUsage(context.Background(), "U1", testUsage)
+	if err != nil {
+		t.Fatalf("IncrementUsage() error = %v", err)
+	}
+	if got.TotalUsage != 2 || got.TotalTokensUsage != 60 {
+		t.Errorf("IncrementUsage() = %+v, want usage 2, tokens 60", got)
+	}
+	if rows := fake.usersRows("U1"); len(rows) != 1 {
+		t.Errorf("rows for U1 = %v, want 1 row", rows)
+	}
+}
+
+func TestMigrateLegacySchema(t *testing.T) {
+	today := time.Now().Format(time.RFC3339)
+	fake := &fakeSheets{
+		// v1: ヘッダー行がなく、4列目に累計のトークン数が入っている
+		rows: [][]interface{}{
+			{"U1", "4", today, "900", "200"},
+			{"U2", "1", today, "50", "50"},
+		},
+	}
+	repo := newTestRepository(t, fake)
+
+	got, err := repo.IncrementUsage(context.Background(), "U1", testUsage)
+	if err != nil {
+		t.Fatalf("IncrementUsage() error = %v", err)
+	}
+	want := model.SpreadsheetData{
+		UserID:                "U1",
+		TotalUsage:            5,
+		LastUsedAt:            got.LastUsedAt,
+		TokensUsage:           10,
+		DailyTokensUsage:      210,
+		TotalTokensUsage:      910,
+		PromptTokensUsage:     6,
+		CompletionTokensUsage: 4,
+		Model:                 "gpt-4o",
+		DailyCostUSD:          testUsage.CostUSD(),
+		TotalCostUSD:          testUsage.CostUSD(),
+	}
+	if *got != want {
+		t.Errorf("IncrementUsage() = %+v, want %+v", *got, want)
+	}
+
+	if fmt.Sprint(fake.rows[0]) != fmt.Sprint(headerRow) {
+		t.Errorf("header row = %v, want %v", fake.rows[0], headerRow)
+	}
+	u2, err := repo.GetUsage(context.Background(), "U2")
+	if err != nil {
+		t.Fatalf("GetUsage() error = %v", err)
+	}
+	if u2.TotalTokensUsage != 50 || u2.DailyTokensUsage != 50 || u2.TotalUsage != 1 {
+		t.Errorf("GetUsage(U2) = %+v", u2)
+	}
+}
+
+func TestAddMissingColumns(t *testing.T) {
+	today := time.Now().Format(time.RFC3339)
+	fake := &fakeSheets{
+		// v2: 画像の生成枚数の列がない
+		rows: [][]interface{}{
+			headerRow[:11],
+			{"U1", "3", today, "10", "30", "300", "180", "120", "gpt-4o", "0.001", "0.010000"},
+		},
+	}
+	repo := newTestRepository(t, fake)
+
+	got, err := repo.IncrementUsage(context.Background(), "U1", model.TokenUsage{Model: "dall-e-2", Images: 1})
+	if err != nil {
+		t.Fatalf("IncrementUsage() error = %v", err)
+	}
+	if got.TotalUsage != 4 || got.DailyTokensUsage != 30 || got.DailyImageUsage != 1 || got.TotalImageUsage != 1 {
+		t.Errorf("IncrementUsage() = %+v", *got)
+	}
+
+	if fmt.Sprint(fake.rows[0]) != fmt.Sprint(headerRow) {
+		t.Errorf("header row = %v, want %v", fake.rows[0], headerRow)
+	}
+	if row := fake.rows[1]; len(row) != len(headerRow) || row[0] != "U1" || row[len(row)-1] != "1" {
+		t.Errorf("row = %v", row)
+	}
+}
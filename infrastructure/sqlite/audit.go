@@ -0,0 +1,38 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+	"github.com/gs1068/slack-gpt-bot/domain/repository"
+)
+
+type auditRepository struct {
+	db *sql.DB
+}
+
+// NewAuditRepository 監査ログをSQLiteに保存する。テーブルがない場合は作成する
+func NewAuditRepository(ctx context.Context, db *sql.DB) (repository.AuditRepository, error) {
+	if err := migrate(ctx, db); err != nil {
+		return nil, fmt.Errorf("failed migrate: %w", err)
+	}
+	return &auditRepository{
+		db: db,
+	}, nil
+}
+
+func (r *auditRepository) RecordCompletion(ctx context.Context, record model.AuditRecord) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO audit_log (time, kind, channel_id, thread_ts, user_id, model, prompt_tokens, completion_tokens,
+			latency_ms, finish_reason, error, prompt, prompt_hash)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		record.Time.Format(time.RFC3339Nano), string(record.Kind), record.ChannelID, record.ThreadTS, record.UserID, record.Model,
+		record.PromptTokens, record.CompletionTokens, record.LatencyMS, record.FinishReason, record.Error, record.Prompt, record.PromptHash)
+	if err != nil {
+		return fmt.Errorf("failed r.db.ExecContext: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package sqlite
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+)
+
+func TestAuditRepository(t *testing.T) {
+	ctx := context.Background()
+	db, err := Open(filepath.Join(t.TempDir(), "audit.db"))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer db.Close()
+
+	// 使用量と同じデータベースを共有できること
+	if _, err := NewUsageRepository(ctx, db); err != nil {
+		t.Fatalf("NewUsageRepository() error = %v", err)
+	}
+	repo, err := NewAuditRepository(ctx, db)
+	if err != nil {
+		t.Fatalf("NewAuditRepository() error = %v", err)
+	}
+
+	record := model.AuditRecord{
+		Time:             time.Now(),
+		Kind:             model.AuditKindReply,
+		ChannelID:        "C1",
+		ThreadTS:         "1700000000.000100",
+		UserID:           "U1",
+		Model:            "gpt-4o",
+		PromptTokens:     120,
+		CompletionTokens: 30,
+		LatencyMS:        850,
+		FinishReason:     "stop",
+		PromptHash:       "abc",
+	}
+	if err := repo.RecordCompletion(ctx, record); err != nil {
+		t.Fatalf("RecordCompletion() error = %v", err)
+	}
+
+	var userID, finishReason string
+	var promptTokens int
+	err = db.QueryRowContext(ctx, `SELECT user_id, prompt_tokens, finish_reason FROM audit_log`).Scan(&userID, &promptTokens, &finishReason)
+	if err != nil {
+		t.Fatalf("select audit_log error = %v", err)
+	}
+	if userID != "U1" || promptTokens != 120 || finishReason != "stop" {
+		t.Errorf("audit_log = %s, %d, %s", userID, promptTokens, finishReason)
+	}
+}
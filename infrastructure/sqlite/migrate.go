@@ -0,0 +1,88 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// migrations スキーマの変更を順に並べたもの。適用済みのバージョンは PRAGMA user_version に記録する
+// 既存の項目は変更せず、末尾に追加すること
+var migrations = []string{
+	// 1: 使用量
+	`CREATE TABLE IF NOT EXISTS usage (
+		user_id            TEXT PRIMARY KEY,
+		total_usage        INTEGER NOT NULL DEFAULT 0,
+		last_used_at       TEXT    NOT NULL DEFAULT '',
+		tokens_usage       INTEGER NOT NULL DEFAULT 0,
+		daily_tokens_usage INTEGER NOT NULL DEFAULT 0,
+		total_tokens_usage INTEGER NOT NULL DEFAULT 0
+	)`,
+	// 2: プロンプト・応答のトークン数、モデル、費用
+	`ALTER TABLE usage ADD COLUMN prompt_tokens_usage INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE usage ADD COLUMN completion_tokens_usage INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE usage ADD COLUMN model TEXT NOT NULL DEFAULT '';
+	ALTER TABLE usage ADD COLUMN daily_cost_usd REAL NOT NULL DEFAULT 0;
+	ALTER TABLE usage ADD COLUMN total_cost_usd REAL NOT NULL DEFAULT 0`,
+	// 3: 監査ログ
+	`CREATE TABLE IF NOT EXISTS audit_log (
+		id                INTEGER PRIMARY KEY AUTOINCREMENT,
+		time              TEXT    NOT NULL,
+		kind              TEXT    NOT NULL,
+		channel_id        TEXT    NOT NULL DEFAULT '',
+		thread_ts         TEXT    NOT NULL DEFAULT '',
+		user_id           TEXT    NOT NULL DEFAULT '',
+		model             TEXT    NOT NULL DEFAULT '',
+		prompt_tokens     INTEGER NOT NULL DEFAULT 0,
+		completion_tokens INTEGER NOT NULL DEFAULT 0,
+		latency_ms        INTEGER NOT NULL DEFAULT 0,
+		finish_reason     TEXT    NOT NULL DEFAULT '',
+		error             TEXT    NOT NULL DEFAULT '',
+		prompt            TEXT    NOT NULL DEFAULT '',
+		prompt_hash       TEXT    NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS audit_log_user_id ON audit_log (user_id, time)`,
+	// 4: 画像の生成枚数
+	`ALTER TABLE usage ADD COLUMN daily_image_usage INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE usage ADD COLUMN total_image_usage INTEGER NOT NULL DEFAULT 0`,
+	// 5: /gpt コマンドの設定
+	`CREATE TABLE IF NOT EXISTS preference (
+		scope   TEXT NOT NULL,
+		id      TEXT NOT NULL,
+		persona TEXT NOT NULL DEFAULT '',
+		model   TEXT NOT NULL DEFAULT '',
+		PRIMARY KEY (scope, id)
+	)`,
+}
+
+// migrate 未適用のスキーマの変更を適用する
+func migrate(ctx context.Context, db *sql.DB) error {
+	var version int
+	if err := db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("failed to read user_version: %w", err)
+	}
+
+	for i := version; i < len(migrations); i++ {
+		if err := applyMigration(ctx, db, i+1, migrations[i]); err != nil {
+			return fmt.Errorf("failed to apply migration %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+func applyMigration(ctx context.Context, db *sql.DB, version int, query string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed db.BeginTx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("failed tx.ExecContext: %w", err)
+	}
+	// PRAGMA ではプレースホルダーを使えない
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", version)); err != nil {
+		return fmt.Errorf("failed to update user_version: %w", err)
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,56 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+	"github.com/gs1068/slack-gpt-bot/domain/repository"
+)
+
+type preferenceRepository struct {
+	db *sql.DB
+}
+
+// NewPreferenceRepository /gpt コマンドの設定をSQLiteに保存する。テーブルがない場合は作成する
+func NewPreferenceRepository(ctx context.Context, db *sql.DB) (repository.PreferenceRepository, error) {
+	if err := migrate(ctx, db); err != nil {
+		return nil, fmt.Errorf("failed migrate: %w", err)
+	}
+	return &preferenceRepository{
+		db: db,
+	}, nil
+}
+
+func (r *preferenceRepository) GetPreference(ctx context.Context, scope model.PreferenceScope, id string) (*model.Preference, error) {
+	preference := model.Preference{Scope: scope, ID: id}
+	err := r.db.QueryRowContext(ctx, "SELECT persona, model FROM preference WHERE scope = ? AND id = ?", string(scope), id).
+		Scan(&preference.Persona, &preference.Model)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed r.db.QueryRowContext: %w", err)
+	}
+	return &preference, nil
+}
+
+func (r *preferenceRepository) SavePreference(ctx context.Context, preference model.Preference) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO preference (scope, id, persona, model) VALUES (?, ?, ?, ?)
+		ON CONFLICT (scope, id) DO UPDATE SET persona = excluded.persona, model = excluded.model`,
+		string(preference.Scope), preference.ID, preference.Persona, preference.Model)
+	if err != nil {
+		return fmt.Errorf("failed r.db.ExecContext: %w", err)
+	}
+	return nil
+}
+
+func (r *preferenceRepository) DeletePreference(ctx context.Context, scope model.PreferenceScope, id string) error {
+	if _, err := r.db.ExecContext(ctx, "DELETE FROM preference WHERE scope = ? AND id = ?", string(scope), id); err != nil {
+		return fmt.Errorf("failed r.db.ExecContext: %w", err)
+	}
+	return nil
+}
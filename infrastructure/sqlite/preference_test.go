@@ -0,0 +1,66 @@
+package sqlite
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+)
+
+func TestPreferenceRepository(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "preference.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	repo, err := NewPreferenceRepository(ctx, db)
+	if err != nil {
+		t.Fatalf("NewPreferenceRepository() error = %v", err)
+	}
+
+	if got, err := repo.GetPreference(ctx, model.PreferenceScopeUser, "U1"); err != nil || got != nil {
+		t.Fatalf("GetPreference() = %v, %v, want nil", got, err)
+	}
+
+	want := model.Preference{Scope: model.PreferenceScopeChannel, ID: "C1", Persona: "engineer", Model: "gpt-4o"}
+	if err := repo.SavePreference(ctx, want); err != nil {
+		t.Fatalf("SavePreference() error = %v", err)
+	}
+	want.Model = "gpt-4o-mini"
+	if err := repo.SavePreference(ctx, want); err != nil {
+		t.Fatalf("SavePreference() error = %v", err)
+	}
+	// 同じIDでもユーザーの設定とは区別する
+	if got, err := repo.GetPreference(ctx, model.PreferenceScopeUser, "C1"); err != nil || got != nil {
+		t.Fatalf("GetPreference() = %v, %v, want nil", got, err)
+	}
+
+	// 再起動後も設定が残る
+	db.Close()
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer db.Close()
+	repo, err = NewPreferenceRepository(ctx, db)
+	if err != nil {
+		t.Fatalf("NewPreferenceRepository() error = %v", err)
+	}
+	got, err := repo.GetPreference(ctx, model.PreferenceScopeChannel, "C1")
+	if err != nil {
+		t.Fatalf("GetPreference() error = %v", err)
+	}
+	if got == nil || !reflect.DeepEqual(*got, want) {
+		t.Errorf("GetPreference() = %v, want %v", got, want)
+	}
+
+	if err := repo.DeletePreference(ctx, model.PreferenceScopeChannel, "C1"); err != nil {
+		t.Fatalf("DeletePreference() error = %v", err)
+	}
+	if got, err := repo.GetPreference(ctx, model.PreferenceScopeChannel, "C1"); err != nil || got != nil {
+		t.Errorf("GetPreference() after delete = %v, %v, want nil", got, err)
+	}
+}
@@ -0,0 +1,30 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+
+	// cgoを使わないSQLiteドライバ
+	_ "modernc.org/sqlite"
+)
+
+// DefaultPath SQLITE_PATH が未設定の場合のデータベースファイル
+const DefaultPath = "./slack-gpt-bot.db"
+
+// Open SQLiteのデータベースを開く。ファイルがない場合は作成する
+func Open(path string) (*sql.DB, error) {
+	// 複数のゴルーチンから書き込んでもロック待ちでエラーにならないようにする
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", path)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed sql.Open: %w", err)
+	}
+	// SQLiteは書き込みを同時に1つしか扱えないため、接続を1つにまとめる
+	db.SetMaxOpenConns(1)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed db.Ping: %w", err)
+	}
+	return db, nil
+}
@@ -0,0 +1,150 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+	"github.com/gs1068/slack-gpt-bot/domain/repository"
+)
+
+const selectUsage = `
+	SELECT user_id, total_usage, last_used_at, tokens_usage, daily_tokens_usage, total_tokens_usage,
+		prompt_tokens_usage, completion_tokens_usage, model, daily_cost_usd, total_cost_usd,
+		daily_image_usage, total_image_usage
+	FROM usage`
+
+type usageRepository struct {
+	db *sql.DB
+}
+
+// NewUsageRepository 使用量をSQLiteに保存する。テーブルがない場合は作成する
+func NewUsageRepository(ctx context.Context, db *sql.DB) (repository.UsageRepository, error) {
+	if err := migrate(ctx, db); err != nil {
+		return nil, fmt.Errorf("failed migrate: %w", err)
+	}
+	return &usageRepository{
+		db: db,
+	}, nil
+}
+
+func (r *usageRepository) GetUsage(ctx context.Context, userID string) (*model.SpreadsheetData, error) {
+	usage, err := scanUsage(r.db.QueryRowContext(ctx, selectUsage+" WHERE user_id = ?", userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed scanUsage: %w", err)
+	}
+	return &usage, nil
+}
+
+func (r *usageRepository) UpdateUsage(ctx context.Context, update model.SpreadsheetData) error {
+	return upsertUsage(ctx, r.db, update)
+}
+
+func (r *usageRepository) IncrementUsage(ctx context.Context, userID string, usage model.TokenUsage) (*model.SpreadsheetData, error) {
+	// 読み込みから書き込みまでを1つのトランザクションで行い、同時の加算が失われないようにする
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed r.db.BeginTx: %w", err)
+	}
+	defer tx.Rollback()
+
+	data, err := scanUsage(tx.QueryRowContext(ctx, selectUsage+" WHERE user_id = ?", userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		data = *model.NewSpreadsheet(userID, 0, "", 0, 0, 0)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed scanUsage: %w", err)
+	}
+
+	data.ResetDailyUsageIfNeeded()
+	data.AddUsage(usage)
+	if err := upsertUsage(ctx, tx, data); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed tx.Commit: %w", err)
+	}
+	return &data, nil
+}
+
+func (r *usageRepository) ListUsage(ctx context.Context) ([]model.SpreadsheetData, error) {
+	rows, err := r.db.QueryContext(ctx, selectUsage+" ORDER BY user_id")
+	if err != nil {
+		return nil, fmt.Errorf("failed r.db.QueryContext: %w", err)
+	}
+	defer rows.Close()
+
+	var usages []model.SpreadsheetData
+	for rows.Next() {
+		usage, err := scanUsage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed scanUsage: %w", err)
+		}
+		usages = append(usages, usage)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed rows.Err: %w", err)
+	}
+	return usages, nil
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func upsertUsage(ctx context.Context, db execer, update model.SpreadsheetData) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO usage (user_id, total_usage, last_used_at, tokens_usage, daily_tokens_usage, total_tokens_usage,
+			prompt_tokens_usage, completion_tokens_usage, model, daily_cost_usd, total_cost_usd,
+			daily_image_usage, total_image_usage)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET
+			total_usage = excluded.total_usage,
+			last_used_at = excluded.last_used_at,
+			tokens_usage = excluded.tokens_usage,
+			daily_tokens_usage = excluded.daily_tokens_usage,
+			total_tokens_usage = excluded.total_tokens_usage,
+			prompt_tokens_usage = excluded.prompt_tokens_usage,
+			completion_tokens_usage = excluded.completion_tokens_usage,
+			model = excluded.model,
+			daily_cost_usd = excluded.daily_cost_usd,
+			total_cost_usd = excluded.total_cost_usd,
+			daily_image_usage = excluded.daily_image_usage,
+			total_image_usage = excluded.total_image_usage`,
+		update.UserID, update.TotalUsage, update.LastUsedAt, update.TokensUsage, update.DailyTokensUsage, update.TotalTokensUsage,
+		update.PromptTokensUsage, update.CompletionTokensUsage, update.Model, update.DailyCostUSD, update.TotalCostUSD,
+		update.DailyImageUsage, update.TotalImageUsage)
+	if err != nil {
+		return fmt.Errorf("failed db.ExecContext: %w", err)
+	}
+	return nil
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanUsage(s scanner) (model.SpreadsheetData, error) {
+	var usage model.SpreadsheetData
+	err := s.Scan(
+		&usage.UserID,
+		&usage.TotalUsage,
+		&usage.LastUsedAt,
+		&usage.TokensUsage,
+		&usage.DailyTokensUsage,
+		&usage.TotalTokensUsage,
+		&usage.PromptTokensUsage,
+		&usage.CompletionTokensUsage,
+		&usage.Model,
+		&usage.DailyCostUSD,
+		&usage.TotalCostUSD,
+		&usage.DailyImageUsage,
+		&usage.TotalImageUsage,
+	)
+	return usage, err
+}
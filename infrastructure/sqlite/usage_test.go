@@ -0,0 +1,88 @@
+package sqlite
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+)
+
+func TestUsageRepository(t *testing.T) {
+	ctx := context.Background()
+	db, err := Open(filepath.Join(t.TempDir(), "usage.db"))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer db.Close()
+
+	repo, err := NewUsageRepository(ctx, db)
+	if err != nil {
+		t.Fatalf("NewUsageRepository() error = %v", err)
+	}
+
+	got, err := repo.GetUsage(ctx, "U1")
+	if err != nil || got != nil {
+		t.Fatalf("GetUsage() for unknown user = %+v, %v, want nil", got, err)
+	}
+
+	first := model.SpreadsheetData{UserID: "U1", TotalUsage: 1, LastUsedAt: "2024-04-01T10:00:00+09:00", DailyTokensUsage: 100, TotalTokensUsage: 100}
+	second := model.SpreadsheetData{UserID: "U1", TotalUsage: 2, LastUsedAt: "2024-04-01T11:00:00+09:00", DailyTokensUsage: 250, TotalTokensUsage: 250}
+	other := model.SpreadsheetData{UserID: "U0", TotalUsage: 1, DailyTokensUsage: 10, TotalTokensUsage: 10}
+	for _, usage := range []model.SpreadsheetData{first, second, other} {
+		if err := repo.UpdateUsage(ctx, usage); err != nil {
+			t.Fatalf("UpdateUsage() error = %v", err)
+		}
+	}
+
+	got, err = repo.GetUsage(ctx, "U1")
+	if err != nil {
+		t.Fatalf("GetUsage() error = %v", err)
+	}
+	if !reflect.DeepEqual(*got, second) {
+		t.Errorf("GetUsage() = %+v, want %+v", *got, second)
+	}
+
+	list, err := repo.ListUsage(ctx)
+	if err != nil {
+		t.Fatalf("ListUsage() error = %v", err)
+	}
+	if want := []model.SpreadsheetData{other, second}; !reflect.DeepEqual(list, want) {
+		t.Errorf("ListUsage() = %+v, want %+v", list, want)
+	}
+}
+
+func TestUsageRepositoryMigratesLegacyTable(t *testing.T) {
+	ctx := context.Background()
+	db, err := Open(filepath.Join(t.TempDir(), "usage.db"))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer db.Close()
+
+	// バージョンを記録していなかった頃のテーブル
+	if _, err := db.ExecContext(ctx, migrations[0]); err != nil {
+		t.Fatalf("create legacy table error = %v", err)
+	}
+	if _, err := db.ExecContext(ctx, `INSERT INTO usage (user_id, total_usage, daily_tokens_usage, total_tokens_usage) VALUES ('U1', 2, 30, 300)`); err != nil {
+		t.Fatalf("insert legacy row error = %v", err)
+	}
+
+	repo, err := NewUsageRepository(ctx, db)
+	if err != nil {
+		t.Fatalf("NewUsageRepository() error = %v", err)
+	}
+	got, err := repo.IncrementUsage(ctx, "U1", model.TokenUsage{Model: "gpt-4o-mini", PromptTokens: 7, CompletionTokens: 3})
+	if err != nil {
+		t.Fatalf("IncrementUsage() error = %v", err)
+	}
+	if got.TotalUsage != 3 || got.TotalTokensUsage != 310 || got.PromptTokensUsage != 7 || got.Model != "gpt-4o-mini" || got.TotalCostUSD <= 0 {
+		t.Errorf("IncrementUsage() = %+v", got)
+	}
+
+	// 2回目以降は適用済みのスキーマの変更を繰り返さない
+	if _, err := NewUsageRepository(ctx, db); err != nil {
+		t.Fatalf("NewUsageRepository() again error = %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package tokenizer
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+	"github.com/gs1068/slack-gpt-bot/domain/repository"
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+// defaultEncoding 対応するエンコーディングが不明なモデル（OpenAI互換のローカルモデルなど）の概算に使う
+const defaultEncoding = tiktoken.MODEL_O200K_BASE
+
+type tokenizerRepository struct {
+	mu        sync.Mutex
+	encodings map[string]*tiktoken.Tiktoken // エンコーディング名 -> トークナイザー
+}
+
+func NewTokenizerRepository() repository.TokenizerRepository {
+	// BPEの辞書はバイナリに埋め込まれたものを使い、ネットワークからは取得しない
+	tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+	return &tokenizerRepository{
+		encodings: make(map[string]*tiktoken.Tiktoken),
+	}
+}
+
+func (r *tokenizerRepository) TokenCounter(modelName string) (model.TokenCounter, error) {
+	encodingName := encodingForModel(modelName)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if enc, ok := r.encodings[encodingName]; ok {
+		return &tokenCounter{enc: enc}, nil
+	}
+
+	enc, err := tiktoken.GetEncoding(encodingName)
+	if err != nil {
+		return nil, fmt.Errorf("failed tiktoken.GetEncoding %s: %w", encodingName, err)
+	}
+	r.encodings[encodingName] = enc
+	return &tokenCounter{enc: enc}, nil
+}
+
+func encodingForModel(modelName string) string {
+	if encodingName, ok := tiktoken.MODEL_TO_ENCODING[modelName]; ok {
+		return encodingName
+	}
+	for prefix, encodingName := range tiktoken.MODEL_PREFIX_TO_ENCODING {
+		if strings.HasPrefix(modelName, prefix) {
+			return encodingName
+		}
+	}
+	return defaultEncoding
+}
+
+type tokenCounter struct {
+	enc *tiktoken.Tiktoken
+}
+
+func (c *tokenCounter) CountTokens(text string) int {
+	return len(c.enc.EncodeOrdinary(text))
+}
@@ -0,0 +1,50 @@
+package tokenizer
+
+import "testing"
+
+func TestTokenCounter(t *testing.T) {
+	tests := []struct {
+		name      string
+		modelName string
+		text      string
+		want      int
+	}{
+		{
+			name:      "gpt-4o",
+			modelName: "gpt-4o",
+			text:      "hello world",
+			want:      2,
+		},
+		{
+			name:      "gpt-4",
+			modelName: "gpt-4",
+			text:      "hello world",
+			want:      2,
+		},
+		{
+			name:      "unknown model falls back to default encoding",
+			modelName: "llama3",
+			text:      "hello world",
+			want:      2,
+		},
+		{
+			name:      "empty text",
+			modelName: "gpt-4o",
+			text:      "",
+			want:      0,
+		},
+	}
+
+	r := NewTokenizerRepository()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter, err := r.TokenCounter(tt.modelName)
+			if err != nil {
+				t.Fatalf("TokenCounter() error = %v", err)
+			}
+			if got := counter.CountTokens(tt.text); got != tt.want {
+				t.Errorf("CountTokens() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package interfaces
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+	"github.com/gs1068/slack-gpt-bot/infrastructure/queue"
+	"github.com/gs1068/slack-gpt-bot/usecase"
+	"github.com/rs/zerolog/log"
+	"github.com/slack-go/slack"
+)
+
+const gptCommand = "/gpt"
+
+type CommandHandler struct {
+	commandUsecase *usecase.CommandUsecase
+	slackUsecase   *usecase.SlackUsecase
+	jobQueue       *queue.Queue
+}
+
+func NewCommandHandler(commandUsecase *usecase.CommandUsecase, slackUsecase *usecase.SlackUsecase, jobQueue *queue.Queue) CommandHandler {
+	return CommandHandler{
+		commandUsecase: commandUsecase,
+		slackUsecase:   slackUsecase,
+		jobQueue:       jobQueue,
+	}
+}
+
+// SlashCommandHandler /gpt コマンドを処理し、実行したユーザーにだけ見えるメッセージで応答する
+func (h *CommandHandler) SlashCommandHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s, err := slack.SlashCommandParse(r)
+	if err != nil {
+		httpError(w, "invalid slash command", http.StatusBadRequest, err)
+		return
+	}
+	if s.Command != gptCommand {
+		log.Warn().Str("command", s.Command).Msg("unsupported slash command")
+		respondEphemeral(w, model.CommandResponse{Text: "未対応のコマンドです。"})
+		return
+	}
+
+	command := model.ParseSlashCommand(s.UserID, s.ChannelID, s.Text)
+	if command.Name == model.CommandImage {
+		h.enqueueImage(w, command)
+		return
+	}
+
+	resp, err := h.commandUsecase.Execute(ctx, command)
+	if err != nil {
+		log.Error().Err(err).Str("command", command.Name).Msg("failed h.commandUsecase.Execute")
+		respondEphemeral(w, model.CommandResponse{Text: "コマンドの実行中にエラーが発生しました。時間をおいて再度お試しください。"})
+		return
+	}
+
+	respondEphemeral(w, resp)
+}
+
+// enqueueImage 画像の生成をキューに積む。画像の生成には時間がかかるため、応答を待たずに受け付けたことだけを返す
+func (h *CommandHandler) enqueueImage(w http.ResponseWriter, command model.SlashCommand) {
+	prompt := command.ImagePrompt()
+	if prompt == "" {
+		respondEphemeral(w, model.CommandResponse{Text: model.ImageUsageMessage})
+		return
+	}
+
+	err := h.jobQueue.Enqueue(queue.Job{
+		Name: "command_image",
+		Run: func(ctx context.Context) error {
+			if err := h.slackUsecase.GenerateImage(ctx, command.ChannelID, "", command.UserID, prompt); err != nil {
+				return fmt.Errorf("failed h.slackUsecase.GenerateImage for channel %s: %w", command.ChannelID, err)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed h.jobQueue.Enqueue")
+		respondEphemeral(w, model.CommandResponse{Text: model.BusyMessage})
+		return
+	}
+
+	respondEphemeral(w, model.CommandResponse{Text: model.ImageAcceptedMessage})
+}
+
+func respondEphemeral(w http.ResponseWriter, resp model.CommandResponse) {
+	msg := slack.Msg{
+		ResponseType: slack.ResponseTypeEphemeral,
+		Text:         resp.Text,
+	}
+	if len(resp.Blocks) > 0 {
+		msg.Blocks = slack.Blocks{BlockSet: resp.Blocks}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(msg)
+}
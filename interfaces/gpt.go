@@ -3,7 +3,6 @@ package interfaces
 import (
 	"context"
 	"encoding/json"
-	"io"
 	"net/http"
 
 	"github.com/rs/zerolog/log"
@@ -27,14 +26,14 @@ func (h *GptHandler) CreateCompletion(w http.ResponseWriter, r *http.Request) {
 
 	prompt := r.URL.Query().Get("prompt")
 	if prompt == "" {
-		log.Fatal().Msg("failed r.URL.Query().Get(\"prompt\")")
+		log.Error().Msg("failed r.URL.Query().Get(\"prompt\")")
 		http.Error(w, "failed r.URL.Query().Get(\"prompt\")", http.StatusBadRequest)
 		return
 	}
 
 	resp, err := h.gptUsecase.CreateCompletion(ctx, prompt)
 	if err != nil {
-		log.Fatal().Err(err).Msg("failed h.gptUsecase.CreateCompletion")
+		log.Error().Err(err).Msg("failed h.gptUsecase.CreateCompletion")
 		http.Error(w, "failed to create completion: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -55,16 +54,16 @@ func (h *GptHandler) CreateImage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	respImage, err := h.gptUsecase.CreateImage(ctx, prompt)
+	image, err := h.gptUsecase.CreateImage(ctx, prompt)
 	if err != nil {
 		log.Error().Err(err).Msg("failed to create image")
 		http.Error(w, "failed to create image: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Content-Type", image.ContentType)
 	w.WriteHeader(http.StatusOK)
-	_, err = io.Copy(w, respImage)
+	_, err = w.Write(image.Data)
 	if err != nil {
 		log.Error().Err(err).Msg("failed to write image data to response")
 		http.Error(w, "failed to send image data", http.StatusInternalServerError)
@@ -3,90 +3,205 @@ package interfaces
 import (
 	"context"
 	"encoding/json"
+	"expvar"
+	"fmt"
 	"io"
 	"net/http"
 
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+	"github.com/gs1068/slack-gpt-bot/infrastructure/queue"
 	"github.com/gs1068/slack-gpt-bot/usecase"
 	"github.com/rs/zerolog/log"
 	"github.com/slack-go/slack/slackevents"
 )
 
+// eventCounter 受信したSlackイベントを種類ごとに数える（/debug/vars で参照できる）
+var eventCounter = expvar.NewMap("slack_events")
+
 type SlackHandler struct {
 	slackUsecase *usecase.SlackUsecase
+	jobQueue     *queue.Queue
 }
 
-func NewSlackHandler(slackUsecase *usecase.SlackUsecase) SlackHandler {
+func NewSlackHandler(slackUsecase *usecase.SlackUsecase, jobQueue *queue.Queue) SlackHandler {
 	return SlackHandler{
 		slackUsecase: slackUsecase,
+		jobQueue:     jobQueue,
 	}
 }
 
 func (i *SlackHandler) EventHandler(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
 	// Slack APPはレスポンスが遅かったりするとリトライが行われる。
-	// GPT側でリクエストを重複して処理してしまうのを防ぐため、リトライの場合は無視する。
+	// 重複はevent_idなどで判定するため、リトライでも処理は続ける。
 	if retryNum := r.Header.Get("X-Slack-Retry-Num"); retryNum != "" {
-		log.Info().Msg("retry request")
-		w.WriteHeader(http.StatusOK)
-		return
+		log.Info().
+			Str("retry_num", retryNum).
+			Str("retry_reason", r.Header.Get("X-Slack-Retry-Reason")).
+			Msg("retry request")
 	}
 
 	bodyBytes, err := io.ReadAll(r.Body)
 	if err != nil {
-		log.Fatal().Msg("failed io.ReadAll(r.Body)")
 		httpError(w, "failed to read request body", http.StatusInternalServerError, err)
 		return
 	}
 
+	// 署名はミドルウェアで検証済みのため、ここではトークン検証を行わない
 	eventsAPIEvent, err := slackevents.ParseEvent(json.RawMessage(bodyBytes), slackevents.OptionNoVerifyToken())
 	if err != nil {
-		log.Fatal().Err(err).Msg("failed slackevents.ParseEvent")
-		httpError(w, "invalid event", http.StatusInternalServerError, err)
+		// 未対応の内側イベントもここに来る。再送されても結果は変わらないため200を返す
+		eventCounter.Add("parse_error", 1)
+		log.Error().Err(err).Msg("failed slackevents.ParseEvent")
+		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	switch event := eventsAPIEvent.InnerEvent.Data.(type) {
-	case *slackevents.AppMentionEvent:
-		handleAppMentionEvent(ctx, w, i.slackUsecase, event)
-	case *slackevents.MessageEvent:
-		handleMessageEvent(ctx, w, i.slackUsecase, event)
+	switch eventsAPIEvent.Type {
+	case slackevents.URLVerification:
+		handleURLVerification(w, eventsAPIEvent)
+	case slackevents.AppRateLimited:
+		handleAppRateLimited(w, eventsAPIEvent)
+	case slackevents.CallbackEvent:
+		i.handleCallbackEvent(w, eventsAPIEvent)
 	default:
-		log.Info().Msg("unsupported event")
-		w.WriteHeader(http.StatusNoContent)
+		// 未知の外側イベントでもSlackに再送されないよう200を返す
+		eventCounter.Add("unsupported:"+eventsAPIEvent.Type, 1)
+		log.Warn().Str("type", eventsAPIEvent.Type).Msg("unsupported outer event")
+		w.WriteHeader(http.StatusOK)
 	}
 }
 
-func handleAppMentionEvent(ctx context.Context, w http.ResponseWriter, usecase *usecase.SlackUsecase, event *slackevents.AppMentionEvent) {
-	ts := getThreadTimestamp(event.TimeStamp, event.ThreadTimeStamp)
+// handleURLVerification Request URL登録時のchallengeにそのまま応答する
+func handleURLVerification(w http.ResponseWriter, eventsAPIEvent slackevents.EventsAPIEvent) {
+	eventCounter.Add(slackevents.URLVerification, 1)
 
-	if err := usecase.ProcessMessages(ctx, event.Channel, ts); err != nil {
-		log.Fatal().Err(err).Msg("failed usecase.ProcessMessages")
-		httpError(w, "failed to process mention event", http.StatusInternalServerError, err)
+	event, ok := eventsAPIEvent.Data.(*slackevents.EventsAPIURLVerificationEvent)
+	if !ok {
+		httpError(w, "invalid url_verification event", http.StatusBadRequest, nil)
 		return
 	}
 
+	log.Info().Msg("url verification")
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.WriteString(w, event.Challenge)
+}
+
+// handleAppRateLimited イベント購読がレート制限された通知を記録する
+func handleAppRateLimited(w http.ResponseWriter, eventsAPIEvent slackevents.EventsAPIEvent) {
+	eventCounter.Add(slackevents.AppRateLimited, 1)
+
+	logger := log.Warn().Str("team_id", eventsAPIEvent.TeamID).Str("api_app_id", eventsAPIEvent.APIAppID)
+	if event, ok := eventsAPIEvent.Data.(*slackevents.EventsAPIAppRateLimited); ok {
+		logger = logger.Int("minute_rate_limited", event.MinuteRateLimited)
+	}
+	logger.Msg("app rate limited")
+
 	w.WriteHeader(http.StatusOK)
 }
 
-func handleMessageEvent(ctx context.Context, w http.ResponseWriter, usecase *usecase.SlackUsecase, event *slackevents.MessageEvent) {
+func (i *SlackHandler) handleCallbackEvent(w http.ResponseWriter, eventsAPIEvent slackevents.EventsAPIEvent) {
+	eventCounter.Add(slackevents.CallbackEvent+":"+eventsAPIEvent.InnerEvent.Type, 1)
+
+	var eventID string
+	if cbEvent, ok := eventsAPIEvent.Data.(*slackevents.EventsAPICallbackEvent); ok {
+		eventID = cbEvent.EventID
+	}
+
+	switch event := eventsAPIEvent.InnerEvent.Data.(type) {
+	case *slackevents.AppMentionEvent:
+		i.handleAppMentionEvent(w, eventID, event)
+	case *slackevents.MessageEvent:
+		i.handleMessageEvent(w, eventID, event)
+	default:
+		log.Info().Str("type", eventsAPIEvent.InnerEvent.Type).Msg("unsupported event")
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (i *SlackHandler) handleAppMentionEvent(w http.ResponseWriter, eventID string, event *slackevents.AppMentionEvent) {
+	slackEvent := model.SlackEvent{
+		EventID:   eventID,
+		ChannelID: event.Channel,
+		TimeStamp: event.TimeStamp,
+	}
+	ts := getThreadTimestamp(event.TimeStamp, event.ThreadTimeStamp)
+	i.enqueueEvent(w, "app_mention", slackEvent, i.processMessage(slackEvent, ts, event.User, event.Text))
+}
+
+func (i *SlackHandler) handleMessageEvent(w http.ResponseWriter, eventID string, event *slackevents.MessageEvent) {
 	if event.User == "" || event.BotID != "" {
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 
+	if event.ChannelType != "im" && event.ThreadTimeStamp == "" {
+		log.Info().Msg("unsupported message event")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	slackEvent := model.SlackEvent{
+		EventID:     eventID,
+		ClientMsgID: event.ClientMsgID,
+		ChannelID:   event.Channel,
+		TimeStamp:   event.TimeStamp,
+	}
 	ts := getThreadTimestamp(event.TimeStamp, event.ThreadTimeStamp)
+	i.enqueueEvent(w, "message", slackEvent, i.processMessage(slackEvent, ts, event.User, event.Text))
+}
 
-	if event.ChannelType == "im" || event.ThreadTimeStamp != "" {
-		if err := usecase.ProcessMessages(ctx, event.Channel, ts); err != nil {
-			log.Fatal().Err(err).Msg("failed usecase.ProcessMessages")
-			httpError(w, "failed to process message", http.StatusInternalServerError, err)
-			return
+// processMessage メッセージに応じた処理を返す。"draw <説明>" の場合は画像を生成し、それ以外はGPTで応答する
+func (i *SlackHandler) processMessage(event model.SlackEvent, ts string, userID string, text string) func(ctx context.Context) error {
+	if prompt, ok := model.ParseImageRequest(text); ok {
+		return func(ctx context.Context) error {
+			if err := i.slackUsecase.GenerateImage(ctx, event.ChannelID, ts, userID, prompt); err != nil {
+				return fmt.Errorf("failed i.slackUsecase.GenerateImage for channel %s, timestamp %s: %w", event.ChannelID, ts, err)
+			}
+			return nil
 		}
-	} else {
-		log.Info().Msg("unsupported message event")
-		w.WriteHeader(http.StatusNoContent)
+	}
+	return func(ctx context.Context) error {
+		if err := i.slackUsecase.ProcessMessages(ctx, event.ChannelID, ts, userID); err != nil {
+			return fmt.Errorf("failed i.slackUsecase.ProcessMessages for channel %s, timestamp %s: %w", event.ChannelID, ts, err)
+		}
+		return nil
+	}
+}
+
+// enqueueEvent GPTの処理をキューに積み、Slackにはすぐに応答する
+// Slackは3秒以内に応答がないとリトライするため、HTTPリクエスト内ではGPTを呼ばない
+func (i *SlackHandler) enqueueEvent(w http.ResponseWriter, name string, event model.SlackEvent, process func(ctx context.Context) error) {
+	ctx := context.Background()
+
+	ok, err := i.slackUsecase.BeginEvent(ctx, event)
+	if err != nil {
+		httpError(w, "failed to check duplicate event", http.StatusInternalServerError, err)
+		return
+	}
+	if !ok {
+		log.Info().Str("event_id", event.EventID).Msg("duplicate event")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	err = i.jobQueue.Enqueue(queue.Job{
+		Name: name,
+		Run: func(ctx context.Context) error {
+			processErr := process(ctx)
+			// ジョブのタイムアウト後でも記録できるよう、新しいコンテキストを使う
+			if err := i.slackUsecase.FinishEvent(context.Background(), event, processErr); err != nil {
+				log.Error().Err(err).Str("event_id", event.EventID).Msg("failed i.slackUsecase.FinishEvent")
+			}
+			return processErr
+		},
+	})
+	if err != nil {
+		// 混雑時は503を返してSlackに再送してもらう
+		if err := i.slackUsecase.FinishEvent(ctx, event, err); err != nil {
+			log.Error().Err(err).Str("event_id", event.EventID).Msg("failed i.slackUsecase.FinishEvent")
+		}
+		httpError(w, "failed to enqueue event", http.StatusServiceUnavailable, err)
 		return
 	}
 
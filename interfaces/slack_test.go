@@ -0,0 +1,58 @@
+package interfaces
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gs1068/slack-gpt-bot/infrastructure/memory"
+	"github.com/gs1068/slack-gpt-bot/infrastructure/queue"
+	"github.com/gs1068/slack-gpt-bot/usecase"
+)
+
+func TestEventHandlerOuterEvents(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		wantBody string
+	}{
+		{
+			name:     "url verification",
+			body:     `{"token":"xxx","challenge":"3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P","type":"url_verification"}`,
+			wantBody: "3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P",
+		},
+		{
+			name: "app rate limited",
+			body: `{"token":"xxx","type":"app_rate_limited","team_id":"T1","minute_rate_limited":1518467820,"api_app_id":"A1"}`,
+		},
+		{
+			name: "unknown outer event",
+			body: `{"token":"xxx","type":"app_future_event","team_id":"T1","api_app_id":"A1"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// キューは1件しか入らないため、ハンドラーがジョブを積んでいれば後から積めない
+			jobQueue := queue.NewQueue(1, 1, 0)
+			slackUsecase := usecase.NewSlackUsecase(nil, nil, nil, memory.NewEventDedupRepository(), nil, nil, nil, nil, nil)
+			handler := NewSlackHandler(slackUsecase, jobQueue)
+
+			req := httptest.NewRequest(http.MethodPost, "/slack/events", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			handler.EventHandler(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusOK)
+			}
+			if got := rec.Body.String(); got != tt.wantBody {
+				t.Errorf("body = %q, want %q", got, tt.wantBody)
+			}
+			if err := jobQueue.Enqueue(queue.Job{Name: "probe", Run: func(ctx context.Context) error { return nil }}); err != nil {
+				t.Errorf("handler queued a job: Enqueue() error = %v", err)
+			}
+		})
+	}
+}
@@ -7,12 +7,19 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
+	"time"
 
 	"github.com/gs1068/slack-gpt-bot/config"
+	"github.com/gs1068/slack-gpt-bot/domain/model"
 	"github.com/gs1068/slack-gpt-bot/infrastructure/gpt"
+	"github.com/gs1068/slack-gpt-bot/infrastructure/memory"
+	"github.com/gs1068/slack-gpt-bot/infrastructure/persona"
+	"github.com/gs1068/slack-gpt-bot/infrastructure/queue"
 	"github.com/gs1068/slack-gpt-bot/infrastructure/slack"
 	"github.com/gs1068/slack-gpt-bot/infrastructure/spreadsheet"
+	"github.com/gs1068/slack-gpt-bot/infrastructure/tokenizer"
 	"github.com/gs1068/slack-gpt-bot/interfaces"
 	"github.com/gs1068/slack-gpt-bot/router"
 	"github.com/gs1068/slack-gpt-bot/usecase"
@@ -32,6 +39,7 @@ func main() {
 	config.LoadEnv()
 	slackBotToken := os.Getenv("SLACK_BOT_TOKEN")
 	openAIAPIKey := os.Getenv("OPENAI_API_KEY")
+	slackSigningSecret := os.Getenv("SLACK_SIGNING_SECRET")
 	spreadsheet.SpreadsheetID = os.Getenv("SPREADSHEET_ID")
 
 	zerolog.TimestampFieldName = "timestamp"
@@ -48,40 +56,148 @@ func main() {
 	}
 	zerolog.SetGlobalLevel(lvl)
 
+	if slackSigningSecret == "" {
+		log.Fatal().Msg("SLACK_SIGNING_SECRET is not set")
+	}
+
 	// Client
 	slackClient := slack.SlackClient(slackBotToken)
-	gptClient := gpt.GptClient(openAIAPIKey)
-	ssClient, err := spreadsheet.SpreadSheetClient()
-	if err != nil {
-		log.Fatal().Err(err).Msg("failed spreadsheet.SpreadSheetClient")
-	}
 	// Repository
 	slackRepo := slack.NewSlackRepository(slackClient)
-	gptRepo := gpt.NewGptRepository(gptClient)
-	ssRepo := spreadsheet.NewSpreadsheetRepository(ssClient)
+	gpt.Retry = gpt.RetryPolicy{
+		MaxRetries: config.GetEnvInt("LLM_RETRY_MAX", gpt.Retry.MaxRetries),
+		BaseDelay:  config.GetEnvDuration("LLM_RETRY_BASE_DELAY", gpt.Retry.BaseDelay),
+		MaxDelay:   config.GetEnvDuration("LLM_RETRY_MAX_DELAY", gpt.Retry.MaxDelay),
+	}
+	gpt.Breaker = gpt.BreakerPolicy{
+		Threshold: config.GetEnvInt("LLM_BREAKER_THRESHOLD", gpt.Breaker.Threshold),
+		Cooldown:  config.GetEnvDuration("LLM_BREAKER_COOLDOWN", gpt.Breaker.Cooldown),
+	}
+	gptRepo, err := gpt.NewGptRepository(llmProviders(openAIAPIKey), config.GetEnvString("LLM_DEFAULT_PROVIDER", gpt.DefaultProviderName), llmFallbacks())
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed gpt.NewGptRepository")
+	}
+	stores := &storage{}
+	defer stores.Close()
+	usageRepo, err := stores.usageRepository(context.Background(), os.Getenv("USAGE_STORE"))
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed stores.usageRepository")
+	}
+	auditRepo, err := stores.auditRepository(context.Background(), config.GetEnvString("AUDIT_SINK", "jsonl"))
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed stores.auditRepository")
+	}
+	dedupRepo := memory.NewEventDedupRepository()
+	tokenizerRepo := tokenizer.NewTokenizerRepository()
+	summaryRepo := memory.NewThreadSummaryRepository()
+	preferenceRepo, err := stores.preferenceRepository(context.Background(), config.GetEnvString("PREFERENCE_STORE", "sqlite"))
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed stores.preferenceRepository")
+	}
+	personaRepo, err := persona.NewPersonaRepository(os.Getenv("PERSONA_CONFIG_PATH"))
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed persona.NewPersonaRepository")
+	}
 	// Usecase
-	slackUsecase := usecase.NewSlackUsecase(slackRepo, gptRepo, ssRepo)
-	gptUsecase := usecase.NewGptUsecase(gptRepo)
+	slackUsecase := usecase.NewSlackUsecase(slackRepo, gptRepo, usageRepo, dedupRepo, tokenizerRepo, summaryRepo, personaRepo, preferenceRepo, auditRepo)
+	commandUsecase := usecase.NewCommandUsecase(slackRepo, personaRepo, preferenceRepo, usageRepo)
+	gptUsecase := usecase.NewGptUsecase(gptRepo, auditRepo)
+	usecase.StreamingEnabled = config.GetEnvBool("GPT_STREAMING", true)
+	usecase.BlockKitReplies = config.GetEnvBool("SLACK_REPLY_BLOCKS", false)
+	model.DailyTokenLimit = config.GetEnvInt("DAILY_TOKEN_LIMIT", model.DailyTokenLimit)
+	model.UserDailyTokenLimit = config.GetEnvInt("USER_DAILY_TOKEN_LIMIT", model.UserDailyTokenLimit)
+	model.DailyImageLimit = config.GetEnvInt("DAILY_IMAGE_LIMIT", model.DailyImageLimit)
+	model.UserDailyImageLimit = config.GetEnvInt("USER_DAILY_IMAGE_LIMIT", model.UserDailyImageLimit)
+	model.MaxReplyLength = config.GetEnvInt("SLACK_REPLY_MAX_LENGTH", model.MaxReplyLength)
+	model.ReplySnippetLength = config.GetEnvInt("SLACK_REPLY_SNIPPET_LENGTH", model.ReplySnippetLength)
+	usecase.PromptAuditMode = model.PromptAuditMode(config.GetEnvString("AUDIT_PROMPT_MODE", string(model.PromptAuditHash)))
+	// モデルごとの料金を設定する前の、全モデル共通の料金の設定は、料金が登録されていないモデルに使う
+	if price := config.GetEnvFloat("GPT_TOKEN_PRICE_PER_MILLION", 0); price > 0 {
+		model.DefaultModelPrice = model.ModelPrice{Prompt: price, Completion: price}
+	}
+	for modelName, price := range config.GetEnvMap("GPT_MODEL_PRICES") {
+		p, err := model.ParseModelPrice(price)
+		if err != nil {
+			log.Warn().Err(err).Str("model", modelName).Msg("invalid GPT_MODEL_PRICES entry, skipping")
+			continue
+		}
+		model.ModelPrices[modelName] = p
+	}
+	if models := config.GetEnvList("GPT_SELECTABLE_MODELS"); len(models) > 0 {
+		model.SelectableModels = models
+	}
+	model.ProviderSelectableModels = providerSelectableModels(model.SelectableModels)
+	for modelName, budget := range config.GetEnvIntMap("GPT_CONTEXT_BUDGETS") {
+		model.ContextTokenBudgets[modelName] = budget
+	}
+	if models := config.GetEnvList("GPT_VISION_MODELS"); len(models) > 0 {
+		model.VisionModels = models
+	}
+	model.MaxImageAttachmentBytes = config.GetEnvInt("ATTACHMENT_IMAGE_MAX_BYTES", model.MaxImageAttachmentBytes)
+	model.MaxImageAttachments = config.GetEnvInt("ATTACHMENT_IMAGE_MAX_COUNT", model.MaxImageAttachments)
+	model.MaxTextAttachmentBytes = config.GetEnvInt("ATTACHMENT_TEXT_MAX_BYTES", model.MaxTextAttachmentBytes)
+	model.MaxTextAttachments = config.GetEnvInt("ATTACHMENT_TEXT_MAX_COUNT", model.MaxTextAttachments)
+	model.DefaultImageOptions = model.ImageOptions{
+		Provider: os.Getenv("IMAGE_PROVIDER"),
+		Model:    config.GetEnvString("IMAGE_MODEL", model.DefaultImageOptions.Model),
+		Size:     config.GetEnvString("IMAGE_SIZE", model.DefaultImageOptions.Size),
+		Quality:  os.Getenv("IMAGE_QUALITY"),
+		Style:    os.Getenv("IMAGE_STYLE"),
+		Count:    config.GetEnvInt("IMAGE_COUNT", model.DefaultImageOptions.Count),
+	}
+	if count := model.DefaultImageOptions.ImageCount(); count != model.DefaultImageOptions.Count {
+		log.Warn().Int("count", model.DefaultImageOptions.Count).Str("model", model.DefaultImageOptions.Model).Int("using", count).Msg("IMAGE_COUNT is not supported by the image model, clamping")
+	}
+	for modelName, price := range config.GetEnvMap("IMAGE_PRICES") {
+		p, err := strconv.ParseFloat(price, 64)
+		if err != nil {
+			log.Warn().Err(err).Str("model", modelName).Msg("invalid IMAGE_PRICES entry, skipping")
+			continue
+		}
+		model.ImagePrices[modelName] = p
+	}
+	// Queue
+	jobQueue := queue.NewQueue(
+		config.GetEnvInt("WORKER_CONCURRENCY", 4),
+		config.GetEnvInt("WORKER_QUEUE_SIZE", 100),
+		config.GetEnvDuration("WORKER_JOB_TIMEOUT", 2*time.Minute),
+	)
 	// Handler
-	slackHandler := interfaces.NewSlackHandler(slackUsecase)
+	slackHandler := interfaces.NewSlackHandler(slackUsecase, jobQueue)
+	commandHandler := interfaces.NewCommandHandler(commandUsecase, slackUsecase, jobQueue)
 	gptHandler := interfaces.NewGptHandler(gptUsecase)
 
-	sbu, err := slackRepo.GetBotUserId()
+	sbu, sbi, err := slackRepo.GetBotIdentity()
 	if err != nil {
-		log.Fatal().Err(err).Msg("failed slackRepo.GetBotUserId")
+		log.Fatal().Err(err).Msg("failed slackRepo.GetBotIdentity")
 	}
 	slack.SlackBotUserID = sbu
+	slack.SlackBotID = sbi
 
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
 	defer close(sig)
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
 	var g errgroup.Group
 	srv := http.Server{
 		Addr:    ":8080",
-		Handler: router.CreateRouter(&slackHandler, &gptHandler),
+		Handler: router.CreateRouter(slackSigningSecret, &slackHandler, &commandHandler, &gptHandler),
 	}
 
+	// メトリクスはSlackからのリクエストを受けるポートとは別のアドレスで公開する
+	adminAddr := config.GetEnvString("ADMIN_ADDR", "127.0.0.1:8081")
+	adminSrv := http.Server{
+		Addr:    adminAddr,
+		Handler: router.CreateAdminRouter(),
+	}
+
+	g.Go(jobQueue.Run)
+	g.Go(func() error {
+		return personaRepo.Watch(ctx, config.GetEnvDuration("PERSONA_RELOAD_INTERVAL", 30*time.Second))
+	})
 	g.Go(func() error {
 		log.Info().Str("port", "8080").Msg("server started")
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -90,12 +206,32 @@ func main() {
 		return nil
 	})
 
+	if adminAddr != "off" {
+		g.Go(func() error {
+			log.Info().Str("addr", adminAddr).Msg("admin server started")
+			if err := adminSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				return err
+			}
+			return nil
+		})
+	}
+
 	<-sig
 	log.Info().Msg("shutting down server...")
+	cancel()
 
 	if err := srv.Shutdown(context.Background()); err != nil {
 		log.Error().Err(err).Msg("an error occurred while shutting down the server")
 	}
+	if err := adminSrv.Shutdown(context.Background()); err != nil {
+		log.Error().Err(err).Msg("an error occurred while shutting down the admin server")
+	}
+	// 受付済みのジョブを処理し終えてから終了する
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), config.GetEnvDuration("WORKER_DRAIN_TIMEOUT", 30*time.Second))
+	defer drainCancel()
+	if err := jobQueue.Shutdown(drainCtx); err != nil {
+		log.Error().Err(err).Msg("an error occurred while draining the job queue")
+	}
 	if err := g.Wait(); err != nil {
 		log.Error().Err(err).Msg("server error")
 	}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/gs1068/slack-gpt-bot/config"
+	"github.com/gs1068/slack-gpt-bot/infrastructure/gpt"
+)
+
+// llmProviders OPENAI_API_KEY で設定する openai と、LLM_PROVIDERS に並べたプロバイダーの設定を読み込む
+// プロバイダーごとの設定は LLM_PROVIDER_<名前>_TYPE などの環境変数で指定する（名前は大文字にし、- は _ にする）
+// LLM_PROVIDERS に openai を含めた場合は OPENAI_API_KEY の設定の代わりに使う
+func llmProviders(openAIAPIKey string) []gpt.ProviderConfig {
+	providers := []gpt.ProviderConfig{{
+		Name:    gpt.DefaultProviderName,
+		Type:    gpt.ProviderTypeOpenAI,
+		APIKey:  openAIAPIKey,
+		BaseURL: os.Getenv("OPENAI_BASE_URL"),
+	}}
+	for _, name := range config.GetEnvList("LLM_PROVIDERS") {
+		prefix := providerEnvPrefix(name)
+		provider := gpt.ProviderConfig{
+			Name:        name,
+			Type:        config.GetEnvString(prefix+"TYPE", gpt.ProviderTypeCompatible),
+			APIKey:      os.Getenv(prefix + "API_KEY"),
+			BaseURL:     os.Getenv(prefix + "BASE_URL"),
+			APIVersion:  os.Getenv(prefix + "API_VERSION"),
+			Deployments: config.GetEnvMap(prefix + "DEPLOYMENTS"),
+		}
+		if name == gpt.DefaultProviderName {
+			providers[0] = provider
+			continue
+		}
+		providers = append(providers, provider)
+	}
+	return providers
+}
+
+// providerSelectableModels LLM_PROVIDER_<名前>_MODELS に設定した、プロバイダーごとに /gpt model で選択できるモデルを読み込む
+// openai は設定がなければ defaults（GPT_SELECTABLE_MODELS）を使う
+func providerSelectableModels(defaults []string) map[string][]string {
+	models := map[string][]string{gpt.DefaultProviderName: defaults}
+	for _, name := range append([]string{gpt.DefaultProviderName}, config.GetEnvList("LLM_PROVIDERS")...) {
+		if list := config.GetEnvList(providerEnvPrefix(name) + "MODELS"); len(list) > 0 {
+			models[name] = list
+		}
+	}
+	return models
+}
+
+// providerEnvPrefix プロバイダーごとの環境変数の接頭辞
+func providerEnvPrefix(name string) string {
+	return "LLM_PROVIDER_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+}
+
+// llmFallbacks LLM_FALLBACKS に並べたフォールバック先（"プロバイダー:モデル" または "プロバイダー"）を読み込む
+// モデル名に : を含む場合（llama3.1:8b など）も、最初の : までをプロバイダーとする
+func llmFallbacks() []gpt.FallbackTarget {
+	var fallbacks []gpt.FallbackTarget
+	for _, entry := range config.GetEnvList("LLM_FALLBACKS") {
+		provider, modelName, _ := strings.Cut(entry, ":")
+		fallbacks = append(fallbacks, gpt.FallbackTarget{
+			Provider: strings.TrimSpace(provider),
+			Model:    strings.TrimSpace(modelName),
+		})
+	}
+	return fallbacks
+}
@@ -0,0 +1,47 @@
+package router
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+	"github.com/slack-go/slack"
+)
+
+// verifySlackSignature Slackからのリクエストであることを署名(v0)で検証するミドルウェア
+// 署名がない、不正、またはタイムスタンプが古い（5分以上ずれている）リクエストは401で拒否する
+func verifySlackSignature(signingSecret string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			verifier, err := slack.NewSecretsVerifier(r.Header, signingSecret)
+			if err != nil {
+				log.Warn().Err(err).Msg("failed slack.NewSecretsVerifier")
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			bodyBytes, err := io.ReadAll(r.Body)
+			if err != nil {
+				log.Error().Err(err).Msg("failed io.ReadAll(r.Body)")
+				http.Error(w, "failed to read request body", http.StatusBadRequest)
+				return
+			}
+
+			if _, err := verifier.Write(bodyBytes); err != nil {
+				log.Error().Err(err).Msg("failed verifier.Write")
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if err := verifier.Ensure(); err != nil {
+				log.Warn().Err(err).Msg("invalid slack signature")
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			// 後続のハンドラーでも読めるようにBodyを戻す
+			r.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package router
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSigningSecret = "8f742231b10e8888abcd99yyyzzz85a5"
+
+func sign(secret, timestamp, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = fmt.Fprintf(mac, "v0:%s:%s", timestamp, body)
+	return "v0=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifySlackSignature(t *testing.T) {
+	body := `{"type":"event_callback","event":{"type":"app_mention"}}`
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-10*time.Minute).Unix(), 10)
+
+	tests := []struct {
+		name       string
+		timestamp  string
+		signature  string
+		wantStatus int
+	}{
+		{
+			name:       "valid signature",
+			timestamp:  now,
+			signature:  sign(testSigningSecret, now, body),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "missing headers",
+			timestamp:  "",
+			signature:  "",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "missing signature",
+			timestamp:  now,
+			signature:  "",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "signed with another secret",
+			timestamp:  now,
+			signature:  sign("another-secret", now, body),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "signature for another body",
+			timestamp:  now,
+			signature:  sign(testSigningSecret, now, `{"type":"event_callback"}`),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "stale timestamp",
+			timestamp:  stale,
+			signature:  sign(testSigningSecret, stale, body),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "malformed signature",
+			timestamp:  now,
+			signature:  "v0=not-hex",
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotBody string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := io.ReadAll(r.Body)
+				gotBody = string(b)
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body))
+			if tt.timestamp != "" {
+				req.Header.Set("X-Slack-Request-Timestamp", tt.timestamp)
+			}
+			if tt.signature != "" {
+				req.Header.Set("X-Slack-Signature", tt.signature)
+			}
+			rec := httptest.NewRecorder()
+
+			verifySlackSignature(testSigningSecret)(next).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %v, want %v", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusOK && gotBody != body {
+				t.Errorf("body passed to next handler = %v, want %v", gotBody, body)
+			}
+		})
+	}
+}
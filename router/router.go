@@ -1,6 +1,7 @@
 package router
 
 import (
+	"expvar"
 	"io"
 	"net/http"
 
@@ -8,12 +9,18 @@ import (
 	"github.com/gs1068/slack-gpt-bot/interfaces"
 )
 
-func CreateRouter(slackHandler *interfaces.SlackHandler, gptHandler *interfaces.GptHandler) chi.Router {
+func CreateRouter(signingSecret string, slackHandler *interfaces.SlackHandler, commandHandler *interfaces.CommandHandler, gptHandler *interfaces.GptHandler) chi.Router {
 	r := chi.NewRouter()
 	// pingを打つとpongが返ってくるよ
 	r.Get("/ping", pingHandler)
-	// Slackイベントを受け取るエンドポイント
-	r.Post("/events", slackHandler.EventHandler)
+	// Slackからのリクエストは署名を検証する
+	r.Group(func(r chi.Router) {
+		r.Use(verifySlackSignature(signingSecret))
+		// Slackイベントを受け取るエンドポイント
+		r.Post("/events", slackHandler.EventHandler)
+		// スラッシュコマンド（/gpt）を受け取るエンドポイント
+		r.Post("/commands", commandHandler.SlashCommandHandler)
+	})
 	// GPT 検証用なので基本は使わない
 	r.Get("/gpt", gptHandler.CreateCompletion)
 	r.Get("/gpt/image", gptHandler.CreateImage)
@@ -21,6 +28,15 @@ func CreateRouter(slackHandler *interfaces.SlackHandler, gptHandler *interfaces.
 	return r
 }
 
+// CreateAdminRouter 運用者向けのエンドポイント。認証しないため、外部から届かないアドレスで公開する
+func CreateAdminRouter() chi.Router {
+	r := chi.NewRouter()
+	// Slackイベントの受信数などのメトリクス
+	r.Get("/debug/vars", expvar.Handler().ServeHTTP)
+
+	return r
+}
+
 func pingHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/plain")
 	w.WriteHeader(http.StatusOK)
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/gs1068/slack-gpt-bot/config"
+	"github.com/gs1068/slack-gpt-bot/domain/repository"
+	"github.com/gs1068/slack-gpt-bot/infrastructure/jsonl"
+	"github.com/gs1068/slack-gpt-bot/infrastructure/memory"
+	"github.com/gs1068/slack-gpt-bot/infrastructure/spreadsheet"
+	"github.com/gs1068/slack-gpt-bot/infrastructure/sqlite"
+	"google.golang.org/api/sheets/v4"
+)
+
+// storage 使用量・監査ログ・設定の保存先を作成する。同じ種類の保存先はクライアントを共有する
+type storage struct {
+	ssClient *sheets.Service
+	db       *sql.DB
+	closers  []io.Closer
+}
+
+// usageRepository USAGE_STORE（spreadsheet / sqlite / memory）に応じて使用量の保存先を作成する
+func (s *storage) usageRepository(ctx context.Context, store string) (repository.UsageRepository, error) {
+	switch store {
+	case "", "spreadsheet":
+		ssClient, err := s.spreadsheet()
+		if err != nil {
+			return nil, err
+		}
+		return spreadsheet.NewSpreadsheetRepository(ssClient), nil
+	case "sqlite":
+		db, err := s.sqlite()
+		if err != nil {
+			return nil, err
+		}
+		return sqlite.NewUsageRepository(ctx, db)
+	case "memory":
+		return memory.NewUsageRepository(), nil
+	default:
+		return nil, fmt.Errorf("unknown USAGE_STORE: %s", store)
+	}
+}
+
+// auditRepository AUDIT_SINK（jsonl / spreadsheet / sqlite / none）に応じて監査ログの保存先を作成する
+func (s *storage) auditRepository(ctx context.Context, sink string) (repository.AuditRepository, error) {
+	switch sink {
+	case "jsonl":
+		// ファイルを指定しない場合は標準出力に書き込む
+		path := os.Getenv("AUDIT_JSONL_PATH")
+		if path == "" {
+			return jsonl.NewAuditRepository(os.Stdout), nil
+		}
+		f, err := jsonl.OpenFile(path)
+		if err != nil {
+			return nil, err
+		}
+		s.closers = append(s.closers, f)
+		return jsonl.NewAuditRepository(f), nil
+	case "spreadsheet":
+		ssClient, err := s.spreadsheet()
+		if err != nil {
+			return nil, err
+		}
+		// 終了時にバッファに残っている監査ログを書き込む
+		audit := spreadsheet.NewAuditRepository(ssClient)
+		s.closers = append(s.closers, audit)
+		return audit, nil
+	case "sqlite":
+		db, err := s.sqlite()
+		if err != nil {
+			return nil, err
+		}
+		return sqlite.NewAuditRepository(ctx, db)
+	case "none":
+		return jsonl.NewAuditRepository(io.Discard), nil
+	default:
+		return nil, fmt.Errorf("unknown AUDIT_SINK: %s", sink)
+	}
+}
+
+// preferenceRepository PREFERENCE_STORE（sqlite / memory）に応じて /gpt コマンドの設定の保存先を作成する
+func (s *storage) preferenceRepository(ctx context.Context, store string) (repository.PreferenceRepository, error) {
+	switch store {
+	case "sqlite":
+		db, err := s.sqlite()
+		if err != nil {
+			return nil, err
+		}
+		return sqlite.NewPreferenceRepository(ctx, db)
+	case "memory":
+		return memory.NewPreferenceRepository(), nil
+	default:
+		return nil, fmt.Errorf("unknown PREFERENCE_STORE: %s", store)
+	}
+}
+
+func (s *storage) spreadsheet() (*sheets.Service, error) {
+	if s.ssClient != nil {
+		return s.ssClient, nil
+	}
+	ssClient, err := spreadsheet.SpreadSheetClient()
+	if err != nil {
+		return nil, fmt.Errorf("failed spreadsheet.SpreadSheetClient: %w", err)
+	}
+	s.ssClient = ssClient
+	return ssClient, nil
+}
+
+func (s *storage) sqlite() (*sql.DB, error) {
+	if s.db != nil {
+		return s.db, nil
+	}
+	db, err := sqlite.Open(config.GetEnvString("SQLITE_PATH", sqlite.DefaultPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed sqlite.Open: %w", err)
+	}
+	s.db = db
+	s.closers = append(s.closers, db)
+	return db, nil
+}
+
+// Close 開いたファイルとデータベースを閉じる
+func (s *storage) Close() error {
+	var firstErr error
+	for _, c := range s.closers {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,101 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+)
+
+// loadAttachments ユーザーの発言に添付された画像とテキストのファイルをダウンロードしてメッセージに加える
+// 画像を読み取れないモデルの場合や、サイズ・枚数の上限を超えたファイルは、代わりに省略したことを注記する
+// テキストは発言の一部としてトークンの予算に数えるため、予算を超える古い発言と一緒に省かれる
+// loadAfterTS 以前の発言は予算に収まらず省かれるため、添付ファイルを読み込まない
+func (u *SlackUsecase) loadAttachments(ctx context.Context, messages model.SlackMessages, modelName string, botUserID string, botID string, loadAfterTS string) {
+	vision := model.SupportsVision(modelName)
+	remainingImages := model.MaxImageAttachments
+	remainingTexts := model.MaxTextAttachments
+
+	// 枚数の上限を超える場合は新しいファイルを優先するため、新しい発言から順に読む
+	for i := len(messages) - 1; i >= 0; i-- {
+		message := &messages[i]
+		if loadAfterTS != "" && model.CompareTS(message.TS, loadAfterTS) <= 0 {
+			break
+		}
+		if message.IsBot(botUserID, botID) {
+			continue
+		}
+
+		for _, file := range message.Files {
+			if file.IsText() {
+				if remainingTexts <= 0 {
+					message.Notes = append(message.Notes, model.SkippedTextNote(file.Name))
+					continue
+				}
+				u.loadTextAttachment(ctx, message, file)
+				remainingTexts--
+				continue
+			}
+			if !file.IsImage() {
+				continue
+			}
+			switch {
+			case !vision:
+				message.Notes = append(message.Notes, model.UnsupportedImageNote(file.Name))
+			case file.Size > model.MaxImageAttachmentBytes:
+				message.Notes = append(message.Notes, model.OversizedImageNote(file.Name))
+			case remainingImages <= 0:
+				message.Notes = append(message.Notes, model.SkippedImageNote(file.Name))
+			default:
+				image, err := u.downloadImage(ctx, file)
+				if err != nil {
+					log.Printf("failed u.downloadImage %s: %v", file.ID, err)
+					message.Notes = append(message.Notes, model.UnreadableImageNote(file.Name))
+					continue
+				}
+				message.Images = append(message.Images, image)
+				remainingImages--
+			}
+		}
+	}
+}
+
+// downloadImage 添付画像をダウンロードし、GPTが読み取れる画像かどうかを内容から判定する
+func (u *SlackUsecase) downloadImage(ctx context.Context, file model.SlackAttachment) (model.ChatImage, error) {
+	data, err := u.slack.DownloadFile(ctx, file, model.MaxImageAttachmentBytes)
+	if err != nil {
+		return model.ChatImage{}, fmt.Errorf("failed u.slack.DownloadFile: %w", err)
+	}
+	image, ok := model.NewChatImage(data)
+	if !ok {
+		return model.ChatImage{}, fmt.Errorf("unsupported image content: %s", file.MimeType)
+	}
+	return image, nil
+}
+
+// loadTextAttachment テキストの添付ファイルをダウンロードし、上限のサイズまでに切り詰めてメッセージに加える
+func (u *SlackUsecase) loadTextAttachment(ctx context.Context, message *model.SlackMessage, file model.SlackAttachment) {
+	// 内容を含めない設定の場合はダウンロードしない
+	if model.MaxTextAttachmentBytes <= 0 {
+		message.Notes = append(message.Notes, model.OmittedTextNote(file.Name))
+		return
+	}
+	if file.Size > model.MaxTextAttachmentDownloadBytes {
+		message.Notes = append(message.Notes, model.OversizedTextNote(file.Name))
+		return
+	}
+
+	data, err := u.slack.DownloadFile(ctx, file, model.MaxTextAttachmentDownloadBytes)
+	if err != nil {
+		log.Printf("failed u.slack.DownloadFile %s: %v", file.ID, err)
+		message.Notes = append(message.Notes, model.UnreadableTextNote(file.Name))
+		return
+	}
+	text, ok := model.NewTextAttachment(file.Name, data)
+	if !ok {
+		message.Notes = append(message.Notes, model.UnreadableTextNote(file.Name))
+		return
+	}
+	message.Texts = append(message.Texts, text)
+}
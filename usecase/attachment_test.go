@@ -0,0 +1,59 @@
+package usecase
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+)
+
+func TestLoadTextAttachment(t *testing.T) {
+	original := model.MaxTextAttachmentBytes
+	t.Cleanup(func() { model.MaxTextAttachmentBytes = original })
+
+	file := model.SlackAttachment{ID: "F1", Name: "app.log", MimeType: "text/plain", Size: 7}
+	tests := []struct {
+		name          string
+		limit         int
+		wantTexts     []model.TextAttachment
+		wantNotes     []string
+		wantDownloads int
+	}{
+		{
+			name:          "included",
+			limit:         8000,
+			wantTexts:     []model.TextAttachment{{Name: "app.log", Content: "secret\n"}},
+			wantDownloads: 1,
+		},
+		{
+			name:      "zero limit",
+			limit:     0,
+			wantNotes: []string{model.OmittedTextNote("app.log")},
+		},
+		{
+			name:      "negative limit",
+			limit:     -1,
+			wantNotes: []string{model.OmittedTextNote("app.log")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model.MaxTextAttachmentBytes = tt.limit
+			slackRepo := &fakeSlack{files: map[string][]byte{"F1": []byte("secret\n")}}
+			u := NewSlackUsecase(slackRepo, nil, nil, nil, nil, nil, nil, nil, fakeAudit{})
+
+			message := &model.SlackMessage{}
+			u.loadTextAttachment(context.Background(), message, file)
+
+			if !reflect.DeepEqual(message.Texts, tt.wantTexts) || !reflect.DeepEqual(message.Notes, tt.wantNotes) {
+				t.Errorf("texts = %+v, notes = %q, want %+v and %q", message.Texts, message.Notes, tt.wantTexts, tt.wantNotes)
+			}
+			// 内容を含めない設定ではダウンロードしない
+			if slackRepo.downloads != tt.wantDownloads {
+				t.Errorf("downloads = %d, want %d", slackRepo.downloads, tt.wantDownloads)
+			}
+		})
+	}
+}
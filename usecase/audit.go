@@ -0,0 +1,79 @@
+package usecase
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+	"github.com/gs1068/slack-gpt-bot/domain/repository"
+)
+
+// PromptAuditMode 監査ログにプロンプトをどう残すか
+var PromptAuditMode = model.PromptAuditHash
+
+// auditedGpt GPTの呼び出しごとに監査ログを記録する
+type auditedGpt struct {
+	gpt   repository.GptRepository
+	audit repository.AuditRepository
+}
+
+// gptCaller 監査ログに残す呼び出し元の情報
+type gptCaller struct {
+	kind      model.AuditKind
+	channelID string
+	threadTS  string
+	userID    string
+}
+
+func (g *auditedGpt) CreateCompletion(ctx context.Context, caller gptCaller, conversation model.Conversation) (model.ChatResponse, error) {
+	start := time.Now()
+	resp, err := g.gpt.CreateCompletion(ctx, conversation)
+	g.record(ctx, caller, conversation, start, resp, err)
+	return resp, err
+}
+
+func (g *auditedGpt) CreateCompletionStream(ctx context.Context, caller gptCaller, conversation model.Conversation, onDelta func(delta string) error) (model.ChatResponse, error) {
+	start := time.Now()
+	resp, err := g.gpt.CreateCompletionStream(ctx, conversation, onDelta)
+	g.record(ctx, caller, conversation, start, resp, err)
+	return resp, err
+}
+
+// CreateImage 画像の説明をユーザーのメッセージとして記録する
+func (g *auditedGpt) CreateImage(ctx context.Context, caller gptCaller, prompt string, options model.ImageOptions) ([]model.GeneratedImage, error) {
+	start := time.Now()
+	images, err := g.gpt.CreateImage(ctx, prompt, options)
+	conversation := model.NewConversation("", prompt)
+	conversation.Model = options.Model
+	g.record(ctx, caller, conversation, start, model.ChatResponse{}, err)
+	return images, err
+}
+
+// record 監査ログを記録する。記録に失敗しても応答は続ける
+func (g *auditedGpt) record(ctx context.Context, caller gptCaller, conversation model.Conversation, start time.Time, resp model.ChatResponse, err error) {
+	record := model.AuditRecord{
+		Time:      start,
+		Kind:      caller.kind,
+		ChannelID: caller.channelID,
+		ThreadTS:  caller.threadTS,
+		UserID:    caller.userID,
+		Model:     conversation.ModelName(),
+	}
+	if resp.Model != "" {
+		record.Model = resp.Model
+	}
+	record.PromptTokens = resp.Usage.PromptTokens
+	record.CompletionTokens = resp.Usage.CompletionTokens
+	record.LatencyMS = time.Since(start).Milliseconds()
+	record.FinishReason = resp.FinishReason
+	if err != nil {
+		record.Error = err.Error()
+	}
+	record.SetPrompt(conversation, PromptAuditMode)
+
+	// タイムアウトで呼び出しが失敗した場合も記録できるよう、キャンセルを引き継がない
+	if err := g.audit.RecordCompletion(context.WithoutCancel(ctx), record); err != nil {
+		log.Printf("failed g.audit.RecordCompletion: %v", err)
+	}
+}
@@ -0,0 +1,216 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+	"github.com/gs1068/slack-gpt-bot/domain/repository"
+	"github.com/gs1068/slack-gpt-bot/infrastructure/slack"
+)
+
+type CommandUsecase struct {
+	slack      repository.SlackRepository
+	persona    repository.PersonaRepository
+	preference repository.PreferenceRepository
+	usage      repository.UsageRepository
+	personas   *personaResolver
+}
+
+func NewCommandUsecase(
+	slack repository.SlackRepository,
+	persona repository.PersonaRepository,
+	preference repository.PreferenceRepository,
+	usage repository.UsageRepository,
+) *CommandUsecase {
+	return &CommandUsecase{
+		slack:      slack,
+		persona:    persona,
+		preference: preference,
+		usage:      usage,
+		personas: &personaResolver{
+			slack:      slack,
+			persona:    persona,
+			preference: preference,
+		},
+	}
+}
+
+// Execute /gpt コマンドを実行し、コマンドを実行したユーザーにだけ表示するメッセージを返す
+func (u *CommandUsecase) Execute(ctx context.Context, command model.SlashCommand) (model.CommandResponse, error) {
+	if command.Name == model.CommandUsage {
+		return u.showUsage(ctx, command)
+	}
+
+	// チャンネル全体の設定の変更は、権限のあるユーザーに限る
+	if command.Scope == model.PreferenceScopeChannel && changesPreference(command) {
+		ok, err := u.canChangeChannelPreference(command)
+		if err != nil {
+			return model.CommandResponse{}, err
+		}
+		if !ok {
+			return model.CommandResponse{Text: model.ChannelScopeDeniedMessage}, nil
+		}
+	}
+
+	var text string
+	var err error
+	switch command.Name {
+	case model.CommandPersona:
+		text, err = u.switchPersona(ctx, command)
+	case model.CommandModel:
+		text, err = u.switchModel(ctx, command)
+	case model.CommandReset:
+		text, err = u.resetPreference(ctx, command)
+	case model.CommandHelp:
+		text = model.CommandHelpMessage
+	default:
+		text = fmt.Sprintf("不明なコマンドです: `%s`\n%s", command.Name, model.CommandHelpMessage)
+	}
+	return model.CommandResponse{Text: text}, err
+}
+
+// changesPreference 設定を変更するコマンドかどうか。引数のない persona と model は現在の設定を表示するだけ
+func changesPreference(command model.SlashCommand) bool {
+	switch command.Name {
+	case model.CommandPersona, model.CommandModel:
+		return len(command.Args) > 0
+	case model.CommandReset:
+		return true
+	default:
+		return false
+	}
+}
+
+// canChangeChannelPreference コマンドを実行したユーザーがチャンネル全体の設定を変更できるか
+func (u *CommandUsecase) canChangeChannelPreference(command model.SlashCommand) (bool, error) {
+	user, err := u.slack.GetUserInfo(command.UserID)
+	if err != nil {
+		return false, fmt.Errorf("failed u.slack.GetUserInfo: %w", err)
+	}
+	channel, err := u.slack.GetChannelInfo(command.ChannelID)
+	if err != nil {
+		return false, fmt.Errorf("failed u.slack.GetChannelInfo: %w", err)
+	}
+	return model.CanChangeChannelPreference(user, channel), nil
+}
+
+func (u *CommandUsecase) switchPersona(ctx context.Context, command model.SlashCommand) (string, error) {
+	personas, err := u.persona.ListPersonas(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed u.persona.ListPersonas: %w", err)
+	}
+	names := make([]string, 0, len(personas))
+	for _, persona := range personas {
+		names = append(names, "`"+persona.Name+"`")
+	}
+
+	// 引数がない場合は現在の設定を表示する
+	if len(command.Args) == 0 {
+		current, err := u.personas.resolve(ctx, command.ChannelID, command.UserID)
+		if err != nil {
+			return "", fmt.Errorf("failed u.personas.resolve: %w", err)
+		}
+		return fmt.Sprintf("現在のペルソナ: `%s`\n選択できるペルソナ: %s", current.Name, strings.Join(names, ", ")), nil
+	}
+
+	name := command.Args[0]
+	if _, ok, err := u.persona.GetPersona(ctx, name); err != nil {
+		return "", fmt.Errorf("failed u.persona.GetPersona: %w", err)
+	} else if !ok {
+		return fmt.Sprintf("ペルソナ `%s` は存在しません。\n選択できるペルソナ: %s", name, strings.Join(names, ", ")), nil
+	}
+
+	err = u.updatePreference(ctx, command, func(preference *model.Preference) {
+		preference.Persona = name
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%sのペルソナを `%s` に切り替えました。", scopeLabel(command.Scope), name), nil
+}
+
+func (u *CommandUsecase) switchModel(ctx context.Context, command model.SlashCommand) (string, error) {
+	// 選択できるモデルは、チャンネルのルールも反映したペルソナのプロバイダーで決まる
+	current, err := u.personas.resolve(ctx, command.ChannelID, command.UserID)
+	if err != nil {
+		return "", fmt.Errorf("failed u.personas.resolve: %w", err)
+	}
+	selectable := model.SelectableModelsFor(current.Provider)
+	if len(selectable) == 0 {
+		return fmt.Sprintf("現在のモデル: `%s`\nプロバイダー `%s` のペルソナではモデルを変更できません。", current.Model, current.Provider), nil
+	}
+	models := "`" + strings.Join(selectable, "`, `") + "`"
+
+	if len(command.Args) == 0 {
+		return fmt.Sprintf("現在のモデル: `%s`\n選択できるモデル: %s", current.Model, models), nil
+	}
+
+	name := command.Args[0]
+	if !model.IsSelectableModel(current.Provider, name) {
+		return fmt.Sprintf("モデル `%s` は選択できません。\n選択できるモデル: %s", name, models), nil
+	}
+
+	err = u.updatePreference(ctx, command, func(preference *model.Preference) {
+		preference.Model = name
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%sのモデルを `%s` に切り替えました。", scopeLabel(command.Scope), name), nil
+}
+
+// showUsage ユーザーの本日・累計の使用量、リセットまでの残り、推定費用をBlock Kitで表示する
+func (u *CommandUsecase) showUsage(ctx context.Context, command model.SlashCommand) (model.CommandResponse, error) {
+	userData, err := loadUsage(ctx, u.usage, command.UserID)
+	if err != nil {
+		return model.CommandResponse{}, fmt.Errorf("failed to retrieve user usage: %w", err)
+	}
+	workspaceData, err := loadUsage(ctx, u.usage, slack.SlackBotUserID)
+	if err != nil {
+		return model.CommandResponse{}, fmt.Errorf("failed to retrieve workspace usage: %w", err)
+	}
+
+	report := model.NewUsageReport(userData, workspaceData, time.Now())
+	return model.CommandResponse{
+		Text:   report.Text(),
+		Blocks: report.Blocks(),
+	}, nil
+}
+
+func (u *CommandUsecase) resetPreference(ctx context.Context, command model.SlashCommand) (string, error) {
+	if err := u.preference.DeletePreference(ctx, command.Scope, command.PreferenceID()); err != nil {
+		return "", fmt.Errorf("failed u.preference.DeletePreference: %w", err)
+	}
+	return fmt.Sprintf("%sの設定を元に戻しました。", scopeLabel(command.Scope)), nil
+}
+
+// updatePreference 既存の設定に変更を加えて保存する
+func (u *CommandUsecase) updatePreference(ctx context.Context, command model.SlashCommand, update func(preference *model.Preference)) error {
+	preference, err := u.preference.GetPreference(ctx, command.Scope, command.PreferenceID())
+	if err != nil {
+		return fmt.Errorf("failed u.preference.GetPreference: %w", err)
+	}
+	if preference == nil {
+		preference = &model.Preference{
+			Scope: command.Scope,
+			ID:    command.PreferenceID(),
+		}
+	}
+
+	update(preference)
+
+	if err := u.preference.SavePreference(ctx, *preference); err != nil {
+		return fmt.Errorf("failed u.preference.SavePreference: %w", err)
+	}
+	return nil
+}
+
+func scopeLabel(scope model.PreferenceScope) string {
+	if scope == model.PreferenceScopeChannel {
+		return "このチャンネル"
+	}
+	return "あなた"
+}
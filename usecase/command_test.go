@@ -0,0 +1,120 @@
+package usecase
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+	"github.com/gs1068/slack-gpt-bot/infrastructure/memory"
+)
+
+func TestCommandUsecaseExecute(t *testing.T) {
+	defer func(models map[string][]string) { model.ProviderSelectableModels = models }(model.ProviderSelectableModels)
+	model.ProviderSelectableModels = map[string][]string{"local": {"llama3.1:8b"}}
+
+	slackRepo := &fakeSlack{
+		channels: map[string]model.ChannelInfo{
+			"C1":      {ID: "C1", Name: "general", Creator: "UCREATOR"},
+			"CSECRET": {ID: "CSECRET", Name: "secret-project", Creator: "UCREATOR"},
+		},
+		users: map[string]model.SlackUser{
+			"UADMIN": {ID: "UADMIN", IsAdmin: true},
+		},
+	}
+	personaConfig := &model.PersonaConfig{
+		Personas: []model.Persona{
+			{Name: "assistant", SystemPrompt: "You are a helpful assistant."},
+		},
+		Rules: []model.PersonaRule{
+			{ChannelPattern: "^secret-", Persona: "assistant", Provider: "local"},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		existing *model.Preference
+		command  model.SlashCommand
+		wantText string
+		want     *model.Preference // 保存された設定。nil の場合は保存されない
+	}{
+		{
+			name:     "user persona",
+			command:  model.SlashCommand{UserID: "U1", ChannelID: "C1", Name: model.CommandPersona, Args: []string{"assistant"}, Scope: model.PreferenceScopeUser},
+			wantText: "あなたのペルソナを `assistant` に切り替えました。",
+			want:     &model.Preference{Scope: model.PreferenceScopeUser, ID: "U1", Persona: "assistant"},
+		},
+		{
+			name:     "user model keeps persona",
+			existing: &model.Preference{Scope: model.PreferenceScopeUser, ID: "U1", Persona: "assistant"},
+			command:  model.SlashCommand{UserID: "U1", ChannelID: "C1", Name: model.CommandModel, Args: []string{"gpt-4o-mini"}, Scope: model.PreferenceScopeUser},
+			wantText: "あなたのモデルを `gpt-4o-mini` に切り替えました。",
+			want:     &model.Preference{Scope: model.PreferenceScopeUser, ID: "U1", Persona: "assistant", Model: "gpt-4o-mini"},
+		},
+		{
+			name:     "channel persona by creator",
+			command:  model.SlashCommand{UserID: "UCREATOR", ChannelID: "C1", Name: model.CommandPersona, Args: []string{"assistant"}, Scope: model.PreferenceScopeChannel},
+			wantText: "このチャンネルのペルソナを `assistant` に切り替えました。",
+			want:     &model.Preference{Scope: model.PreferenceScopeChannel, ID: "C1", Persona: "assistant"},
+		},
+		{
+			name:     "channel model by admin",
+			command:  model.SlashCommand{UserID: "UADMIN", ChannelID: "C1", Name: model.CommandModel, Args: []string{"gpt-4o"}, Scope: model.PreferenceScopeChannel},
+			wantText: "このチャンネルのモデルを `gpt-4o` に切り替えました。",
+			want:     &model.Preference{Scope: model.PreferenceScopeChannel, ID: "C1", Model: "gpt-4o"},
+		},
+		{
+			name:     "channel persona denied",
+			command:  model.SlashCommand{UserID: "U1", ChannelID: "C1", Name: model.CommandPersona, Args: []string{"assistant"}, Scope: model.PreferenceScopeChannel},
+			wantText: model.ChannelScopeDeniedMessage,
+		},
+		{
+			name:     "channel reset denied",
+			existing: &model.Preference{Scope: model.PreferenceScopeChannel, ID: "C1", Persona: "assistant"},
+			command:  model.SlashCommand{UserID: "U1", ChannelID: "C1", Name: model.CommandReset, Scope: model.PreferenceScopeChannel},
+			wantText: model.ChannelScopeDeniedMessage,
+			want:     &model.Preference{Scope: model.PreferenceScopeChannel, ID: "C1", Persona: "assistant"},
+		},
+		{
+			name:     "OpenAI model on local provider",
+			command:  model.SlashCommand{UserID: "U1", ChannelID: "CSECRET", Name: model.CommandModel, Args: []string{"gpt-4o"}, Scope: model.PreferenceScopeUser},
+			wantText: "モデル `gpt-4o` は選択できません。",
+		},
+		{
+			name:     "local model on local provider",
+			command:  model.SlashCommand{UserID: "U1", ChannelID: "CSECRET", Name: model.CommandModel, Args: []string{"llama3.1:8b"}, Scope: model.PreferenceScopeUser},
+			wantText: "あなたのモデルを `llama3.1:8b` に切り替えました。",
+			want:     &model.Preference{Scope: model.PreferenceScopeUser, ID: "U1", Model: "llama3.1:8b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			preference := memory.NewPreferenceRepository()
+			if tt.existing != nil {
+				if err := preference.SavePreference(ctx, *tt.existing); err != nil {
+					t.Fatalf("SavePreference() error = %v", err)
+				}
+			}
+			u := NewCommandUsecase(slackRepo, newFakePersona(t, personaConfig), preference, memory.NewUsageRepository())
+
+			got, err := u.Execute(ctx, tt.command)
+			if err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+			if !strings.HasPrefix(got.Text, tt.wantText) {
+				t.Errorf("Execute() text = %q, want prefix %q", got.Text, tt.wantText)
+			}
+
+			saved, err := preference.GetPreference(ctx, tt.command.Scope, tt.command.PreferenceID())
+			if err != nil {
+				t.Fatalf("GetPreference() error = %v", err)
+			}
+			if !reflect.DeepEqual(saved, tt.want) {
+				t.Errorf("saved preference = %+v, want %+v", saved, tt.want)
+			}
+		})
+	}
+}
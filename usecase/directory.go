@@ -0,0 +1,40 @@
+package usecase
+
+import (
+	"log"
+
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+)
+
+// loadDirectory スレッドの発言者とメッセージ中で参照されたユーザー・チャンネルの名前を引く
+// 名前を引けなかったIDはプロンプトにそのまま残す
+func (u *SlackUsecase) loadDirectory(messages model.SlackMessages, botUserID string, botID string) model.SlackDirectory {
+	directory := model.SlackDirectory{
+		BotUserID: botUserID,
+		BotID:     botID,
+		Users:     make(map[string]string),
+		Channels:  make(map[string]string),
+	}
+
+	userIDs, channelIDs := messages.ReferencedIDs()
+	for _, userID := range userIDs {
+		if userID == botUserID {
+			continue
+		}
+		user, err := u.slack.GetUserInfo(userID)
+		if err != nil {
+			log.Printf("failed u.slack.GetUserInfo %s: %v", userID, err)
+			continue
+		}
+		directory.Users[userID] = user.DisplayName
+	}
+	for _, channelID := range channelIDs {
+		channel, err := u.slack.GetChannelInfo(channelID)
+		if err != nil {
+			log.Printf("failed u.slack.GetChannelInfo %s: %v", channelID, err)
+			continue
+		}
+		directory.Channels[channelID] = channel.Name
+	}
+	return directory
+}
@@ -0,0 +1,130 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+	"github.com/gs1068/slack-gpt-bot/domain/repository"
+)
+
+// fakeSlack 投稿したメッセージとアップロードしたファイルを記録する。使わないメソッドは呼ぶと panic する
+type fakeSlack struct {
+	repository.SlackRepository
+
+	mu        sync.Mutex
+	channels  map[string]model.ChannelInfo
+	users     map[string]model.SlackUser
+	messages  []string
+	uploads   []model.SlackFile
+	uploadErr error
+	files     map[string][]byte // ファイルID -> 内容
+	downloads int
+}
+
+func (s *fakeSlack) CreateNewBotMessage(channelId string, timeStamp string, msg string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messages = append(s.messages, msg)
+	return nil
+}
+
+func (s *fakeSlack) PostPlaceholderMessage(channelId string, timeStamp string, msg string) (*model.BotMessage, error) {
+	return &model.BotMessage{}, nil
+}
+
+func (s *fakeSlack) UpdateBotMessage(botMessage *model.BotMessage, msg string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messages = append(s.messages, msg)
+	return nil
+}
+
+func (s *fakeSlack) GetChannelInfo(channelId string) (model.ChannelInfo, error) {
+	if channel, ok := s.channels[channelId]; ok {
+		return channel, nil
+	}
+	return model.ChannelInfo{ID: channelId}, nil
+}
+
+func (s *fakeSlack) GetUserInfo(userID string) (model.SlackUser, error) {
+	if user, ok := s.users[userID]; ok {
+		return user, nil
+	}
+	return model.SlackUser{ID: userID}, nil
+}
+
+func (s *fakeSlack) UploadFile(ctx context.Context, channelId string, timeStamp string, file model.SlackFile) error {
+	if s.uploadErr != nil {
+		return s.uploadErr
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.uploads = append(s.uploads, file)
+	return nil
+}
+
+func (s *fakeSlack) DownloadFile(ctx context.Context, file model.SlackAttachment, maxBytes int) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.downloads++
+	data, ok := s.files[file.ID]
+	if !ok {
+		return nil, fmt.Errorf("file %s not found", file.ID)
+	}
+	return data, nil
+}
+
+// fakeGpt 決まった画像を返す。使わないメソッドは呼ぶと panic する
+type fakeGpt struct {
+	repository.GptRepository
+
+	images   []model.GeneratedImage
+	imageErr error
+	calls    int
+}
+
+func (g *fakeGpt) CreateImage(ctx context.Context, prompt string, options model.ImageOptions) ([]model.GeneratedImage, error) {
+	g.calls++
+	return g.images, g.imageErr
+}
+
+// fakePersona 設定ファイルを読まずに PersonaConfig からペルソナを返す
+type fakePersona struct {
+	config *model.PersonaConfig
+}
+
+func newFakePersona(t *testing.T, config *model.PersonaConfig) *fakePersona {
+	t.Helper()
+	if err := config.Prepare(); err != nil {
+		t.Fatalf("Prepare() error = %v", err)
+	}
+	return &fakePersona{config: config}
+}
+
+func (p *fakePersona) FindPersona(ctx context.Context, channel model.ChannelInfo) (model.Persona, string, error) {
+	persona, provider := p.config.Resolve(channel)
+	return persona, provider, nil
+}
+
+func (p *fakePersona) GetPersona(ctx context.Context, name string) (model.Persona, bool, error) {
+	persona, ok := p.config.Persona(name)
+	return persona, ok, nil
+}
+
+func (p *fakePersona) ListPersonas(ctx context.Context) ([]model.Persona, error) {
+	return p.config.Personas, nil
+}
+
+// fakeAudit 監査ログを捨てる
+type fakeAudit struct{}
+
+func (fakeAudit) RecordCompletion(ctx context.Context, record model.AuditRecord) error {
+	return nil
+}
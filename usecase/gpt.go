@@ -3,54 +3,41 @@ package usecase
 import (
 	"context"
 	"fmt"
-	"io"
-	"log"
-	"net/http"
 
+	"github.com/gs1068/slack-gpt-bot/domain/model"
 	"github.com/gs1068/slack-gpt-bot/domain/repository"
-	"github.com/sashabaranov/go-openai"
 )
 
 type GptUsecase struct {
-	gpt repository.GptRepository
+	completion *auditedGpt
 }
 
 func NewGptUsecase(
 	gpt repository.GptRepository,
+	audit repository.AuditRepository,
 ) *GptUsecase {
 	return &GptUsecase{
-		gpt: gpt,
+		completion: &auditedGpt{gpt: gpt, audit: audit},
 	}
 }
 
-func (u *GptUsecase) CreateCompletion(ctx context.Context, prompt string) (openai.ChatCompletionResponse, error) {
-	resp, err := u.gpt.CreateCompletion(ctx, prompt)
+func (u *GptUsecase) CreateCompletion(ctx context.Context, prompt string) (model.ChatResponse, error) {
+	resp, err := u.completion.CreateCompletion(ctx, gptCaller{kind: model.AuditKindDebug}, model.NewConversation(model.CharacterSettings, prompt))
 	if err != nil {
-		return openai.ChatCompletionResponse{}, fmt.Errorf("failed u.gpt.CreateCompletion: %w", err)
+		return model.ChatResponse{}, fmt.Errorf("failed u.gpt.CreateCompletion: %w", err)
 	}
 
 	return resp, nil
 }
 
-func (u *GptUsecase) CreateImage(ctx context.Context, prompt string) (io.Reader, error) {
-	respUrl, err := u.gpt.CreateImage(ctx, prompt)
+// CreateImage 画像を1枚生成して返す
+func (u *GptUsecase) CreateImage(ctx context.Context, prompt string) (model.GeneratedImage, error) {
+	options := model.DefaultImageOptions
+	options.Count = 1
+	images, err := u.completion.CreateImage(ctx, gptCaller{kind: model.AuditKindDebug}, prompt, options)
 	if err != nil {
-		return nil, fmt.Errorf("failed u.gpt.CreateImage: %w", err)
+		return model.GeneratedImage{}, fmt.Errorf("failed u.gpt.CreateImage: %w", err)
 	}
 
-	image, err := downloadImage(respUrl)
-	if err != nil {
-		return nil, fmt.Errorf("failed downloadImage: %w", err)
-	}
-
-	return image, nil
-}
-
-func downloadImage(url string) (io.Reader, error) {
-	response, err := http.Get(url)
-	if err != nil {
-		log.Printf("downloadImage failed, err=%+v", err)
-		return nil, err
-	}
-	return response.Body, nil
+	return images[0], nil
 }
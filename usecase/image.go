@@ -0,0 +1,130 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+	"github.com/gs1068/slack-gpt-bot/infrastructure/slack"
+)
+
+// GenerateImage 画像を生成してスレッドにアップロードする。timeStamp が空の場合はチャンネルに投稿する
+// 画像はトークンとは別にユーザーとワークスペース全体の1日あたりの枚数で制限する
+func (u *SlackUsecase) GenerateImage(ctx context.Context, channelId string, timeStamp string, userID string, prompt string) error {
+	if prompt == "" {
+		return u.slack.CreateNewBotMessage(channelId, timeStamp, model.ImageUsageMessage)
+	}
+
+	// 同時に依頼されても上限を超えないよう、作成する前に枚数を確保する
+	options := model.DefaultImageOptions
+	count := options.ImageCount()
+	limitMessage, err := u.reserveImages(ctx, userID, options.Model, count)
+	if err != nil {
+		return err
+	}
+	if limitMessage != "" {
+		return u.slack.CreateNewBotMessage(channelId, timeStamp, limitMessage)
+	}
+
+	generated, uploadErr := u.generateImages(ctx, channelId, timeStamp, userID, prompt, options)
+
+	// 作成した枚数で確定し、リクエストとして1回数える。作成した画像は費用がかかっているため、アップロードに失敗しても払い戻さない
+	settlement := model.ImageSettlement(options.Model, count, generated)
+	for _, id := range []string{userID, slack.SlackBotUserID} {
+		if _, err := u.usage.IncrementUsage(ctx, id, settlement); err != nil {
+			return errors.Join(uploadErr, fmt.Errorf("failed u.usage.IncrementUsage for %s: %w", id, err))
+		}
+	}
+
+	return uploadErr
+}
+
+// reserveImages ユーザーとワークスペース全体の使用量に count 枚を加算して確保する
+// 上限を超える場合は加算した分を取り消し、ユーザーに伝えるメッセージを返す。確保した時点ではリクエストとして数えない
+func (u *SlackUsecase) reserveImages(ctx context.Context, userID string, modelName string, count int) (string, error) {
+	reservation := model.ImageReservation(modelName, count)
+	cancellation := model.ImageCancellation(modelName, count)
+
+	userData, err := u.usage.IncrementUsage(ctx, userID, reservation)
+	if err != nil {
+		return "", fmt.Errorf("failed u.usage.IncrementUsage for user %s: %w", userID, err)
+	}
+	// 加算した後の使用量で上限を確かめる
+	if err := userData.CanUseUserDailyImages(0); err != nil {
+		if _, err := u.usage.IncrementUsage(ctx, userID, cancellation); err != nil {
+			return "", fmt.Errorf("failed u.usage.IncrementUsage for user %s: %w", userID, err)
+		}
+		return model.UserImageLimitMessage, nil
+	}
+
+	workspaceData, err := u.usage.IncrementUsage(ctx, slack.SlackBotUserID, reservation)
+	if err != nil {
+		if _, cancelErr := u.usage.IncrementUsage(ctx, userID, cancellation); cancelErr != nil {
+			log.Printf("failed u.usage.IncrementUsage for user %s: %v", userID, cancelErr)
+		}
+		return "", fmt.Errorf("failed u.usage.IncrementUsage for workspace: %w", err)
+	}
+	if err := workspaceData.CanUseDailyImages(0); err != nil {
+		if err := incrementUsage(ctx, u.usage, userID, cancellation); err != nil {
+			return "", err
+		}
+		return model.ImageLimitMessage, nil
+	}
+
+	return "", nil
+}
+
+// generateImages 画像を作成してアップロードし、結果をプレースホルダーのメッセージで伝える。作成した枚数を返す
+func (u *SlackUsecase) generateImages(ctx context.Context, channelId string, timeStamp string, userID string, prompt string, options model.ImageOptions) (int, error) {
+	botMessage, err := u.slack.PostPlaceholderMessage(channelId, timeStamp, model.ImagePlaceholderMessage)
+	if err != nil {
+		return 0, fmt.Errorf("failed u.slack.PostPlaceholderMessage for channel %s, timestamp %s: %v", channelId, timeStamp, err)
+	}
+
+	generated, uploadErr := u.uploadImages(ctx, channelId, timeStamp, userID, prompt, options)
+	message := fmt.Sprintf("<@%s> の依頼で画像を作成しました。", userID)
+	if uploadErr != nil {
+		message = model.ImageErrorMessage
+	}
+	if err := u.slack.UpdateBotMessage(botMessage, message); err != nil {
+		log.Printf("failed u.slack.UpdateBotMessage: %v", err)
+	}
+	return generated, uploadErr
+}
+
+// uploadImages 画像を生成してSlackにアップロードする
+// 内容を取得できなかった画像やアップロードに失敗した画像も費用がかかっているため、APIが生成した枚数を返す
+func (u *SlackUsecase) uploadImages(ctx context.Context, channelId string, timeStamp string, userID string, prompt string, options model.ImageOptions) (int, error) {
+	caller := gptCaller{kind: model.AuditKindImage, channelID: channelId, threadTS: timeStamp, userID: userID}
+	images, err := u.gpt.CreateImage(ctx, caller, prompt, options)
+	generated := len(images)
+	var downloadErr *model.ImageDownloadError
+	if errors.As(err, &downloadErr) {
+		// 取得できた画像はアップロードする
+		generated = downloadErr.Generated
+	} else if err != nil {
+		return 0, fmt.Errorf("failed u.gpt.CreateImage: %w", err)
+	}
+
+	for i, image := range images {
+		altText := prompt
+		if image.RevisedPrompt != "" {
+			altText = image.RevisedPrompt
+		}
+		err := u.slack.UploadFile(ctx, channelId, timeStamp, model.SlackFile{
+			Filename: fmt.Sprintf("image-%d.%s", i+1, image.Extension()),
+			Title:    prompt,
+			AltText:  altText,
+			Content:  image.Data,
+		})
+		if err != nil {
+			return generated, fmt.Errorf("failed u.slack.UploadFile: %w", err)
+		}
+	}
+	if downloadErr != nil {
+		return generated, fmt.Errorf("failed u.gpt.CreateImage: %w", downloadErr)
+	}
+	return generated, nil
+}
@@ -0,0 +1,113 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+	"github.com/gs1068/slack-gpt-bot/infrastructure/memory"
+	"github.com/gs1068/slack-gpt-bot/infrastructure/slack"
+)
+
+func TestGenerateImage(t *testing.T) {
+	png := model.NewGeneratedImage([]byte("\x89PNG\r\n\x1a\n"), "")
+
+	tests := []struct {
+		name        string
+		user        model.SpreadsheetData
+		workspace   model.SpreadsheetData
+		gpt         *fakeGpt
+		uploadErr   error
+		wantErr     bool
+		wantMessage string
+		wantCalls   int
+		wantUsage   int // ユーザーのリクエスト数
+		wantImages  int // ユーザーとワークスペースの画像の枚数（事前の使用量からの増分）
+		wantModel   string
+	}{
+		{
+			name:        "accepted",
+			gpt:         &fakeGpt{images: []model.GeneratedImage{png}},
+			wantMessage: "<@U1> の依頼で画像を作成しました。",
+			wantCalls:   1,
+			wantUsage:   1,
+			wantImages:  1,
+			wantModel:   model.DefaultImageOptions.Model,
+		},
+		{
+			name:        "rejected by user limit",
+			user:        model.SpreadsheetData{DailyImageUsage: model.UserDailyImageLimit},
+			gpt:         &fakeGpt{},
+			wantMessage: model.UserImageLimitMessage,
+			wantModel:   "gpt-4o",
+		},
+		{
+			name:        "rejected by workspace limit",
+			workspace:   model.SpreadsheetData{DailyImageUsage: model.DailyImageLimit},
+			gpt:         &fakeGpt{},
+			wantMessage: model.ImageLimitMessage,
+			wantModel:   "gpt-4o",
+		},
+		{
+			name:        "refunded when generation fails",
+			gpt:         &fakeGpt{imageErr: errors.New("server error")},
+			wantErr:     true,
+			wantMessage: model.ImageErrorMessage,
+			wantCalls:   1,
+			wantUsage:   1,
+			wantModel:   model.DefaultImageOptions.Model,
+		},
+		{
+			name:        "not refunded when upload fails",
+			gpt:         &fakeGpt{images: []model.GeneratedImage{png}},
+			uploadErr:   errors.New("upload failed"),
+			wantErr:     true,
+			wantMessage: model.ImageErrorMessage,
+			wantCalls:   1,
+			wantUsage:   1,
+			wantImages:  1,
+			wantModel:   model.DefaultImageOptions.Model,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			usage := memory.NewUsageRepository()
+			tt.user.UserID, tt.user.Model = "U1", "gpt-4o"
+			tt.workspace.UserID, tt.workspace.Model = slack.SlackBotUserID, "gpt-4o"
+			for _, data := range []model.SpreadsheetData{tt.user, tt.workspace} {
+				if err := usage.UpdateUsage(ctx, data); err != nil {
+					t.Fatalf("UpdateUsage() error = %v", err)
+				}
+			}
+			slackRepo := &fakeSlack{uploadErr: tt.uploadErr}
+			u := NewSlackUsecase(slackRepo, tt.gpt, usage, nil, nil, nil, nil, nil, fakeAudit{})
+
+			err := u.GenerateImage(ctx, "C1", "1700000000.000100", "U1", "a cat")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GenerateImage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(slackRepo.messages) != 1 || slackRepo.messages[0] != tt.wantMessage {
+				t.Errorf("messages = %q, want %q", slackRepo.messages, tt.wantMessage)
+			}
+			if tt.gpt.calls != tt.wantCalls {
+				t.Errorf("CreateImage calls = %d, want %d", tt.gpt.calls, tt.wantCalls)
+			}
+
+			user, _ := usage.GetUsage(ctx, "U1")
+			workspace, _ := usage.GetUsage(ctx, slack.SlackBotUserID)
+			// 上限で断った依頼はリクエストとして数えず、作成しなかった画像は払い戻す
+			if user.TotalUsage != tt.wantUsage || user.Model != tt.wantModel {
+				t.Errorf("user usage = %d, model = %q, want %d and %q", user.TotalUsage, user.Model, tt.wantUsage, tt.wantModel)
+			}
+			if got := user.DailyImageUsage - tt.user.DailyImageUsage; got != tt.wantImages {
+				t.Errorf("user images = %d, want %d", got, tt.wantImages)
+			}
+			if got := workspace.DailyImageUsage - tt.workspace.DailyImageUsage; got != tt.wantImages {
+				t.Errorf("workspace images = %d, want %d", got, tt.wantImages)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+	"github.com/gs1068/slack-gpt-bot/domain/repository"
+)
+
+// personaResolver チャンネルのペルソナに、チャンネルとユーザーの設定を反映したペルソナを求める
+type personaResolver struct {
+	slack      repository.SlackRepository
+	persona    repository.PersonaRepository
+	preference repository.PreferenceRepository
+}
+
+func (r *personaResolver) resolve(ctx context.Context, channelId string, userID string) (model.Persona, error) {
+	channel, err := r.slack.GetChannelInfo(channelId)
+	if err != nil {
+		// チャンネル情報が取得できない場合はIDだけで判定する
+		log.Printf("failed r.slack.GetChannelInfo for channel %s: %v", channelId, err)
+		channel = model.ChannelInfo{ID: channelId}
+	}
+
+	persona, ruleProvider, err := r.persona.FindPersona(ctx, channel)
+	if err != nil {
+		return model.Persona{}, fmt.Errorf("failed r.persona.FindPersona: %w", err)
+	}
+
+	channelPreference, err := r.preference.GetPreference(ctx, model.PreferenceScopeChannel, channelId)
+	if err != nil {
+		return model.Persona{}, fmt.Errorf("failed r.preference.GetPreference for channel %s: %w", channelId, err)
+	}
+	userPreference, err := r.preference.GetPreference(ctx, model.PreferenceScopeUser, userID)
+	if err != nil {
+		return model.Persona{}, fmt.Errorf("failed r.preference.GetPreference for user %s: %w", userID, err)
+	}
+
+	lookup := func(name string) (model.Persona, bool) {
+		p, ok, err := r.persona.GetPersona(ctx, name)
+		if err != nil {
+			log.Printf("failed r.persona.GetPersona %s: %v", name, err)
+			return model.Persona{}, false
+		}
+		return p, ok
+	}
+	// 社外秘のチャンネルをローカルのモデルに送るなど、ルールのプロバイダーは設定でペルソナを変えても優先する
+	persona = model.ApplyPreferences(persona, lookup, channelPreference, userPreference)
+	return persona.WithProvider(ruleProvider), nil
+}
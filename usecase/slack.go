@@ -2,51 +2,105 @@ package usecase
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/gs1068/slack-gpt-bot/domain/model"
 	"github.com/gs1068/slack-gpt-bot/domain/repository"
 	"github.com/gs1068/slack-gpt-bot/infrastructure/slack"
 )
 
+// StreamingEnabled GPT応答を逐次Slackに反映するかどうか
+var StreamingEnabled = true
+
+// BlockKitReplies GPT応答をBlock Kitのブロックで投稿するかどうか。false の場合はmrkdwnのテキストで投稿する
+var BlockKitReplies = false
+
 type SlackUsecase struct {
-	slack repository.SlackRepository
-	gpt   repository.GptRepository
-	ss    repository.SpreadsheetRepository
+	slack     repository.SlackRepository
+	gpt       *auditedGpt
+	usage     repository.UsageRepository
+	dedup     repository.EventDedupRepository
+	tokenizer repository.TokenizerRepository
+	summary   repository.ThreadSummaryRepository
+	personas  *personaResolver
 }
 
 func NewSlackUsecase(
 	slack repository.SlackRepository,
 	gpt repository.GptRepository,
-	ss repository.SpreadsheetRepository,
+	usage repository.UsageRepository,
+	dedup repository.EventDedupRepository,
+	tokenizer repository.TokenizerRepository,
+	summary repository.ThreadSummaryRepository,
+	persona repository.PersonaRepository,
+	preference repository.PreferenceRepository,
+	audit repository.AuditRepository,
 ) *SlackUsecase {
 	return &SlackUsecase{
-		slack: slack,
-		gpt:   gpt,
-		ss:    ss,
+		slack:     slack,
+		gpt:       &auditedGpt{gpt: gpt, audit: audit},
+		usage:     usage,
+		dedup:     dedup,
+		tokenizer: tokenizer,
+		summary:   summary,
+		personas: &personaResolver{
+			slack:      slack,
+			persona:    persona,
+			preference: preference,
+		},
 	}
 }
 
-func (u *SlackUsecase) ProcessMessages(ctx context.Context, channelId string, timeStamp string) error {
-	currentData, err := u.ss.GetSpreadsheetDataBySlackID(ctx, slack.SlackBotUserID)
+// BeginEvent イベントの処理を開始する。処理中または処理済みのイベントの場合は false を返す
+func (u *SlackUsecase) BeginEvent(ctx context.Context, event model.SlackEvent) (bool, error) {
+	ok, err := u.dedup.TryAcquire(ctx, event.DedupKeys(), model.EventProcessingTTL)
 	if err != nil {
-		return fmt.Errorf("failed to retrieve spreadsheet data: %w", err)
+		return false, fmt.Errorf("failed u.dedup.TryAcquire: %w", err)
 	}
+	return ok, nil
+}
 
-	// データがない場合はユーザーを新規作成
-	if currentData == nil {
-		currentData = model.NewSpreadsheet(fmt.Sprint(slack.SlackBotUserID), 0, "", 0, 0, 0)
+// FinishEvent 処理結果を記録する。失敗した場合は再送されたイベントを処理できるようにする
+// 応答の一部をすでに投稿した場合（model.ErrReplyPosted）は、同じ応答を再び投稿しないよう処理済みとする
+func (u *SlackUsecase) FinishEvent(ctx context.Context, event model.SlackEvent, processErr error) error {
+	if processErr != nil && !errors.Is(processErr, model.ErrReplyPosted) {
+		if err := u.dedup.Release(ctx, event.DedupKeys()); err != nil {
+			return fmt.Errorf("failed u.dedup.Release: %w", err)
+		}
+		return nil
 	}
 
-	// 日付が変わったら使用量をリセット
-	currentData.ResetDailyUsageIfNeeded()
+	if err := u.dedup.MarkDone(ctx, event.DedupKeys(), model.EventDoneTTL); err != nil {
+		return fmt.Errorf("failed u.dedup.MarkDone: %w", err)
+	}
+	return nil
+}
+
+func (u *SlackUsecase) ProcessMessages(ctx context.Context, channelId string, timeStamp string, userID string) error {
+	// ワークスペース全体の使用量（BotのユーザーIDで管理）
+	workspaceData, err := loadUsage(ctx, u.usage, slack.SlackBotUserID)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve workspace usage: %w", err)
+	}
+
+	// リクエストしたユーザーごとの使用量
+	userData, err := loadUsage(ctx, u.usage, userID)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve user usage: %w", err)
+	}
 
 	// トークン使用可能かチェック
-	if err := currentData.CanUseDailyTokens(); err != nil {
+	if err := workspaceData.CanUseDailyTokens(); err != nil {
 		// 上限を超えた場合はメッセージを返して処理を終了
 		return u.slack.CreateNewBotMessage(channelId, timeStamp, model.LimitMessage)
 	}
+	if err := userData.CanUseUserDailyTokens(); err != nil {
+		return u.slack.CreateNewBotMessage(channelId, timeStamp, model.UserLimitMessage)
+	}
 
 	messages, err := u.slack.LoadConversationReplies(channelId, timeStamp)
 	if err != nil {
@@ -54,36 +108,240 @@ func (u *SlackUsecase) ProcessMessages(ctx context.Context, channelId string, ti
 	}
 
 	slackMessages := model.ConvertToSlackMessages(messages)
-	botUserID := slack.SlackBotUserID
-	gptPrompt := slackMessages.CreatePrompt(botUserID)
-	log.Printf("[GPTプロンプト] %s", gptPrompt)
+	botUserID, botID := slack.SlackBotUserID, slack.SlackBotID
 
-	// GPT応答を取得
-	gptResponse, err := u.gpt.CreateCompletion(ctx, gptPrompt)
+	// チャンネルに応じたペルソナ（システムプロンプト・モデル・温度）に、/gpt コマンドでの設定を反映して使う
+	persona, err := u.personas.resolve(ctx, channelId, userID)
+	if err != nil {
+		return fmt.Errorf("failed u.personas.resolve: %w", err)
+	}
+	// 発言者やメンションのIDを名前に置き換えて、誰の発言かをGPTが読み取れるようにする
+	directory := u.loadDirectory(slackMessages, botUserID, botID)
+	modelName := model.Conversation{Model: persona.Model}.ModelName()
+	counter, err := u.tokenizer.TokenCounter(modelName)
+	if err != nil {
+		return fmt.Errorf("failed u.tokenizer.TokenCounter: %w", err)
+	}
+	budget := model.ContextTokenBudget(modelName)
+	cached, err := u.summary.GetThreadSummary(ctx, channelId, timeStamp)
 	if err != nil {
-		return fmt.Errorf("failed u.gpt.CreateCompletion: %v", err)
+		// 要約を取得できなくても直近の履歴だけで応答する
+		log.Printf("failed u.summary.GetThreadSummary: %v", err)
+	}
+	newConversation := func() model.Conversation {
+		conversation := slackMessages.CreateConversation(persona.SystemPrompt, directory)
+		conversation.Provider = persona.Provider
+		conversation.Model = persona.Model
+		conversation.Temperature = persona.Temperature
+		if cached != nil {
+			conversation.Summary = cached.Summary
+		}
+		return conversation
 	}
 
-	// GPT応答をメッセージとして追加
-	var gptMessage string
-	if len(gptResponse.Choices) > 0 {
-		gptMessage = gptResponse.Choices[0].Message.Content
+	// 添付ファイルがなくても予算に収まらない古い発言は省かれるため、その添付ファイルはダウンロードしない
+	var loadAfterTS string
+	if _, dropped := newConversation().FitToBudget(counter, budget); len(dropped) > 0 {
+		loadAfterTS = dropped[len(dropped)-1].TS
+	}
+	// 添付ファイルを読み込む。画像はモデルが読み取れる場合だけダウンロードする
+	u.loadAttachments(ctx, slackMessages, modelName, botUserID, botID, loadAfterTS)
+
+	// これまでの要約があれば、その分も含めてモデルのトークン上限に収まるよう古いターンを省く
+	conversation, dropped := newConversation().FitToBudget(counter, budget)
+	// 省いた古いターンのうち、まだ要約に含まれていないものを要約に加える
+	var summaryUsage model.TokenUsage
+	if newTurns := cached.NewTurns(dropped); len(newTurns) > 0 {
+		log.Printf("%d turns dropped to fit the context budget", len(dropped))
+		summary, usage, err := u.summarizeDroppedTurns(ctx, channelId, timeStamp, userID, conversation, newTurns)
+		if err != nil {
+			// 要約できなくても直近の履歴とこれまでの要約で応答する
+			log.Printf("failed u.summarizeDroppedTurns: %v", err)
+		}
+		conversation.Summary = summary
+		summaryUsage = usage
+	}
+
+	// GPT応答を取得してSlackBot（GPT）の応答を返す
+	var usage model.TokenUsage
+	var replyErr error
+	if StreamingEnabled {
+		usage, replyErr = u.replyWithStream(ctx, channelId, timeStamp, userID, conversation)
 	} else {
-		gptMessage = "GPTレスポンスが空です。"
+		usage, replyErr = u.reply(ctx, channelId, timeStamp, userID, conversation)
+	}
+
+	// 応答を投稿できなかった場合も、GPTの呼び出しに使ったトークン数は加算する
+	// 要約は応答と別のモデルで作成することがあるため、要約に使ったモデルの料金で別に加算する
+	usageErr := errors.Join(
+		incrementUsage(ctx, u.usage, userID, summaryUsage),
+		incrementUsage(ctx, u.usage, userID, usage),
+	)
+	if replyErr != nil {
+		return errors.Join(replyErr, usageErr)
+	}
+	if usageErr != nil {
+		// 応答は投稿済みなので、再送されたイベントで再び投稿しないようにする
+		return fmt.Errorf("%w: %w", model.ErrReplyPosted, usageErr)
+	}
+
+	return nil
+}
+
+// summarizeDroppedTurns これまでの要約（conversation.Summary）に新たに省いたターンを加えた要約を返す
+// 要約できなかった場合はこれまでの要約を返す。要約の作成に使ったトークン数も返す
+func (u *SlackUsecase) summarizeDroppedTurns(ctx context.Context, channelId string, timeStamp string, userID string, conversation model.Conversation, newTurns []model.ChatMessage) (string, model.TokenUsage, error) {
+	previousSummary := conversation.Summary
+	summaryConversation := model.NewSummaryConversation(previousSummary, newTurns)
+	summaryConversation.Provider = conversation.Provider
+	summaryConversation.Model = conversation.Model
+	caller := gptCaller{kind: model.AuditKindSummary, channelID: channelId, threadTS: timeStamp, userID: userID}
+	resp, err := u.gpt.CreateCompletion(ctx, caller, summaryConversation)
+	if err != nil {
+		return previousSummary, model.TokenUsage{}, fmt.Errorf("failed u.gpt.CreateCompletion: %w", err)
+	}
+	usage := resp.TokenUsage(summaryConversation)
+	usage.Supplementary = true
+	if resp.Content == "" {
+		return previousSummary, usage, nil
 	}
 
-	// SlackBot（GPT）の応答を返す
-	err = u.slack.CreateNewBotMessage(channelId, timeStamp, gptMessage)
+	summary := model.ThreadSummary{
+		ChannelID:         channelId,
+		ThreadTS:          timeStamp,
+		Summary:           resp.Content,
+		SummarizedUntilTS: newTurns[len(newTurns)-1].TS,
+	}
+	if err := u.summary.SaveThreadSummary(ctx, summary); err != nil {
+		return summary.Summary, usage, fmt.Errorf("failed u.summary.SaveThreadSummary: %w", err)
+	}
+
+	return summary.Summary, usage, nil
+}
+
+// reply GPT応答をすべて受け取ってから投稿する。使用したトークン数は投稿に失敗した場合も返す
+func (u *SlackUsecase) reply(ctx context.Context, channelId string, timeStamp string, userID string, conversation model.Conversation) (model.TokenUsage, error) {
+	caller := gptCaller{kind: model.AuditKindReply, channelID: channelId, threadTS: timeStamp, userID: userID}
+	gptResponse, err := u.gpt.CreateCompletion(ctx, caller, conversation)
 	if err != nil {
-		return fmt.Errorf("failed u.slack.CreateNewBotMessage for channel %s, timestamp %s: %v", channelId, timeStamp, err)
+		// 再試行やフォールバックでも応答できなかったことをユーザーに伝える
+		if postErr := u.slack.CreateNewBotMessage(channelId, timeStamp, model.ErrorMessage); postErr != nil {
+			log.Printf("failed u.slack.CreateNewBotMessage: %v", postErr)
+			return model.TokenUsage{}, fmt.Errorf("failed u.gpt.CreateCompletion: %v", err)
+		}
+		return model.TokenUsage{}, fmt.Errorf("%w: failed u.gpt.CreateCompletion: %v", model.ErrReplyPosted, err)
 	}
+	usage := gptResponse.TokenUsage(conversation)
+
+	// GPT応答をメッセージとして追加
+	gptMessage := gptResponse.Content
+	if gptMessage == "" {
+		gptMessage = model.EmptyResponseMessage
+	}
+
+	err = u.postReply(ctx, channelId, timeStamp, nil, gptMessage)
+	if err != nil {
+		return usage, fmt.Errorf("failed u.postReply for channel %s, timestamp %s: %w", channelId, timeStamp, err)
+	}
+
+	return usage, nil
+}
+
+// replyWithStream 仮のメッセージを投稿し、GPT応答を受け取りながら一定間隔で更新する。使用したトークン数は投稿に失敗した場合も返す
+// 仮のメッセージを投稿した後のエラーは model.ErrReplyPosted として返す
+func (u *SlackUsecase) replyWithStream(ctx context.Context, channelId string, timeStamp string, userID string, conversation model.Conversation) (model.TokenUsage, error) {
+	caller := gptCaller{kind: model.AuditKindReply, channelID: channelId, threadTS: timeStamp, userID: userID}
+	botMessage, err := u.slack.PostPlaceholderMessage(channelId, timeStamp, model.PlaceholderMessage)
+	if err != nil {
+		return model.TokenUsage{}, fmt.Errorf("failed u.slack.PostPlaceholderMessage for channel %s, timestamp %s: %v", channelId, timeStamp, err)
+	}
+
+	var builder strings.Builder
+	var lastUpdatedAt time.Time
+	resp, err := u.gpt.CreateCompletionStream(ctx, caller, conversation, func(delta string) error {
+		builder.WriteString(delta)
 
-	// 使用量を加算
-	currentData.AddTokenUsage(gptResponse.Usage.TotalTokens)
-	err = u.ss.UpdateSpreadsheet(ctx, *currentData)
+		// chat.updateのレート制限に掛からないよう間引いて更新する
+		if time.Since(lastUpdatedAt) < model.StreamUpdateInterval {
+			return nil
+		}
+		lastUpdatedAt = time.Now()
+		// 途中の更新は閉じていないコードブロックも閉じたテキストで表示する
+		if err := u.slack.UpdateBotMessage(botMessage, model.StreamingPreview(builder.String())); err != nil {
+			// 途中の更新に失敗しても最後にまとめて更新するので続ける
+			log.Printf("failed u.slack.UpdateBotMessage: %v", err)
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed u.ss.UpdateSpreadsheet: %w", err)
+		if updateErr := u.slack.UpdateBotMessage(botMessage, model.ErrorMessage); updateErr != nil {
+			log.Printf("failed u.slack.UpdateBotMessage: %v", updateErr)
+		}
+		return model.TokenUsage{}, fmt.Errorf("%w: failed u.gpt.CreateCompletionStream: %v", model.ErrReplyPosted, err)
 	}
+	usage := resp.TokenUsage(conversation)
 
+	gptMessage := builder.String()
+	if gptMessage == "" {
+		gptMessage = model.EmptyResponseMessage
+	}
+	if err := u.postReply(ctx, channelId, timeStamp, botMessage, gptMessage); err != nil {
+		return usage, fmt.Errorf("%w: failed u.postReply for channel %s, timestamp %s: %v", model.ErrReplyPosted, channelId, timeStamp, err)
+	}
+
+	return usage, nil
+}
+
+// postReply GPT応答をスレッドに投稿する。botMessage がある場合は最初の部分でそのメッセージを更新する
+// 長い応答は段落やコードブロックの区切りで複数のメッセージに分けて順に投稿し、ReplySnippetLength を超える場合はファイルとして投稿する
+// 途中のメッセージで失敗した場合は、それまでの部分を投稿済みのため model.ErrReplyPosted として返す
+func (u *SlackUsecase) postReply(ctx context.Context, channelId string, timeStamp string, botMessage *model.BotMessage, gptMessage string) error {
+	if model.IsSnippetReply(gptMessage) {
+		err := u.postSnippet(ctx, channelId, timeStamp, botMessage, gptMessage)
+		if err == nil {
+			return nil
+		}
+		// アップロードできない場合はメッセージに分けて投稿する
+		log.Printf("failed u.postSnippet: %v", err)
+	}
+
+	replies := model.NewBotReplies(gptMessage, BlockKitReplies)
+	for i, reply := range replies {
+		if i == 0 && botMessage != nil {
+			if err := u.slack.UpdateBotReply(botMessage, reply); err != nil {
+				return fmt.Errorf("failed u.slack.UpdateBotReply (%d/%d): %w", i+1, len(replies), err)
+			}
+			continue
+		}
+		if err := u.slack.PostBotReply(channelId, timeStamp, reply); err != nil {
+			if i > 0 {
+				return fmt.Errorf("%w: failed u.slack.PostBotReply (%d/%d): %v", model.ErrReplyPosted, i+1, len(replies), err)
+			}
+			return fmt.Errorf("failed u.slack.PostBotReply (%d/%d): %w", i+1, len(replies), err)
+		}
+	}
+	return nil
+}
+
+// postSnippet GPT応答をファイルとしてスレッドにアップロードする
+func (u *SlackUsecase) postSnippet(ctx context.Context, channelId string, timeStamp string, botMessage *model.BotMessage, gptMessage string) error {
+	file := model.SlackFile{
+		Filename: model.SnippetFilename,
+		Title:    model.SnippetTitle,
+		Content:  []byte(gptMessage),
+	}
+	if botMessage == nil {
+		file.Comment = model.SnippetReplyMessage
+	}
+	if err := u.slack.UploadFile(ctx, channelId, timeStamp, file); err != nil {
+		return fmt.Errorf("failed u.slack.UploadFile: %w", err)
+	}
+
+	if botMessage != nil {
+		// アップロードは済んでいるので、仮のメッセージを更新できなくても応答は失敗としない
+		if err := u.slack.UpdateBotMessage(botMessage, model.SnippetReplyMessage); err != nil {
+			log.Printf("failed u.slack.UpdateBotMessage: %v", err)
+		}
+	}
 	return nil
 }
@@ -0,0 +1,42 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gs1068/slack-gpt-bot/domain/model"
+	"github.com/gs1068/slack-gpt-bot/domain/repository"
+	"github.com/gs1068/slack-gpt-bot/infrastructure/slack"
+)
+
+// loadUsage 指定IDの使用量を取得する。データがない場合は新規作成し、日付が変わっていれば日次の使用量をリセットする
+func loadUsage(ctx context.Context, usage repository.UsageRepository, id string) (*model.SpreadsheetData, error) {
+	data, err := usage.GetUsage(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed usage.GetUsage: %w", err)
+	}
+
+	// データがない場合はユーザーを新規作成
+	if data == nil {
+		data = model.NewSpreadsheet(id, 0, "", 0, 0, 0)
+	}
+
+	// 日付が変わったら使用量をリセット
+	data.ResetDailyUsageIfNeeded()
+
+	return data, nil
+}
+
+// incrementUsage ユーザーとワークスペース全体の使用量に加算する。加算するものがない場合は何もしない
+func incrementUsage(ctx context.Context, usage repository.UsageRepository, userID string, tokenUsage model.TokenUsage) error {
+	if tokenUsage.IsZero() {
+		return nil
+	}
+	if _, err := usage.IncrementUsage(ctx, userID, tokenUsage); err != nil {
+		return fmt.Errorf("failed usage.IncrementUsage for user %s: %w", userID, err)
+	}
+	if _, err := usage.IncrementUsage(ctx, slack.SlackBotUserID, tokenUsage); err != nil {
+		return fmt.Errorf("failed usage.IncrementUsage for workspace: %w", err)
+	}
+	return nil
+}